@@ -25,6 +25,8 @@ import (
 	lru "github.com/hashicorp/golang-lru"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
 )
 
 var (
@@ -38,12 +40,17 @@ var _ ethdb.Batch = &Batch{}
 // If blockservice block exchange is configured the blockservice can fetch data that are missing locally from IPFS peers
 type Batch struct {
 	blockService          blockservice.BlockService
+	codec                 keycodec.KeyCodec
 	putCache, deleteCache *lru.Cache
 	valueSize             int
 }
 
 // NewBatch returns a ethdb.Batch interface for IPFS
 func NewBatch(bs blockservice.BlockService, capacity int) (ethdb.Batch, error) {
+	return newBatch(bs, DefaultKeyCodec, capacity)
+}
+
+func newBatch(bs blockservice.BlockService, codec keycodec.KeyCodec, capacity int) (*Batch, error) {
 	putCache, err := lru.New(capacity)
 	if err != nil {
 		return nil, err
@@ -54,6 +61,7 @@ func NewBatch(bs blockservice.BlockService, capacity int) (ethdb.Batch, error) {
 	}
 	return &Batch{
 		blockService: bs,
+		codec:        codec,
 		putCache:     putCache,
 		deleteCache:  deleteCache,
 	}, nil
@@ -98,7 +106,7 @@ func (b *Batch) Write() error {
 	puts := make([]blocks.Block, b.putCache.Len())
 	for i, key := range b.putCache.Keys() {
 		val, _ := b.putCache.Get(key) // don't need to check "ok"s, the key is known and val is always []byte
-		b, err := NewBlock(common.Hex2Bytes(key.(string)), val.([]byte))
+		b, err := newBlock(b.codec, common.Hex2Bytes(key.(string)), val.([]byte))
 		if err != nil {
 			return err
 		}
@@ -108,8 +116,7 @@ func (b *Batch) Write() error {
 		return err
 	}
 	for _, key := range b.deleteCache.Keys() {
-		// we are using state codec because we don't know the codec and at this level the codec doesn't matter, the datastore key is multihash-only derived
-		c, err := Keccak256ToCid(common.Hex2Bytes(key.(string)), stateTrieCodec)
+		c, err := b.codec.CID(common.Hex2Bytes(key.(string)))
 		if err != nil {
 			return err
 		}
@@ -20,16 +20,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
 )
 
 var (
-	stateTrieCodec       uint64 = 0x96
-	defaultBatchCapacity        = 1024
-	errNotSupported             = errors.New("this operation is not supported")
+	// DefaultKeyCodec converts the keccak256 hash keys into CIDs
+	// We are using the state codec because we don't know the codec and at this level the codec doesn't matter,
+	// the datastore key is multihash-only derived
+	DefaultKeyCodec keycodec.KeyCodec = keycodec.Multihash{Codec: cid.EthStateTrie}
+
+	defaultBatchCapacity = 1024
+	errNotSupported      = errors.New("this operation is not supported")
 )
 
 var _ ethdb.Database = &Database{}
@@ -39,19 +47,35 @@ var _ ethdb.Database = &Database{}
 // If blockservice block exchange is configured the blockservice can fetch data that are missing locally from IPFS peers
 type Database struct {
 	blockService blockservice.BlockService
+	codec        keycodec.KeyCodec
+	readOnly     bool
 }
 
 // NewKeyValueStore returns a ethdb.KeyValueStore interface for IPFS
 func NewKeyValueStore(bs blockservice.BlockService) ethdb.KeyValueStore {
+	return NewDatabaseWithKeyCodec(bs, DefaultKeyCodec)
+}
+
+// NewDatabase returns a ethdb.Database interface for IPFS
+func NewDatabase(bs blockservice.BlockService) ethdb.Database {
+	return NewDatabaseWithKeyCodec(bs, DefaultKeyCodec)
+}
+
+// NewDatabaseWithKeyCodec returns a ethdb.Database interface for IPFS that addresses blocks by the CIDs of the KeyCodec
+func NewDatabaseWithKeyCodec(bs blockservice.BlockService, codec keycodec.KeyCodec) *Database {
 	return &Database{
 		blockService: bs,
+		codec:        codec,
 	}
 }
 
-// NewDatabase returns a ethdb.Database interface for IPFS
-func NewDatabase(bs blockservice.BlockService) ethdb.Database {
+// NewReadOnlyDatabase returns a Database for IPFS that returns ErrReadOnly from all its writers, including its batches
+// Blocks can still be fetched through the blockservice's exchange
+func NewReadOnlyDatabase(bs blockservice.BlockService, codec keycodec.KeyCodec) *Database {
 	return &Database{
 		blockService: bs,
+		codec:        codec,
+		readOnly:     true,
 	}
 }
 
@@ -63,8 +87,7 @@ func (d *Database) ModifyAncients(f func(ethdb.AncientWriteOp) error) (int64, er
 // Has retrieves if a key is present in the key-value data store
 // This only operates on the local blockstore not through the exchange
 func (d *Database) Has(key []byte) (bool, error) {
-	// we are using state codec because we don't know the codec and at this level the codec doesn't matter, the datastore key is multihash-only derived
-	c, err := Keccak256ToCid(key, stateTrieCodec)
+	c, err := d.codec.CID(key)
 	if err != nil {
 		return false, err
 	}
@@ -74,8 +97,7 @@ func (d *Database) Has(key []byte) (bool, error) {
 // Get satisfies the ethdb.KeyValueReader interface
 // Get retrieves the given key if it's present in the key-value data store
 func (d *Database) Get(key []byte) ([]byte, error) {
-	// we are using state codec because we don't know the codec and at this level the codec doesn't matter, the datastore key is multihash-only derived
-	c, err := Keccak256ToCid(key, stateTrieCodec)
+	c, err := d.codec.CID(key)
 	if err != nil {
 		return nil, err
 	}
@@ -86,11 +108,38 @@ func (d *Database) Get(key []byte) ([]byte, error) {
 	return block.RawData(), nil
 }
 
+// GetMany retrieves the values for the given keys using the blockservice's GetBlocks
+// The returned values are in the same order as the keys, with a nil entry for each key that could not be found
+func (d *Database) GetMany(keys [][]byte) ([][]byte, error) {
+	cids := make([]cid.Cid, len(keys))
+	for i, key := range keys {
+		c, err := d.codec.CID(key)
+		if err != nil {
+			return nil, err
+		}
+		cids[i] = c
+	}
+
+	found := make(map[string][]byte, len(keys))
+	for block := range d.blockService.GetBlocks(context.Background(), cids) {
+		found[string(block.Cid().Hash())] = block.RawData()
+	}
+
+	values := make([][]byte, len(keys))
+	for i, c := range cids {
+		values[i] = found[string(c.Hash())]
+	}
+	return values, nil
+}
+
 // Put satisfies the ethdb.KeyValueWriter interface
 // Put inserts the given value into the key-value data store
 // Key is expected to be the keccak256 hash of value
 func (d *Database) Put(key []byte, value []byte) error {
-	b, err := NewBlock(key, value)
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	b, err := newBlock(d.codec, key, value)
 	if err != nil {
 		return err
 	}
@@ -100,8 +149,10 @@ func (d *Database) Put(key []byte, value []byte) error {
 // Delete satisfies the ethdb.KeyValueWriter interface
 // Delete removes the key from the key-value data store
 func (d *Database) Delete(key []byte) error {
-	// we are using state codec because we don't know the codec and at this level the codec doesn't matter, the datastore key is multihash-only derived
-	c, err := Keccak256ToCid(key, stateTrieCodec)
+	if d.readOnly {
+		return ErrReadOnly
+	}
+	c, err := d.codec.CID(key)
 	if err != nil {
 		return err
 	}
@@ -114,6 +165,7 @@ type DatabaseProperty int
 const (
 	Unknown DatabaseProperty = iota
 	ExchangeOnline
+	ReadOnlyMode
 )
 
 // DatabasePropertyFromString helper function
@@ -121,6 +173,8 @@ func DatabasePropertyFromString(property string) (DatabaseProperty, error) {
 	switch strings.ToLower(property) {
 	case "exchange", "online":
 		return ExchangeOnline, nil
+	case ReadOnlyProperty:
+		return ReadOnlyMode, nil
 	default:
 		return Unknown, fmt.Errorf("unknown database property")
 	}
@@ -134,6 +188,8 @@ func (d *Database) Stat(property string) (string, error) {
 		return "", err
 	}
 	switch prop {
+	case ReadOnlyMode:
+		return strconv.FormatBool(d.readOnly), nil
 	default:
 		return "", fmt.Errorf("unhandled database property")
 	}
@@ -149,7 +205,10 @@ func (d *Database) Compact(start []byte, limit []byte) error {
 // NewBatch creates a write-only database that buffers changes to its host db
 // until a final write is called
 func (d *Database) NewBatch() ethdb.Batch {
-	b, err := NewBatch(d.blockService, defaultBatchCapacity)
+	if d.readOnly {
+		return ReadOnlyBatch{}
+	}
+	b, err := newBatch(d.blockService, d.codec, defaultBatchCapacity)
 	if err != nil {
 		panic(err)
 	}
@@ -159,7 +218,10 @@ func (d *Database) NewBatch() ethdb.Batch {
 // NewBatchWithSize satisfies the ethdb.Batcher interface.
 // NewBatchWithSize creates a write-only database batch with pre-allocated buffer.
 func (d *Database) NewBatchWithSize(size int) ethdb.Batch {
-	b, err := NewBatch(d.blockService, size)
+	if d.readOnly {
+		return ReadOnlyBatch{}
+	}
+	b, err := newBatch(d.blockService, d.codec, size)
 	if err != nil {
 		panic(err)
 	}
@@ -174,7 +236,7 @@ func (d *Database) NewBatchWithSize(size int) ethdb.Batch {
 // Note: This method assumes that the prefix is NOT part of the start, so there's
 // no need for the caller to prepend the prefix to the start
 func (d *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
-	return NewIterator(start, prefix, d.blockService)
+	return newIterator(start, prefix, d.blockService, d.codec)
 }
 
 // Close satisfies the io.Closer interface
@@ -74,6 +74,19 @@ var _ = Describe("Database", func() {
 		})
 	})
 
+	Describe("GetMany", func() {
+		It("returns the values in request order, with nil for keys that don't exist in the db", func() {
+			err := database.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			missingKey := (&types.Header{Number: big.NewInt(1)}).Hash().Bytes()
+			vals, err := database.(*ipfsethdb.Database).GetMany([][]byte{missingKey, testEthKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vals).To(HaveLen(2))
+			Expect(vals[0]).To(BeNil())
+			Expect(vals[1]).To(Equal(testValue))
+		})
+	})
+
 	Describe("Put", func() {
 		It("persists the key-value pair in the database", func() {
 			_, err = database.Get(testEthKey)
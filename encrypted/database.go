@@ -0,0 +1,196 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package encrypted
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+)
+
+// Config holds the settings of an encrypted KeyValueStore
+type Config struct {
+	// AllowPlaintext returns the values that aren't sealed as they are
+	// It is meant for stores that hold unencrypted values written before encryption was enabled,
+	// until a key rotation has sealed them; values sealed with a key the provider doesn't have still fail with ErrUnknownKey
+	AllowPlaintext bool
+}
+
+var _ ethdb.KeyValueStore = &KeyValueStore{}
+
+// KeyValueStore is an ethdb.KeyValueStore that wraps another ethdb.KeyValueStore and seals the values it writes with AES-GCM
+// Keys are passed through unchanged, so blocks stay addressable by their keccak256 hashes in the underlying store,
+// while their values are only readable with the keys of the KeyProvider, and only under the key they were put with
+// Sealed values don't hash to their keys, so the underlying store must not verify them: Postgres ethdbs with
+// Config.Verify, and IPFS blockstores, which check blocks against their CIDs when hashing on read or exchanging them
+// with peers, reject them
+// Use rawdb.NewDatabase to build an ethdb.Database (e.g. for trie.NewDatabase) around it
+type KeyValueStore struct {
+	ethdb.KeyValueStore
+	sealer *Sealer
+	config Config
+}
+
+// NewKeyValueStore returns an encrypting ethdb.KeyValueStore wrapping the provided one
+func NewKeyValueStore(kvs ethdb.KeyValueStore, provider KeyProvider, config Config) (*KeyValueStore, error) {
+	sealer, err := NewSealer(provider)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyValueStore{KeyValueStore: kvs, sealer: sealer, config: config}, nil
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key from the underlying store and opens its value
+func (e *KeyValueStore) Get(key []byte) ([]byte, error) {
+	sealed, err := e.KeyValueStore.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(key, sealed)
+}
+
+// GetMany retrieves the values for the given keys, in a single round trip if the underlying store is an
+// ipfsethdb.MultiGetter, with a nil value for each missing key
+func (e *KeyValueStore) GetMany(keys [][]byte) ([][]byte, error) {
+	values, err := ipfsethdb.GetMany(e.KeyValueStore, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, sealed := range values {
+		if sealed == nil {
+			continue
+		}
+		value, err := e.open(keys[i], sealed)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put seals the value and inserts it into the underlying store
+func (e *KeyValueStore) Put(key []byte, value []byte) error {
+	sealed, err := e.sealer.Seal(key, value)
+	if err != nil {
+		return err
+	}
+	return e.KeyValueStore.Put(key, sealed)
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a batch over the underlying store that seals the values put in it
+func (e *KeyValueStore) NewBatch() ethdb.Batch {
+	return &batch{Batch: e.KeyValueStore.NewBatch(), store: e}
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface
+// NewBatchWithSize creates a batch over the underlying store with a pre-allocated buffer
+func (e *KeyValueStore) NewBatchWithSize(size int) ethdb.Batch {
+	return &batch{Batch: e.KeyValueStore.NewBatchWithSize(size), store: e}
+}
+
+// NewIterator satisfies the ethdb.Iteratee interface
+// NewIterator creates an iterator over the underlying store that opens the values it returns
+func (e *KeyValueStore) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	return &iterator{Iterator: e.KeyValueStore.NewIterator(prefix, start), store: e}
+}
+
+func (e *KeyValueStore) open(key, sealed []byte) ([]byte, error) {
+	value, err := e.sealer.Open(key, sealed)
+	if err != nil && e.config.AllowPlaintext && errors.Is(err, ErrNotSealed) {
+		return sealed, nil
+	}
+	return value, err
+}
+
+// batch wraps the underlying store's batch so that the values put in it are sealed
+type batch struct {
+	ethdb.Batch
+	store *KeyValueStore
+}
+
+// Put satisfies the ethdb.Batch interface
+// Put inserts the sealed value into the batch for later committing
+func (b *batch) Put(key []byte, value []byte) error {
+	sealed, err := b.store.sealer.Seal(key, value)
+	if err != nil {
+		return err
+	}
+	return b.Batch.Put(key, sealed)
+}
+
+// Replay satisfies the ethdb.Batch interface
+// Replay replays the batch contents, with the values opened, to the writer
+func (b *batch) Replay(w ethdb.KeyValueWriter) error {
+	return b.Batch.Replay(&opener{KeyValueWriter: w, store: b.store})
+}
+
+// opener opens the values replayed to a writer
+type opener struct {
+	ethdb.KeyValueWriter
+	store *KeyValueStore
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (o *opener) Put(key []byte, sealed []byte) error {
+	value, err := o.store.open(key, sealed)
+	if err != nil {
+		return err
+	}
+	return o.KeyValueWriter.Put(key, value)
+}
+
+// iterator wraps the underlying store's iterator so that the values it returns are opened
+type iterator struct {
+	ethdb.Iterator
+	store *KeyValueStore
+	value []byte
+	err   error
+}
+
+// Next satisfies the ethdb.Iterator interface
+// Next moves the iterator to the next key/value pair and opens its value, stopping at a value that can't be opened
+func (i *iterator) Next() bool {
+	i.value = nil
+	if i.err != nil || !i.Iterator.Next() {
+		return false
+	}
+	if i.value, i.err = i.store.open(i.Iterator.Key(), i.Iterator.Value()); i.err != nil {
+		return false
+	}
+	return true
+}
+
+// Error satisfies the ethdb.Iterator interface
+// Error returns any accumulated error
+func (i *iterator) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.Iterator.Error()
+}
+
+// Value satisfies the ethdb.Iterator interface
+// Value returns the opened value of the current key/value pair, or nil if done
+func (i *iterator) Value() []byte {
+	return i.value
+}
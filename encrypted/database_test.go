@@ -0,0 +1,174 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package encrypted_test
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/encrypted"
+)
+
+var _ = Describe("KeyValueStore", func() {
+	var (
+		memdb  *memorydb.Database
+		ring   *encrypted.KeyRing
+		kvs    *encrypted.KeyValueStore
+		err    error
+		value  = []byte("mockValue")
+		key    = crypto.Keccak256(value)
+		keyOne = bytes.Repeat([]byte{1}, 32)
+		keyTwo = bytes.Repeat([]byte{2}, 16)
+	)
+
+	BeforeEach(func() {
+		memdb = memorydb.New()
+		ring, err = encrypted.NewKeyRing(1, map[uint32][]byte{1: keyOne})
+		Expect(err).ToNot(HaveOccurred())
+		kvs, err = encrypted.NewKeyValueStore(memdb, ring, encrypted.Config{})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("seals values under their keccak256 keys", func() {
+		Expect(kvs.Put(key, value)).To(Succeed())
+		sealed, err := memdb.Get(key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sealed).ToNot(ContainSubstring(string(value)))
+		id, err := encrypted.KeyID(sealed)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal(uint32(1)))
+
+		got, err := kvs.Get(key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(Equal(value))
+		has, err := kvs.Has(key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+	})
+
+	It("opens values sealed with earlier keys after a rotation", func() {
+		Expect(kvs.Put(key, value)).To(Succeed())
+		Expect(ring.Add(2, keyTwo)).To(Succeed())
+		Expect(ring.SetCurrent(2)).To(Succeed())
+		otherKey := crypto.Keccak256([]byte("other"))
+		Expect(kvs.Put(otherKey, []byte("other"))).To(Succeed())
+
+		values, err := kvs.GetMany([][]byte{key, otherKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{value, []byte("other")}))
+
+		sealer, err := encrypted.NewSealer(ring)
+		Expect(err).ToNot(HaveOccurred())
+		sealed, _ := memdb.Get(key)
+		resealed, changed, err := sealer.Reseal(key, sealed)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(encrypted.KeyID(resealed)).To(Equal(uint32(2)))
+		_, changed, err = sealer.Reseal(key, resealed)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+	})
+
+	It("rejects values it can't open", func() {
+		Expect(memdb.Put(key, value)).To(Succeed())
+		_, err := kvs.Get(key)
+		Expect(err).To(MatchError(encrypted.ErrNotSealed))
+
+		Expect(kvs.Put(key, value)).To(Succeed())
+		sealed, _ := memdb.Get(key)
+		sealed[len(sealed)-1] ^= 0xff
+		Expect(memdb.Put(key, sealed)).To(Succeed())
+		_, err = kvs.Get(key)
+		Expect(err).To(HaveOccurred())
+
+		otherRing, err := encrypted.NewKeyRing(2, map[uint32][]byte{2: keyTwo})
+		Expect(err).ToNot(HaveOccurred())
+		other, err := encrypted.NewKeyValueStore(memdb, otherRing, encrypted.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = other.Get(key)
+		Expect(err).To(MatchError(encrypted.ErrUnknownKey))
+	})
+
+	It("only opens values under the key they were put with", func() {
+		otherKey := crypto.Keccak256([]byte("other"))
+		Expect(kvs.Put(key, value)).To(Succeed())
+		Expect(kvs.Put(otherKey, []byte("other"))).To(Succeed())
+		sealed, _ := memdb.Get(key)
+		Expect(memdb.Put(otherKey, sealed)).To(Succeed())
+		_, err := kvs.Get(otherKey)
+		Expect(err).To(HaveOccurred())
+
+		sealer, err := encrypted.NewSealer(ring)
+		Expect(err).ToNot(HaveOccurred())
+		_, _, err = sealer.Reseal(otherKey, sealed)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns plaintext values as they are if allowed", func() {
+		Expect(memdb.Put(key, value)).To(Succeed())
+		kvs, err = encrypted.NewKeyValueStore(memdb, ring, encrypted.Config{AllowPlaintext: true})
+		Expect(err).ToNot(HaveOccurred())
+		got, err := kvs.Get(key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(Equal(value))
+
+		// values sealed with a key the provider doesn't have are not mistaken for plaintext
+		otherRing, err := encrypted.NewKeyRing(2, map[uint32][]byte{2: keyTwo})
+		Expect(err).ToNot(HaveOccurred())
+		other, err := encrypted.NewKeyValueStore(memdb, otherRing, encrypted.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(other.Put(key, value)).To(Succeed())
+		_, err = kvs.Get(key)
+		Expect(err).To(MatchError(encrypted.ErrUnknownKey))
+	})
+
+	It("seals the values put in batches, and opens them when iterating and replaying", func() {
+		batch := kvs.NewBatch()
+		for i := byte(0); i < 3; i++ {
+			Expect(batch.Put([]byte{i}, []byte{i, i})).To(Succeed())
+		}
+		replayed := memorydb.New()
+		Expect(batch.Replay(replayed)).To(Succeed())
+		Expect(replayed.Get([]byte{1})).To(Equal([]byte{1, 1}))
+		Expect(batch.Write()).To(Succeed())
+		sealed, _ := memdb.Get([]byte{1})
+		Expect(encrypted.KeyID(sealed)).To(Equal(uint32(1)))
+
+		it := kvs.NewIterator(nil, nil)
+		defer it.Release()
+		var n byte
+		for it.Next() {
+			Expect(it.Key()).To(Equal([]byte{n}))
+			Expect(it.Value()).To(Equal([]byte{n, n}))
+			n++
+		}
+		Expect(it.Error()).ToNot(HaveOccurred())
+		Expect(n).To(Equal(byte(3)))
+	})
+
+	It("validates the keys of a KeyRing", func() {
+		_, err := encrypted.NewKeyRing(1, map[uint32][]byte{1: []byte("short")})
+		Expect(err).To(HaveOccurred())
+		_, err = encrypted.NewKeyRing(2, map[uint32][]byte{1: keyOne})
+		Expect(err).To(MatchError(encrypted.ErrUnknownKey))
+		Expect(ring.Add(1, keyTwo)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,254 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package encrypted seals the values of an ethdb.KeyValueStore with AES-GCM before they reach the underlying store
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// sealVersion is the first byte of a sealed value
+	// 0xfc starts an RLP list with a 5 byte length, so it doesn't collide with the trie nodes and other RLP values
+	// that are stored, nor with the compression markers of the Postgres ethdbs
+	sealVersion byte = 0xfc
+	// headerLen is the length of the version byte and key ID that precede the nonce, the header is authenticated
+	headerLen = 1 + 4
+)
+
+var (
+	// ErrNotSealed is returned when opening a value that wasn't sealed by a Sealer
+	ErrNotSealed = errors.New("value is not sealed")
+	// ErrUnknownKey is returned when a KeyProvider doesn't have the key a value was sealed with
+	ErrUnknownKey = errors.New("unknown encryption key")
+
+	errInvalidKey = errors.New("encryption keys must be 16, 24 or 32 bytes long")
+)
+
+// KeyProvider supplies the AES keys values are sealed with
+// Each key is identified by an ID that is stored with the values it seals, so that keys can be rotated:
+// new values are sealed with the current key, while values sealed with earlier keys can still be opened
+// The key material for an ID must never change
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key new values are sealed with
+	CurrentKey() (uint32, []byte, error)
+	// Key returns the key with the given ID, or ErrUnknownKey
+	Key(id uint32) ([]byte, error)
+}
+
+var _ KeyProvider = &KeyRing{}
+
+// KeyRing is a KeyProvider holding its keys in memory
+type KeyRing struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewKeyRing returns a KeyRing sealing with the key of the current ID
+func NewKeyRing(current uint32, keys map[uint32][]byte) (*KeyRing, error) {
+	r := &KeyRing{keys: make(map[uint32][]byte, len(keys))}
+	for id, key := range keys {
+		if err := r.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.SetCurrent(current); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Add adds a key to the ring, it doesn't change the current key
+func (r *KeyRing) Add(id uint32, key []byte) error {
+	if !validKeyLen(len(key)) {
+		return errInvalidKey
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.keys[id]; ok && string(existing) != string(key) {
+		return fmt.Errorf("encryption key %d already exists", id)
+	}
+	r.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// SetCurrent makes the key with the given ID the one new values are sealed with
+func (r *KeyRing) SetCurrent(id uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[id]; !ok {
+		return fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	r.current = id
+	return nil
+}
+
+// CurrentKey satisfies the KeyProvider interface
+func (r *KeyRing) CurrentKey() (uint32, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current, r.keys[r.current], nil
+}
+
+// Key satisfies the KeyProvider interface
+func (r *KeyRing) Key(id uint32) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// Sealer seals and opens values with the keys of a KeyProvider
+// A sealed value is laid out as the version byte, the big endian key ID, the GCM nonce and the ciphertext,
+// with the version byte, key ID and the ethdb key the value is stored under authenticated as additional data,
+// so a value only opens under the key it was sealed for and rows can't be swapped in the underlying store
+type Sealer struct {
+	provider KeyProvider
+
+	mu    sync.RWMutex
+	aeads map[uint32]cipher.AEAD
+}
+
+// NewSealer returns a Sealer using the keys of the provider
+func NewSealer(provider KeyProvider) (*Sealer, error) {
+	if provider == nil {
+		return nil, errors.New("a KeyProvider is required")
+	}
+	return &Sealer{provider: provider, aeads: make(map[uint32]cipher.AEAD)}, nil
+}
+
+// Seal returns the value to store under the key, sealed with the provider's current key
+func (s *Sealer) Seal(key, value []byte) ([]byte, error) {
+	id, aesKey, err := s.provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(id, aesKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, headerLen+aead.NonceSize(), headerLen+aead.NonceSize()+len(value)+aead.Overhead())
+	sealed[0] = sealVersion
+	binary.BigEndian.PutUint32(sealed[1:headerLen], id)
+	if _, err := rand.Read(sealed[headerLen:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[headerLen:], value, additionalData(sealed, key)), nil
+}
+
+// Open returns the value that was sealed for the key
+// ErrNotSealed is returned for values that don't have the layout of a sealed value,
+// and ErrUnknownKey if the provider doesn't have the key the value was sealed with
+func (s *Sealer) Open(key, sealed []byte) ([]byte, error) {
+	id, err := KeyID(sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < headerLen+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrNotSealed
+	}
+	nonce, ciphertext := sealed[headerLen:headerLen+aead.NonceSize()], sealed[headerLen+aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, additionalData(sealed, key))
+	if err != nil {
+		return nil, fmt.Errorf("opening value sealed with key %d: %w", id, err)
+	}
+	return value, nil
+}
+
+// Reseal returns the value sealed for the key with the provider's current key, and whether it had been sealed with another key
+// A value already sealed with the current key is returned as it is
+func (s *Sealer) Reseal(key, sealed []byte) ([]byte, bool, error) {
+	id, err := KeyID(sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	current, _, err := s.provider.CurrentKey()
+	if err != nil {
+		return nil, false, err
+	}
+	value, err := s.Open(key, sealed)
+	if err != nil {
+		return nil, false, err
+	}
+	if id == current {
+		return sealed, false, nil
+	}
+	resealed, err := s.Seal(key, value)
+	return resealed, err == nil, err
+}
+
+// KeyID returns the ID of the key a value was sealed with
+func KeyID(sealed []byte) (uint32, error) {
+	if len(sealed) < headerLen || sealed[0] != sealVersion {
+		return 0, ErrNotSealed
+	}
+	return binary.BigEndian.Uint32(sealed[1:headerLen]), nil
+}
+
+// additionalData returns the header of the sealed value followed by the ethdb key it is stored under
+func additionalData(sealed, key []byte) []byte {
+	ad := make([]byte, 0, headerLen+len(key))
+	return append(append(ad, sealed[:headerLen]...), key...)
+}
+
+// aead returns the cipher for the key ID, creating it from the key, or the provider's key for the ID if nil
+func (s *Sealer) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	s.mu.RLock()
+	aead, ok := s.aeads[id]
+	s.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+	if key == nil {
+		var err error
+		if key, err = s.provider.Key(id); err != nil {
+			return nil, err
+		}
+	}
+	if !validKeyLen(len(key)) {
+		return nil, errInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.aeads[id] = aead
+	s.mu.Unlock()
+	return aead, nil
+}
+
+func validKeyLen(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
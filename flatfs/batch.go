@@ -0,0 +1,147 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package flatfsethdb
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+var _ ethdb.Batch = &Batch{}
+
+// Batch is the type that satisfies the ethdb.Batch interface for IPFS Ethereum data stored in a directory tree
+// The operations are buffered until Write, which groups the fsyncs: the values are all written and synced to temporary
+// files before any is renamed into place, and each directory touched is then synced once
+type Batch struct {
+	db        *Database
+	ops       []batchOp
+	valueSize int
+}
+
+type batchOp struct {
+	key, value []byte
+	delete     bool
+}
+
+// Put satisfies the ethdb.Batch interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value
+func (b *Batch) Put(key []byte, value []byte) error {
+	b.ops = append(b.ops, batchOp{key: common.CopyBytes(key), value: common.CopyBytes(value)})
+	b.valueSize += len(value)
+	return nil
+}
+
+// Delete satisfies the ethdb.Batch interface
+// Delete removes the key from the key-value data store
+func (b *Batch) Delete(key []byte) error {
+	b.ops = append(b.ops, batchOp{key: common.CopyBytes(key), delete: true})
+	return nil
+}
+
+// ValueSize satisfies the ethdb.Batch interface
+// ValueSize retrieves the amount of data queued up for writing
+// The returned value is the total byte length of all data queued to write
+func (b *Batch) ValueSize() int {
+	return b.valueSize
+}
+
+// Write satisfies the ethdb.Batch interface
+// Write flushes any accumulated data to disk
+// Only the last operation on each key is applied
+func (b *Batch) Write() error {
+	last := make(map[string]int, len(b.ops))
+	for i, op := range b.ops {
+		last[string(op.key)] = i
+	}
+	type rename struct{ tmp, path string }
+	var renames []rename
+	var deletes []string
+	cleanup := func() {
+		for _, r := range renames {
+			os.Remove(r.tmp)
+		}
+	}
+	for i, op := range b.ops {
+		if last[string(op.key)] != i {
+			continue
+		}
+		p, err := b.db.path(op.key)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		if op.delete {
+			deletes = append(deletes, p)
+			continue
+		}
+		tmp, err := b.db.writeTemp(p, op.value)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		renames = append(renames, rename{tmp: tmp, path: p})
+	}
+
+	dirs := make(map[string]struct{})
+	for i, r := range renames {
+		if err := os.Rename(r.tmp, r.path); err != nil {
+			for _, r := range renames[i:] {
+				os.Remove(r.tmp)
+			}
+			return err
+		}
+		dirs[filepath.Dir(r.path)] = struct{}{}
+	}
+	for _, p := range deletes {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		dirs[filepath.Dir(p)] = struct{}{}
+	}
+	return b.db.syncDirs(dirs)
+}
+
+// Replay satisfies the ethdb.Batch interface
+// Replay replays the batch contents
+func (b *Batch) Replay(w ethdb.KeyValueWriter) error {
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = w.Delete(op.key)
+		} else {
+			err = w.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset satisfies the ethdb.Batch interface
+// Reset resets the batch for reuse
+// This should be called after every write
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+	b.valueSize = 0
+}
@@ -0,0 +1,431 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package flatfsethdb is the ethdb over a directory tree with a file per value, named by the multihash db key of
+// postgres/v1, so that it can be read by other tools without this library
+//
+// A value stored under the db key "/blocks/CIQ...XYZ" is in the file <root>/YZ/CIQ...XYZ.data, sharded by the two
+// characters before the last one, the layout of go-ds-flatfs's next-to-last/2 shard function, which is recorded in the
+// tree's SHARDING file
+package flatfsethdb
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+)
+
+const (
+	// Extension is the extension of the value files
+	Extension = ".data"
+	// ShardingFile is the file in the root directory that records the shard function, as go-ds-flatfs expects
+	ShardingFile = "SHARDING"
+	// shardFunc is the id of go-ds-flatfs's next-to-last/2 shard function
+	shardFunc = "/repo/flatfs/shard/v1/next-to-last/2"
+)
+
+var (
+	errNotSupported = errors.New("this operation is not supported")
+	errNoPath       = errors.New("the root directory must be set")
+
+	// KeyCodec converts the keccak256 hash keys into multihash db keys, the keys of postgres/v1
+	KeyCodec keycodec.KeyCodec = keycodec.Multihash{Codec: cid.Raw}
+)
+
+// Config holds the settings of the flat-file ethdb
+type Config struct {
+	// Path is the root directory of the tree, it is created if it doesn't exist
+	Path string
+	// Sync fsyncs the files and directories that are written, so that a write survives a crash once it returns
+	// Writes are atomic whatever the setting
+	Sync bool
+	// ReadOnly makes the ethdb return ipfsethdb.ErrReadOnly from all its writers
+	ReadOnly bool
+}
+
+var _ ethdb.Database = &Database{}
+
+// Database is the type that satisfies the ethdb.Database and ethdb.KeyValueStore interfaces for IPFS Ethereum data
+// stored in a directory tree
+type Database struct {
+	config Config
+}
+
+// NewKeyValueStore returns a ethdb.KeyValueStore interface for a directory tree
+func NewKeyValueStore(config Config) (ethdb.KeyValueStore, error) {
+	return newDatabase(config)
+}
+
+// NewDatabase returns a ethdb.Database interface for a directory tree
+func NewDatabase(config Config) (ethdb.Database, error) {
+	return newDatabase(config)
+}
+
+func newDatabase(config Config) (*Database, error) {
+	if config.Path == "" {
+		return nil, errNoPath
+	}
+	d := &Database{config: config}
+	if !config.ReadOnly {
+		if err := os.MkdirAll(config.Path, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := d.checkSharding(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// checkSharding checks that the tree is sharded by next-to-last/2, writing the SHARDING file if it is missing so that
+// go-ds-flatfs can open the tree
+func (d *Database) checkSharding() error {
+	p := filepath.Join(d.config.Path, ShardingFile)
+	id, err := os.ReadFile(p)
+	switch {
+	case err == nil:
+		if strings.TrimSpace(string(id)) != shardFunc {
+			return fmt.Errorf("unsupported shard function %q", strings.TrimSpace(string(id)))
+		}
+		return nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	case d.config.ReadOnly:
+		return nil
+	}
+	tmp, err := d.writeTemp(p, []byte(shardFunc+"\n"))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return d.syncDirs(map[string]struct{}{d.config.Path: {}})
+}
+
+// Path returns the path of the file the value for the db key is stored in, relative to the root directory
+func Path(dbKey string) string {
+	name := path.Base(dbKey)
+	shard := "_"
+	if len(name) >= 3 {
+		shard = name[len(name)-3 : len(name)-1]
+	}
+	return filepath.Join(shard, name+Extension)
+}
+
+// path returns the path of the file the value for the key is stored in
+func (d *Database) path(key []byte) (string, error) {
+	dbKey, err := KeyCodec.Key(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.config.Path, Path(dbKey)), nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the key-value data store
+func (d *Database) Has(key []byte) (bool, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key if it's present in the key-value data store
+func (d *Database) Get(key []byte) ([]byte, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value
+// The value is written to a temporary file that is renamed into place, so readers never see a partial value
+func (d *Database) Put(key []byte, value []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := d.writeTemp(p, value)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return d.syncDirs(map[string]struct{}{filepath.Dir(p): {}})
+}
+
+// writeTemp writes the value to a temporary file next to the path, fsyncing it if the config says to
+// A shard directory created for it is made durable by fsyncing the root directory too
+// Temporary files are hidden, so they are skipped by iterators
+func (d *Database) writeTemp(p string, value []byte) (string, error) {
+	dir := filepath.Dir(p)
+	if err := os.Mkdir(dir, 0755); err == nil {
+		if err := d.syncDirs(map[string]struct{}{d.config.Path: {}}); err != nil {
+			return "", err
+		}
+	} else if !errors.Is(err, fs.ErrExist) {
+		return "", err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(p)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(value)
+	if err == nil && d.config.Sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// syncDirs fsyncs the directories, so the renames and removals in them are durable, if the config says to
+func (d *Database) syncDirs(dirs map[string]struct{}) error {
+	if !d.config.Sync {
+		return nil
+	}
+	for dir := range dirs {
+		f, err := os.Open(dir)
+		if err != nil {
+			return err
+		}
+		err = f.Sync()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the key-value data store
+func (d *Database) Delete(key []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return d.syncDirs(map[string]struct{}{filepath.Dir(p): {}})
+}
+
+// Stat satisfies the ethdb.Stater interface
+// Stat returns the total "size" of the values in bytes, their "count", or whether the database is "readonly"
+func (d *Database) Stat(property string) (string, error) {
+	switch strings.ToLower(property) {
+	case "size", "count":
+		var size, count int64
+		err := d.walk(func(_ string, entry fs.DirEntry) error {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			count++
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		if strings.ToLower(property) == "count" {
+			return strconv.FormatInt(count, 10), nil
+		}
+		return strconv.FormatInt(size, 10), nil
+	case ipfsethdb.ReadOnlyProperty:
+		return strconv.FormatBool(d.config.ReadOnly), nil
+	default:
+		return "", fmt.Errorf("unknown database property")
+	}
+}
+
+// walk calls fn for each value file in the tree, skipping temporary files
+func (d *Database) walk(fn func(p string, entry fs.DirEntry) error) error {
+	return filepath.WalkDir(d.config.Path, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !strings.HasSuffix(entry.Name(), Extension) {
+			return nil
+		}
+		return fn(p, entry)
+	})
+}
+
+// Compact satisfies the ethdb.Compacter interface
+// Compact flattens the underlying data store for the given key range
+func (d *Database) Compact(start []byte, limit []byte) error {
+	return errNotSupported
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a write-only database that buffers changes to its host db
+// until a final write is called
+func (d *Database) NewBatch() ethdb.Batch {
+	if d.config.ReadOnly {
+		return ipfsethdb.ReadOnlyBatch{}
+	}
+	return &Batch{db: d}
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface.
+// NewBatchWithSize creates a write-only database batch with pre-allocated buffer.
+func (d *Database) NewBatchWithSize(size int) ethdb.Batch {
+	if d.config.ReadOnly {
+		return ipfsethdb.ReadOnlyBatch{}
+	}
+	return &Batch{db: d, ops: make([]batchOp, 0, size)}
+}
+
+// NewIterator satisfies the ethdb.Iteratee interface
+// it creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix, starting at a particular
+// initial key (or after, if it does not exist).
+//
+// Note: This method assumes that the prefix is NOT part of the start, so there's
+// no need for the caller to prepend the prefix to the start
+func (d *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	return newIterator(d, prefix, start)
+}
+
+// Close satisfies the io.Closer interface
+// Close does nothing, as no files are held open
+func (d *Database) Close() error {
+	return nil
+}
+
+// HasAncient satisfies the ethdb.AncientReader interface
+// HasAncient returns an indicator whether the specified data exists in the ancient store
+func (d *Database) HasAncient(kind string, number uint64) (bool, error) {
+	return false, errNotSupported
+}
+
+// Ancient satisfies the ethdb.AncientReader interface
+// Ancient retrieves an ancient binary blob from the append-only immutable files
+func (d *Database) Ancient(kind string, number uint64) ([]byte, error) {
+	return nil, errNotSupported
+}
+
+// Ancients satisfies the ethdb.AncientReader interface
+// Ancients returns the ancient item numbers in the ancient store
+func (d *Database) Ancients() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// Tail satisfies the ethdb.AncientReader interface.
+// Tail returns the number of first stored item in the freezer.
+func (d *Database) Tail() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientSize satisfies the ethdb.AncientReader interface
+// AncientSize returns the ancient size of the specified category
+func (d *Database) AncientSize(kind string) (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientRange retrieves all the items in a range, starting from the index 'start'.
+// It will return
+//  - at most 'count' items,
+//  - at least 1 item (even if exceeding the maxBytes), but will otherwise
+//   return as many items as fit into maxBytes.
+func (d *Database) AncientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	return nil, errNotSupported
+}
+
+// ReadAncients applies the provided AncientReader function
+func (d *Database) ReadAncients(fn func(ethdb.AncientReaderOp) error) (err error) {
+	return errNotSupported
+}
+
+// ModifyAncients satisfies the ethdb.AncientWriter interface
+// ModifyAncients runs a write operation on the ancient store
+func (d *Database) ModifyAncients(f func(ethdb.AncientWriteOp) error) (int64, error) {
+	return 0, errNotSupported
+}
+
+// TruncateHead satisfies the ethdb.AncientWriter interface.
+// TruncateHead discards all but the first n ancient data from the ancient store.
+func (d *Database) TruncateHead(n uint64) error {
+	return errNotSupported
+}
+
+// TruncateTail satisfies the ethdb.AncientWriter interface.
+// TruncateTail discards the first n ancient data from the ancient store.
+func (d *Database) TruncateTail(n uint64) error {
+	return errNotSupported
+}
+
+// Sync satisfies the ethdb.AncientWriter interface
+// Sync flushes all in-memory ancient store data to disk
+func (d *Database) Sync() error {
+	return errNotSupported
+}
+
+// MigrateTable satisfies the ethdb.AncientWriter interface.
+// MigrateTable processes and migrates entries of a given table to a new format.
+func (d *Database) MigrateTable(string, func([]byte) ([]byte, error)) error {
+	return errNotSupported
+}
+
+// NewSnapshot satisfies the ethdb.Snapshotter interface.
+// NewSnapshot creates a database snapshot based on the current state.
+func (d *Database) NewSnapshot() (ethdb.Snapshot, error) {
+	return nil, errNotSupported
+}
+
+// AncientDatadir returns an error as we don't have a backing chain freezer.
+func (d *Database) AncientDatadir() (string, error) {
+	return "", errNotSupported
+}
@@ -0,0 +1,199 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package flatfsethdb_test
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	flatfsethdb "github.com/cerc-io/ipfs-ethdb/v5/flatfs"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var (
+	testHeader    = types.Header{Number: big.NewInt(1337)}
+	testValue, _  = rlp.EncodeToBytes(&testHeader)
+	testEthKey    = testHeader.Hash().Bytes()
+	testMhKey, _  = pgipfsethdb.MultihashKeyFromKeccak256(testEthKey)
+	testHeader2   = types.Header{Number: big.NewInt(2)}
+	testValue2, _ = rlp.EncodeToBytes(&testHeader2)
+	testEthKey2   = testHeader2.Hash().Bytes()
+)
+
+// files returns the paths of all the files in the tree, relative to its root
+func files(root string) []string {
+	var paths []string
+	Expect(filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(root, p)
+			paths = append(paths, rel)
+		}
+		return err
+	})).To(Succeed())
+	return paths
+}
+
+var _ = Describe("Database", func() {
+	var (
+		dir      string
+		config   flatfsethdb.Config
+		database ethdb.Database
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "flatfsethdb")
+		Expect(err).ToNot(HaveOccurred())
+		config = flatfsethdb.Config{Path: filepath.Join(dir, "blocks"), Sync: true}
+		database, err = flatfsethdb.NewDatabase(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(database.Close()).To(Succeed())
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("stores values in files sharded by their multihash key", func() {
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		name := strings.TrimPrefix(testMhKey, "/blocks/")
+		path := filepath.Join(name[len(name)-3:len(name)-1], name+flatfsethdb.Extension)
+		Expect(flatfsethdb.Path(testMhKey)).To(Equal(path))
+		Expect(files(config.Path)).To(ConsistOf(flatfsethdb.ShardingFile, path))
+		Expect(os.ReadFile(filepath.Join(config.Path, path))).To(Equal(testValue))
+
+		val, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+	})
+
+	It("records the shard function for go-ds-flatfs, and rejects trees sharded by another", func() {
+		Expect(os.ReadFile(filepath.Join(config.Path, flatfsethdb.ShardingFile))).
+			To(Equal([]byte("/repo/flatfs/shard/v1/next-to-last/2\n")))
+		_, err := flatfsethdb.NewDatabase(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.WriteFile(filepath.Join(config.Path, flatfsethdb.ShardingFile), []byte("/repo/flatfs/shard/v1/prefix/2\n"), 0644)).To(Succeed())
+		_, err = flatfsethdb.NewDatabase(config)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reports and deletes values", func() {
+		Expect(database.Has(testEthKey)).To(BeFalse())
+		_, err := database.Get(testEthKey)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		Expect(database.Has(testEthKey)).To(BeTrue())
+		Expect(database.Delete(testEthKey)).To(Succeed())
+		Expect(database.Has(testEthKey)).To(BeFalse())
+		Expect(database.Delete(testEthKey)).To(Succeed())
+	})
+
+	It("writes only the last operation on each key of a batch", func() {
+		Expect(database.Put(testEthKey2, testValue2)).To(Succeed())
+		batch := database.NewBatch()
+		Expect(batch.Put(testEthKey, testValue)).To(Succeed())
+		Expect(batch.Delete(testEthKey)).To(Succeed())
+		Expect(batch.Put(testEthKey, testValue)).To(Succeed())
+		Expect(batch.Delete(testEthKey2)).To(Succeed())
+		Expect(batch.ValueSize()).To(Equal(2 * len(testValue)))
+		Expect(database.Has(testEthKey)).To(BeFalse())
+
+		Expect(batch.Write()).To(Succeed())
+		Expect(database.Get(testEthKey)).To(Equal(testValue))
+		Expect(database.Has(testEthKey2)).To(BeFalse())
+		Expect(files(config.Path)).To(HaveLen(2))
+
+		batch.Reset()
+		Expect(batch.ValueSize()).To(Equal(0))
+	})
+
+	It("iterates over the keys in order, from the prefix and start", func() {
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		Expect(database.Put(testEthKey2, testValue2)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(config.Path, "README"), []byte("not a value"), 0644)).To(Succeed())
+		keys := [][]byte{testEthKey, testEthKey2}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+		it := database.NewIterator(nil, nil)
+		var visited [][]byte
+		for it.Next() {
+			visited = append(visited, it.Key())
+			value, err := database.Get(it.Key())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(it.Value()).To(Equal(value))
+		}
+		Expect(it.Error()).ToNot(HaveOccurred())
+		it.Release()
+		Expect(visited).To(Equal(keys))
+
+		// values deleted after the walk are skipped
+		it = database.NewIterator(nil, nil)
+		deleted, err := database.Get(keys[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Delete(keys[0])).To(Succeed())
+		Expect(it.Next()).To(BeTrue())
+		Expect(it.Key()).To(Equal(keys[1]))
+		Expect(it.Next()).To(BeFalse())
+		Expect(it.Error()).ToNot(HaveOccurred())
+		it.Release()
+		Expect(database.Put(keys[0], deleted)).To(Succeed())
+
+		it = database.NewIterator(nil, keys[1])
+		Expect(it.Next()).To(BeTrue())
+		Expect(it.Key()).To(Equal(keys[1]))
+		Expect(it.Next()).To(BeFalse())
+		it.Release()
+
+		it = database.NewIterator(keys[0][:4], nil)
+		Expect(it.Next()).To(BeTrue())
+		Expect(it.Key()).To(Equal(keys[0]))
+		Expect(it.Next()).To(BeFalse())
+		it.Release()
+	})
+
+	It("reports the size and count of the values", func() {
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		Expect(database.Put(testEthKey2, testValue2)).To(Succeed())
+		Expect(database.Stat("size")).To(Equal(strconv.Itoa(len(testValue) + len(testValue2))))
+		Expect(database.Stat("count")).To(Equal("2"))
+	})
+
+	It("rejects writes in read-only mode", func() {
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		config.ReadOnly = true
+		readOnly, err := flatfsethdb.NewDatabase(config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readOnly.Get(testEthKey)).To(Equal(testValue))
+		Expect(readOnly.Put(testEthKey, testValue)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(readOnly.Delete(testEthKey)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(readOnly.NewBatch().Write()).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(readOnly.Stat(ipfsethdb.ReadOnlyProperty)).To(Equal("true"))
+	})
+})
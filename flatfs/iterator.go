@@ -0,0 +1,132 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package flatfsethdb
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/multiformats/go-multihash"
+)
+
+var _ ethdb.Iterator = &Iterator{}
+
+// Iterator is the type that satisfies the ethdb.Iterator interface for IPFS Ethereum data stored in a directory tree
+// The keys are recovered from the file names when the iterator is created, and the values are read as they are visited
+type Iterator struct {
+	entries           []entry
+	index             int
+	currentKey, value []byte
+	err               error
+}
+
+type entry struct {
+	key  []byte
+	path string
+}
+
+// newIterator walks the tree for the keys with the prefix from the start key on
+func newIterator(db *Database, prefix, start []byte) *Iterator {
+	it := &Iterator{index: -1}
+	from := append(append([]byte{}, prefix...), start...)
+	it.err = db.walk(func(p string, _ fs.DirEntry) error {
+		key, ok := keyFromPath(p)
+		if !ok || !bytes.HasPrefix(key, prefix) || bytes.Compare(key, from) < 0 {
+			return nil
+		}
+		it.entries = append(it.entries, entry{key: key, path: p})
+		return nil
+	})
+	sort.Slice(it.entries, func(i, j int) bool {
+		return bytes.Compare(it.entries[i].key, it.entries[j].key) < 0
+	})
+	return it
+}
+
+// keyFromPath returns the keccak256 hash key of a value file, from the multihash in its name
+// Files that weren't written by this package are skipped
+func keyFromPath(p string) ([]byte, bool) {
+	name := strings.TrimSuffix(filepath.Base(p), Extension)
+	mh, err := dshelp.DsKeyToMultihash(datastore.NewKey(name))
+	if err != nil {
+		return nil, false
+	}
+	decoded, err := multihash.Decode(mh)
+	if err != nil || decoded.Code != multihash.KECCAK_256 {
+		return nil, false
+	}
+	return decoded.Digest, true
+}
+
+// Next satisfies the ethdb.Iterator interface
+// Next moves the iterator to the next key/value pair
+// It returns whether the iterator is exhausted
+// Values deleted since the iterator was created are skipped
+func (i *Iterator) Next() bool {
+	for i.err == nil && i.index+1 < len(i.entries) {
+		i.index++
+		value, err := os.ReadFile(i.entries[i.index].path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if i.err = err; err == nil {
+			i.currentKey, i.value = i.entries[i.index].key, value
+			return true
+		}
+	}
+	i.currentKey, i.value = nil, nil
+	return false
+}
+
+// Error satisfies the ethdb.Iterator interface
+// Error returns any accumulated error
+// Exhausting all the key/value pairs is not considered to be an error
+func (i *Iterator) Error() error {
+	return i.err
+}
+
+// Key satisfies the ethdb.Iterator interface
+// Key returns the key of the current key/value pair, or nil if done
+// The caller should not modify the contents of the returned slice
+// and its contents may change on the next call to Next
+func (i *Iterator) Key() []byte {
+	return i.currentKey
+}
+
+// Value satisfies the ethdb.Iterator interface
+// Value returns the value of the current key/value pair, or nil if done
+// The caller should not modify the contents of the returned slice
+// and its contents may change on the next call to Next
+func (i *Iterator) Value() []byte {
+	return i.value
+}
+
+// Release satisfies the ethdb.Iterator interface
+// Release releases associated resources
+// Release should always succeed and can be called multiple times without causing error
+func (i *Iterator) Release() {
+	i.entries = nil
+	i.currentKey, i.value = nil, nil
+}
@@ -0,0 +1,249 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package ethdbtest holds the ginkgo specs shared by the ethdbs over a SQL blocks table, e.g. postgres/v1 and sqlite
+package ethdbtest
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+)
+
+var (
+	testHeader    = types.Header{Number: big.NewInt(1337)}
+	testValue, _  = rlp.EncodeToBytes(&testHeader)
+	testEthKey    = testHeader.Hash().Bytes()
+	testHeader2   = types.Header{Number: big.NewInt(2)}
+	testValue2, _ = rlp.EncodeToBytes(&testHeader2)
+	testEthKey2   = testHeader2.Hash().Bytes()
+)
+
+// Store is an ethdb under test, set up by the BeforeEach of the suite that runs the specs
+type Store struct {
+	// Database writes at a block number, and has a MultiGetter GetMany
+	Database ethdb.Database
+	// Insert writes the value under the keccak256 key directly to the blocks table, bypassing the Database
+	Insert func(key, value []byte) error
+	// InUse, if set, returns the number of pooled connections in use
+	InUse func() int
+}
+
+// DescribeDatabase adds the specs of the ethdb.KeyValueStore methods, run against the store returned by the function
+func DescribeDatabase(store func() Store) {
+	var (
+		s   Store
+		err error
+	)
+	BeforeEach(func() {
+		s = store()
+	})
+
+	Describe("Has", func() {
+		It("returns false if a key-pair doesn't exist in the db", func() {
+			has, err := s.Database.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).ToNot(BeTrue())
+		})
+		It("returns true if a key-pair exists in the db", func() {
+			Expect(s.Insert(testEthKey, testValue)).To(Succeed())
+			has, err := s.Database.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeTrue())
+		})
+	})
+
+	Describe("Get", func() {
+		It("throws an err if the key-pair doesn't exist in the db", func() {
+			_, err = s.Database.Get(testEthKey)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+		})
+		It("returns the value associated with the key, if the pair exists", func() {
+			Expect(s.Insert(testEthKey, testValue)).To(Succeed())
+			val, err := s.Database.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue))
+		})
+	})
+
+	Describe("GetMany", func() {
+		It("returns the values in request order, with nil for keys that don't exist in the db", func() {
+			Expect(s.Insert(testEthKey, testValue)).To(Succeed())
+			mg, ok := s.Database.(ipfsethdb.MultiGetter)
+			Expect(ok).To(BeTrue())
+			vals, err := mg.GetMany([][]byte{testEthKey2, testEthKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vals).To(HaveLen(2))
+			Expect(vals[0]).To(BeNil())
+			Expect(vals[1]).To(Equal(testValue))
+		})
+	})
+
+	Describe("Put", func() {
+		It("persists the key-value pair in the database", func() {
+			_, err = s.Database.Get(testEthKey)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+
+			err = s.Database.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			val, err := s.Database.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue))
+		})
+	})
+
+	Describe("Delete", func() {
+		It("removes the key-value pair from the database", func() {
+			err = s.Database.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			val, err := s.Database.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue))
+
+			err = s.Database.Delete(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = s.Database.Get(testEthKey)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+		})
+	})
+}
+
+// DescribeBatch adds the specs of the ethdb.Batch methods, run against batches of the store returned by the function
+func DescribeBatch(store func() Store) {
+	var (
+		s     Store
+		batch ethdb.Batch
+		err   error
+	)
+	BeforeEach(func() {
+		s = store()
+		batch = s.Database.NewBatch()
+	})
+	AfterEach(func() {
+		batch.Reset()
+	})
+
+	Describe("Put/Write", func() {
+		It("adds the key-value pair to the batch", func() {
+			_, err = s.Database.Get(testEthKey)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+			_, err = s.Database.Get(testEthKey2)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+
+			err = batch.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Put(testEthKey2, testValue2)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Write()
+			Expect(err).ToNot(HaveOccurred())
+
+			val, err := s.Database.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue))
+			val2, err := s.Database.Get(testEthKey2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val2).To(Equal(testValue2))
+		})
+	})
+
+	Describe("Delete/Reset/Write", func() {
+		It("deletes the key-value pair in the batch", func() {
+			err = batch.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Put(testEthKey2, testValue2)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Write()
+			Expect(err).ToNot(HaveOccurred())
+
+			batch.Reset()
+			err = batch.Delete(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Delete(testEthKey2)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Write()
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = s.Database.Get(testEthKey)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+			_, err = s.Database.Get(testEthKey2)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+		})
+	})
+
+	Describe("Reset", func() {
+		It("rolls back the operations that haven't been written", func() {
+			err = batch.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			batch.Reset()
+			err = batch.Write()
+			Expect(err).ToNot(HaveOccurred())
+
+			has, err := s.Database.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeFalse())
+			if s.InUse != nil {
+				Expect(s.InUse()).To(Equal(0))
+			}
+		})
+	})
+
+	Describe("Replay", func() {
+		It("replays the operations into another writer", func() {
+			err = batch.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Delete(testEthKey2)
+			Expect(err).ToNot(HaveOccurred())
+
+			memdb := rawdb.NewMemoryDatabase()
+			Expect(memdb.Put(testEthKey2, testValue2)).To(Succeed())
+			Expect(batch.Replay(memdb)).To(Succeed())
+			Expect(memdb.Get(testEthKey)).To(Equal(testValue))
+			Expect(memdb.Has(testEthKey2)).To(BeFalse())
+		})
+	})
+
+	Describe("ValueSize/Reset", func() {
+		It("returns the size of data in the batch queued for write", func() {
+			err = batch.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Put(testEthKey2, testValue2)
+			Expect(err).ToNot(HaveOccurred())
+			err = batch.Write()
+			Expect(err).ToNot(HaveOccurred())
+
+			size := batch.ValueSize()
+			Expect(size).To(Equal(len(testValue) + len(testValue2)))
+
+			batch.Reset()
+			size = batch.ValueSize()
+			Expect(size).To(Equal(0))
+		})
+	})
+}
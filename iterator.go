@@ -21,6 +21,8 @@ import (
 
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ipfs/go-blockservice"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
 )
 
 var _ ethdb.Iterator = &Iterator{}
@@ -32,14 +34,20 @@ var _ ethdb.Iterator = &Iterator{}
 // from the ethdb.KeyValueStoreand ethdb.Database interfaces)
 type Iterator struct {
 	blockService       blockservice.BlockService
+	codec              keycodec.KeyCodec
 	currentKey, prefix []byte
 	err                error
 }
 
 // NewIterator returns an ethdb.Iterator interface for PG-IPFS
 func NewIterator(start, prefix []byte, bs blockservice.BlockService) ethdb.Iterator {
+	return newIterator(start, prefix, bs, DefaultKeyCodec)
+}
+
+func newIterator(start, prefix []byte, bs blockservice.BlockService, codec keycodec.KeyCodec) *Iterator {
 	return &Iterator{
 		blockService: bs,
+		codec:        codec,
 		prefix:       prefix,
 		currentKey:   start,
 	}
@@ -74,8 +82,7 @@ func (i *Iterator) Key() []byte {
 // The caller should not modify the contents of the returned slice
 // and its contents may change on the next call to Next
 func (i *Iterator) Value() []byte {
-	// we are using state codec because we don't know the codec and at this level the codec doesn't matter, the datastore key is multihash-only derived
-	c, err := Keccak256ToCid(i.currentKey, stateTrieCodec)
+	c, err := i.codec.CID(i.currentKey)
 	if err != nil {
 		i.err = err
 		return nil
@@ -0,0 +1,239 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package keycodec converts the keys the ethdbs are given into the keys and CIDs their blocks are stored under
+package keycodec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	// ErrNoCID is returned by codecs that store keys without deriving a CID from them
+	ErrNoCID = errors.New("key codec does not address blocks by CID")
+	// ErrHashMismatch is returned when a value does not hash to its key
+	ErrHashMismatch = errors.New("value does not hash to its key")
+)
+
+var (
+	_ KeyCodec = Multihash{}
+	_ KeyCodec = CID{}
+	_ KeyCodec = Raw{}
+
+	_ Verifier = Multihash{}
+	_ Verifier = CID{}
+)
+
+// KeyCodec converts an ethdb key into the key string its block is stored under in a key-value table,
+// and into the CID its block is addressed by in a blockservice
+// Implementations must be deterministic, the same ethdb key always mapping to the same storage key
+type KeyCodec interface {
+	// Key returns the storage key for the ethdb key
+	Key(key []byte) (string, error)
+	// CID returns the CID of the block for the ethdb key
+	CID(key []byte) (cid.Cid, error)
+}
+
+// Verifier is implemented by the KeyCodecs whose keys are hashes of the values, so a value can be checked against its key
+type Verifier interface {
+	// Verify hashes the value with the hasher of the key, returning ErrHashMismatch if the digest differs from the key
+	Verify(key, value []byte) error
+}
+
+// Verify checks the value against its key if the codec is a Verifier, and does nothing otherwise
+func Verify(codec KeyCodec, key, value []byte) error {
+	if v, ok := codec.(Verifier); ok {
+		return v.Verify(key, value)
+	}
+	return nil
+}
+
+// Multihash is the KeyCodec for ethdb keys that are hash digests, by default keccak256 hashes as passed by go-ethereum
+// Keys are the blockstore-prefixed multihash datastore keys, so a block is found whatever its codec
+// CIDs are v1 CIDs with the configured codec
+type Multihash struct {
+	Codec uint64
+	// Code is the multihash code of the hash function the keys are digests of, keccak256 if zero
+	// e.g. multihash.SHA2_256 for SSZ objects stored under their sha256 roots
+	Code uint64
+	// CodesByLength overrides Code for keys of the given lengths, for stores mixing hash functions of different sizes,
+	// e.g. blake2b-512 and keccak256 keys
+	// Keys are only told apart by their length, so hash functions of the same size, e.g. keccak256 and sha2-256, can't
+	// be mixed in one codec; use a codec with the other Code for the keys of the second function
+	CodesByLength map[int]uint64
+}
+
+// code returns the multihash code for a key of the given length
+func (m Multihash) code(length int) uint64 {
+	if code, ok := m.CodesByLength[length]; ok {
+		return code
+	}
+	if m.Code != 0 {
+		return m.Code
+	}
+	return multihash.KECCAK_256
+}
+
+// multihash wraps the digest in a multihash with the code for its length
+func (m Multihash) multihash(key []byte) (multihash.Multihash, error) {
+	return multihash.Encode(key, m.code(len(key)))
+}
+
+// Key satisfies the KeyCodec interface
+// Key converts the hash into a blockstore-prefixed multihash db key string
+func (m Multihash) Key(key []byte) (string, error) {
+	mh, err := m.multihash(key)
+	if err != nil {
+		return "", err
+	}
+	return MultihashKey(mh), nil
+}
+
+// CID satisfies the KeyCodec interface
+// CID converts the hash into a v1 CID with the codec
+func (m Multihash) CID(key []byte) (cid.Cid, error) {
+	mh, err := m.multihash(key)
+	if err != nil {
+		return cid.Cid{}, err
+	}
+	return cid.NewCidV1(m.Codec, mh), nil
+}
+
+// Verify satisfies the Verifier interface
+// Verify hashes the value with the hash function of the key's multihash code
+func (m Multihash) Verify(key, value []byte) error {
+	mh, err := multihash.Sum(value, m.code(len(key)), len(key))
+	if err != nil {
+		return err
+	}
+	return checkDigest(mh, key)
+}
+
+// CID is the KeyCodec for ethdb keys that are the bytes of a CID
+// Keys are the CID strings, the layout of the postgres/v0 ethdbs
+type CID struct{}
+
+// Key satisfies the KeyCodec interface
+// Key casts the key to a CID and returns its string form
+// Casting asserts that the CID is correctly formatted, and handles the different string encodings of v0 and v1 CIDs
+func (CID) Key(key []byte) (string, error) {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+// CID satisfies the KeyCodec interface
+// CID casts the key to a CID
+func (CID) CID(key []byte) (cid.Cid, error) {
+	return cid.Cast(key)
+}
+
+// Verify satisfies the Verifier interface
+// Verify hashes the value with the hash function of the CID
+func (CID) Verify(key, value []byte) error {
+	c, err := cid.Cast(key)
+	if err != nil {
+		return err
+	}
+	mh, err := c.Prefix().Sum(value)
+	if err != nil {
+		return err
+	}
+	decoded, err := multihash.Decode(c.Hash())
+	if err != nil {
+		return err
+	}
+	return checkDigest(mh.Hash(), decoded.Digest)
+}
+
+// checkDigest compares the digest of a multihash with the expected digest
+func checkDigest(mh multihash.Multihash, digest []byte) error {
+	decoded, err := multihash.Decode(mh)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(decoded.Digest, digest) {
+		return fmt.Errorf("%w: %s digest %x, key %x", ErrHashMismatch, multihash.Codes[decoded.Code], decoded.Digest, digest)
+	}
+	return nil
+}
+
+// Raw is the KeyCodec for stores that keep blocks under the ethdb keys as they are
+// The keys are used as strings, so they must be valid text for the store, e.g. UTF-8 for a Postgres TEXT column
+type Raw struct{}
+
+// Key satisfies the KeyCodec interface
+// Key returns the key unchanged
+func (Raw) Key(key []byte) (string, error) {
+	return string(key), nil
+}
+
+// CID satisfies the KeyCodec interface
+// CID returns ErrNoCID, as raw keys don't address blocks by content
+func (Raw) CID([]byte) (cid.Cid, error) {
+	return cid.Cid{}, ErrNoCID
+}
+
+// MultihashKey returns the blockstore-prefixed datastore key string of the multihash
+func MultihashKey(mh multihash.Multihash) string {
+	return blockstore.BlockPrefix.String() + dshelp.MultihashToDsKey(mh).String()
+}
+
+// MultihashFromKey returns the multihash of a key string returned by MultihashKey
+func MultihashFromKey(key string) (multihash.Multihash, error) {
+	prefix := blockstore.BlockPrefix.String()
+	if !strings.HasPrefix(key, prefix+"/") {
+		return nil, fmt.Errorf("key %q is not under %s", key, prefix)
+	}
+	return dshelp.DsKeyToMultihash(datastore.NewKey(strings.TrimPrefix(key, prefix)))
+}
+
+// DigestFromKey returns the hash digest of a key string returned by Multihash.Key, i.e. the ethdb key it was derived from
+func DigestFromKey(key string) ([]byte, error) {
+	mh, err := MultihashFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := multihash.Decode(mh)
+	if err != nil {
+		return nil, err
+	}
+	return decoded.Digest, nil
+}
+
+// Keys converts each of the ethdb keys into its storage key
+func Keys(codec KeyCodec, keys [][]byte) ([]string, error) {
+	dbKeys := make([]string, len(keys))
+	for i, key := range keys {
+		dbKey, err := codec.Key(key)
+		if err != nil {
+			return nil, err
+		}
+		dbKeys[i] = dbKey
+	}
+	return dbKeys, nil
+}
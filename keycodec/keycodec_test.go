@@ -0,0 +1,147 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package keycodec_test
+
+import (
+	"crypto/sha256"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+)
+
+var _ = Describe("KeyCodec", func() {
+	var (
+		value = []byte("value")
+		hash  = crypto.Keccak256(value)
+		mh, _ = multihash.Encode(hash, multihash.KECCAK_256)
+		c     = cid.NewCidV1(cid.EthTx, mh)
+	)
+
+	Describe("Multihash", func() {
+		codec := keycodec.Multihash{Codec: cid.EthTx}
+
+		It("stores keccak256 hashes under their blockstore-prefixed multihash keys", func() {
+			key, err := codec.Key(hash)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(HavePrefix("/blocks/"))
+			Expect(key).To(Equal(keycodec.MultihashKey(mh)))
+		})
+		It("recovers the multihashes from their keys", func() {
+			key, err := codec.Key(hash)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keycodec.MultihashFromKey(key)).To(BeEquivalentTo(mh))
+			_, err = keycodec.MultihashFromKey(c.String())
+			Expect(err).To(HaveOccurred())
+			Expect(keycodec.DigestFromKey(key)).To(Equal(hash))
+		})
+		It("gives the same key whatever the CID codec", func() {
+			key, err := codec.Key(hash)
+			Expect(err).ToNot(HaveOccurred())
+			other, err := keycodec.Multihash{Codec: cid.EthStateTrie}.Key(hash)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(other).To(Equal(key))
+		})
+		It("derives v1 CIDs with its codec", func() {
+			derived, err := codec.CID(hash)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(derived).To(Equal(c))
+		})
+		It("verifies values with the keccak256 hasher", func() {
+			Expect(codec.Verify(hash, value)).To(Succeed())
+			Expect(codec.Verify(hash, []byte("other"))).To(MatchError(keycodec.ErrHashMismatch))
+		})
+		It("uses the configured multihash code", func() {
+			sha := sha256.Sum256(value)
+			shaCodec := keycodec.Multihash{Codec: cid.Raw, Code: multihash.SHA2_256}
+			key, err := shaCodec.Key(sha[:])
+			Expect(err).ToNot(HaveOccurred())
+			shaMh, _ := multihash.Encode(sha[:], multihash.SHA2_256)
+			Expect(key).To(Equal(keycodec.MultihashKey(shaMh)))
+			Expect(shaCodec.Verify(sha[:], value)).To(Succeed())
+			Expect(shaCodec.Verify(hash, value)).To(MatchError(keycodec.ErrHashMismatch))
+		})
+		It("picks the multihash code by key length", func() {
+			blakeMh, err := multihash.Sum(value, multihash.BLAKE2B_MAX, -1)
+			Expect(err).ToNot(HaveOccurred())
+			decoded, err := multihash.Decode(blakeMh)
+			Expect(err).ToNot(HaveOccurred())
+			blake := decoded.Digest
+			mixed := keycodec.Multihash{CodesByLength: map[int]uint64{64: multihash.BLAKE2B_MAX}}
+			derived, err := mixed.CID(blake)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(derived.Prefix().MhType).To(Equal(uint64(multihash.BLAKE2B_MAX)))
+			Expect(mixed.Verify(blake, value)).To(Succeed())
+			derived, err = mixed.CID(hash)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(derived.Prefix().MhType).To(Equal(uint64(multihash.KECCAK_256)))
+			Expect(mixed.Verify(hash, value)).To(Succeed())
+		})
+	})
+
+	Describe("CID", func() {
+		codec := keycodec.CID{}
+
+		It("stores CID keys under their string form", func() {
+			key, err := codec.Key(c.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(Equal(c.String()))
+			derived, err := codec.CID(c.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(derived).To(Equal(c))
+		})
+		It("verifies values with the hasher of the CID", func() {
+			Expect(codec.Verify(c.Bytes(), value)).To(Succeed())
+			Expect(codec.Verify(c.Bytes(), []byte("other"))).To(MatchError(keycodec.ErrHashMismatch))
+		})
+		It("rejects keys that are not CIDs", func() {
+			_, err := codec.Key([]byte("not a cid"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Raw", func() {
+		It("passes keys through and does not derive CIDs", func() {
+			key, err := keycodec.Raw{}.Key([]byte("some/key"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(key).To(Equal("some/key"))
+			_, err = keycodec.Raw{}.CID([]byte("some/key"))
+			Expect(err).To(MatchError(keycodec.ErrNoCID))
+		})
+	})
+
+	Describe("Verify", func() {
+		It("skips codecs that can't verify values", func() {
+			Expect(keycodec.Verify(keycodec.Raw{}, []byte("key"), value)).To(Succeed())
+			Expect(keycodec.Verify(keycodec.Multihash{}, hash, []byte("other"))).To(MatchError(keycodec.ErrHashMismatch))
+		})
+	})
+
+	Describe("Keys", func() {
+		It("converts each key in order", func() {
+			keys, err := keycodec.Keys(keycodec.Raw{}, [][]byte{[]byte("a"), []byte("b")})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keys).To(Equal([]string{"a", "b"}))
+			_, err = keycodec.Keys(keycodec.CID{}, [][]byte{c.Bytes(), []byte("bad")})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
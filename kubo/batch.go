@@ -1,5 +1,5 @@
 // VulcanizeDB
-// Copyright © 2020 Vulcanize
+// Copyright © 2023 Vulcanize
 
 // This program is free software: you can redistribute it and/or modify
 // it under the terms of the GNU Affero General Public License as published by
@@ -14,63 +14,46 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-package pgipfsethdb
+package kuboethdb
 
 import (
-	"math/big"
+	"context"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
-	"github.com/jmoiron/sqlx"
+	"github.com/ipfs/go-cid"
 )
 
 var _ ethdb.Batch = &Batch{}
 
-// Batch is the type that satisfies the ethdb.Batch interface for PG-IPFS Ethereum data using a direct Postgres connection
+// Batch is the type that satisfies the ethdb.Batch interface for IPFS Ethereum data stored in a Kubo daemon
+// The operations are buffered until Write, which puts the blocks with up to MaxConns calls to the daemon at a time
+// and then removes the deleted blocks with a single call
 type Batch struct {
-	db        *sqlx.DB
-	tx        *sqlx.Tx
+	db        *Database
+	ops       []batchOp
 	valueSize int
-
-	blockNumber *big.Int
 }
 
-// NewBatch returns a ethdb.Batch interface for PG-IPFS
-func NewBatch(db *sqlx.DB, tx *sqlx.Tx, blockNumber *big.Int) ethdb.Batch {
-	b := &Batch{
-		db:          db,
-		tx:          tx,
-		blockNumber: blockNumber,
-	}
-	if tx == nil {
-		b.Reset()
-	}
-	return b
+type batchOp struct {
+	key, value []byte
+	delete     bool
 }
 
 // Put satisfies the ethdb.Batch interface
 // Put inserts the given value into the key-value data store
 // Key is expected to be the keccak256 hash of value
-func (b *Batch) Put(key []byte, value []byte) (err error) {
-	mhKey, err := MultihashKeyFromKeccak256(key)
-	if err != nil {
-		return err
-	}
-	if _, err = b.tx.Exec(putPgStr, mhKey, value, b.blockNumber.Uint64()); err != nil {
-		return err
-	}
+func (b *Batch) Put(key []byte, value []byte) error {
+	b.ops = append(b.ops, batchOp{key: common.CopyBytes(key), value: common.CopyBytes(value)})
 	b.valueSize += len(value)
 	return nil
 }
 
 // Delete satisfies the ethdb.Batch interface
 // Delete removes the key from the key-value data store
-func (b *Batch) Delete(key []byte) (err error) {
-	mhKey, err := MultihashKeyFromKeccak256(key)
-	if err != nil {
-		return err
-	}
-	_, err = b.tx.Exec(deletePgStr, mhKey)
-	return err
+func (b *Batch) Delete(key []byte) error {
+	b.ops = append(b.ops, batchOp{key: common.CopyBytes(key), delete: true})
+	return nil
 }
 
 // ValueSize satisfies the ethdb.Batch interface
@@ -82,27 +65,59 @@ func (b *Batch) ValueSize() int {
 
 // Write satisfies the ethdb.Batch interface
 // Write flushes any accumulated data to disk
+// Only the last operation on each key is sent to the daemon
 func (b *Batch) Write() error {
-	if b.tx == nil {
-		return nil
+	last := make(map[string]int, len(b.ops))
+	for i, op := range b.ops {
+		last[string(op.key)] = i
+	}
+	var puts []batchOp
+	var putCIDs, deletes []cid.Cid
+	for i, op := range b.ops {
+		if last[string(op.key)] != i {
+			continue
+		}
+		c, err := b.db.codec.CID(op.key)
+		if err != nil {
+			return err
+		}
+		if op.delete {
+			deletes = append(deletes, c)
+			continue
+		}
+		puts = append(puts, op)
+		putCIDs = append(putCIDs, c)
+	}
+	err := forEach(context.Background(), len(puts), b.db.maxConns, func(ctx context.Context, i int) error {
+		return b.db.client.put(ctx, putCIDs[i], puts[i].value)
+	})
+	if err != nil || len(deletes) == 0 {
+		return err
 	}
-	return b.tx.Commit()
+	return b.db.client.rm(context.Background(), deletes)
 }
 
 // Replay satisfies the ethdb.Batch interface
 // Replay replays the batch contents
 func (b *Batch) Replay(w ethdb.KeyValueWriter) error {
-	return errNotSupported
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = w.Delete(op.key)
+		} else {
+			err = w.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Reset satisfies the ethdb.Batch interface
 // Reset resets the batch for reuse
 // This should be called after every write
 func (b *Batch) Reset() {
-	var err error
-	b.tx, err = b.db.Beginx()
-	if err != nil {
-		panic(err)
-	}
+	b.ops = b.ops[:0]
 	b.valueSize = 0
 }
@@ -0,0 +1,225 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package kuboethdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// codecNames are the multicodec names of the codecs Kubo is asked to put blocks with
+var codecNames = map[uint64]string{
+	cid.Raw:                "raw",
+	cid.DagProtobuf:        "dag-pb",
+	cid.DagCBOR:            "dag-cbor",
+	cid.EthBlock:           "eth-block",
+	cid.EthBlockList:       "eth-block-list",
+	cid.EthTxTrie:          "eth-tx-trie",
+	cid.EthTx:              "eth-tx",
+	cid.EthTxReceiptTrie:   "eth-tx-receipt-trie",
+	cid.EthTxReceipt:       "eth-tx-receipt",
+	cid.EthStateTrie:       "eth-state-trie",
+	cid.EthAccountSnapshot: "eth-account-snapshot",
+	cid.EthStorageTrie:     "eth-storage-trie",
+}
+
+// Error is an error returned by the Kubo RPC API
+type Error struct {
+	Message string
+	Code    int
+	Type    string
+}
+
+func (e *Error) Error() string {
+	return "kubo: " + e.Message
+}
+
+// IsNotFound returns whether the error is Kubo reporting that it doesn't have a block
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && strings.Contains(strings.ToLower(e.Message), "not found")
+}
+
+// blockStat is the response of block/put and block/stat
+type blockStat struct {
+	Key  string
+	Size int
+}
+
+// removedBlock is an entry of the response of block/rm
+type removedBlock struct {
+	Hash  string
+	Error string
+}
+
+// client calls the block commands of a Kubo RPC API
+type client struct {
+	http    *http.Client
+	api     string
+	offline bool
+}
+
+// call posts the command with the query arguments, and the body if it is not nil, returning the response body
+// The caller closes the body
+func (c *client) call(ctx context.Context, command string, args url.Values, body io.Reader, contentType string) (io.ReadCloser, error) {
+	if c.offline {
+		args.Set("offline", "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.api+"/api/v0/"+command+"?"+args.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusOK {
+		return res.Body, nil
+	}
+	defer res.Body.Close()
+	apiErr := &Error{}
+	if err := json.NewDecoder(res.Body).Decode(apiErr); err != nil || apiErr.Message == "" {
+		return nil, fmt.Errorf("kubo: %s returned %s", command, res.Status)
+	}
+	return nil, apiErr
+}
+
+// get returns the raw data of the block
+func (c *client) get(ctx context.Context, id cid.Cid) ([]byte, error) {
+	body, err := c.call(ctx, "block/get", url.Values{"arg": {id.String()}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// stat returns whether the daemon has the block
+func (c *client) stat(ctx context.Context, id cid.Cid) (bool, error) {
+	body, err := c.call(ctx, "block/stat", url.Values{"arg": {id.String()}}, nil, "")
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+	var stat blockStat
+	return true, json.NewDecoder(body).Decode(&stat)
+}
+
+// put adds the block with the codec and hash function of the CID, and checks the daemon derived the same CID
+func (c *client) put(ctx context.Context, id cid.Cid, data []byte) error {
+	prefix := id.Prefix()
+	codec, ok := codecNames[prefix.Codec]
+	if !ok {
+		return fmt.Errorf("kubo: unsupported codec 0x%x", prefix.Codec)
+	}
+	mhType, ok := multihash.Codes[prefix.MhType]
+	if !ok {
+		return fmt.Errorf("kubo: unsupported multihash 0x%x", prefix.MhType)
+	}
+	var form bytes.Buffer
+	w := multipart.NewWriter(&form)
+	part, err := w.CreateFormFile("data", "data")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	args := url.Values{
+		"cid-codec": {codec},
+		"mhtype":    {mhType},
+		"mhlen":     {strconv.Itoa(prefix.MhLength)},
+	}
+	body, err := c.call(ctx, "block/put", args, &form, w.FormDataContentType())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	var stat blockStat
+	if err := json.NewDecoder(body).Decode(&stat); err != nil {
+		return err
+	}
+	put, err := cid.Decode(stat.Key)
+	if err != nil {
+		return err
+	}
+	if !put.Equals(id) {
+		return fmt.Errorf("kubo: block was put as %s, expected %s", put, id)
+	}
+	return nil
+}
+
+// rm removes the blocks, ignoring those the daemon doesn't have
+func (c *client) rm(ctx context.Context, ids []cid.Cid) error {
+	args := url.Values{"force": {"true"}}
+	for _, id := range ids {
+		args.Add("arg", id.String())
+	}
+	body, err := c.call(ctx, "block/rm", args, nil, "")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	dec := json.NewDecoder(body)
+	for {
+		var removed removedBlock
+		if err := dec.Decode(&removed); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if removed.Error != "" {
+			return fmt.Errorf("kubo: removing %s: %s", removed.Hash, removed.Error)
+		}
+	}
+}
+
+// repoSize returns the size of the daemon's repo in bytes
+func (c *client) repoSize(ctx context.Context) (string, error) {
+	body, err := c.call(ctx, "repo/stat", url.Values{"size-only": {"true"}}, nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	var stat struct {
+		RepoSize uint64
+	}
+	if err := json.NewDecoder(body).Decode(&stat); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(stat.RepoSize, 10), nil
+}
@@ -0,0 +1,156 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package kuboethdb_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// fakeDaemon serves the block commands of the Kubo RPC API from memory
+type fakeDaemon struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	blocks map[string][]byte // by multihash, as in a Kubo blockstore
+	calls  map[string]int
+}
+
+func newFakeDaemon() *fakeDaemon {
+	d := &fakeDaemon{blocks: make(map[string][]byte), calls: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v0/block/get", d.get)
+	mux.HandleFunc("/api/v0/block/stat", d.stat)
+	mux.HandleFunc("/api/v0/block/put", d.put)
+	mux.HandleFunc("/api/v0/block/rm", d.rm)
+	mux.HandleFunc("/api/v0/repo/stat", d.repoStat)
+	d.Server = httptest.NewServer(d.count(mux))
+	return d
+}
+
+func (d *fakeDaemon) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		d.calls[r.URL.Path]++
+		d.mu.Unlock()
+		if r.Method != http.MethodPost {
+			http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Calls returns the number of calls made to the command, e.g. "block/put"
+func (d *fakeDaemon) Calls(command string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.calls["/api/v0/"+command]
+}
+
+func (d *fakeDaemon) lookup(w http.ResponseWriter, r *http.Request) (cid.Cid, []byte, bool) {
+	c, err := cid.Decode(r.URL.Query().Get("arg"))
+	if err != nil {
+		fail(w, err.Error())
+		return cid.Undef, nil, false
+	}
+	d.mu.Lock()
+	data, ok := d.blocks[string(c.Hash())]
+	d.mu.Unlock()
+	if !ok {
+		fail(w, "block was not found locally (offline): ipld: could not find "+c.String())
+	}
+	return c, data, ok
+}
+
+func (d *fakeDaemon) get(w http.ResponseWriter, r *http.Request) {
+	if _, data, ok := d.lookup(w, r); ok {
+		w.Write(data)
+	}
+}
+
+func (d *fakeDaemon) stat(w http.ResponseWriter, r *http.Request) {
+	if c, data, ok := d.lookup(w, r); ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{"Key": c.String(), "Size": len(data)})
+	}
+}
+
+func (d *fakeDaemon) put(w http.ResponseWriter, r *http.Request) {
+	codecs := map[string]uint64{"raw": cid.Raw, "eth-state-trie": cid.EthStateTrie}
+	codec, ok := codecs[r.URL.Query().Get("cid-codec")]
+	if !ok {
+		fail(w, "unknown cid-codec")
+		return
+	}
+	file, _, err := r.FormFile("data")
+	if err != nil {
+		fail(w, err.Error())
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		fail(w, err.Error())
+		return
+	}
+	mh, err := multihash.Sum(data, multihash.Names[r.URL.Query().Get("mhtype")], -1)
+	if err != nil {
+		fail(w, err.Error())
+		return
+	}
+	d.mu.Lock()
+	d.blocks[string(mh)] = data
+	d.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"Key": cid.NewCidV1(codec, mh).String(), "Size": len(data)})
+}
+
+func (d *fakeDaemon) rm(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	for _, arg := range r.URL.Query()["arg"] {
+		c, err := cid.Decode(arg)
+		if err != nil {
+			enc.Encode(map[string]string{"Hash": arg, "Error": err.Error()})
+			continue
+		}
+		d.mu.Lock()
+		delete(d.blocks, string(c.Hash()))
+		d.mu.Unlock()
+		enc.Encode(map[string]string{"Hash": arg})
+	}
+}
+
+func (d *fakeDaemon) repoStat(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	size := 0
+	for _, data := range d.blocks {
+		size += len(data)
+	}
+	d.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]interface{}{"RepoSize": size})
+}
+
+// fail writes an error the way the Kubo RPC API does
+func fail(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Message": message, "Code": 0, "Type": "error"})
+}
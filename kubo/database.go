@@ -0,0 +1,365 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package kuboethdb is the ethdb over the block commands of a running Kubo daemon's HTTP RPC API,
+// so that the IPFS repo doesn't have to be opened in process, and its lockfile taken
+package kuboethdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+)
+
+var (
+	errNotSupported = errors.New("this operation is not supported")
+	errNoURL        = errors.New("the URL of the Kubo RPC API must be set")
+
+	DefaultConfig = Config{
+		URL:      "http://127.0.0.1:5001",
+		MaxConns: 16,
+		Timeout:  time.Minute,
+	}
+)
+
+// Config holds the settings of the Kubo RPC ethdb
+type Config struct {
+	// URL is the address of the daemon's RPC API, e.g. "http://127.0.0.1:5001"
+	URL string
+	// MaxConns is the size of the connection pool to the daemon, and the number of blocks fetched or put concurrently
+	// by GetMany and batches
+	MaxConns int
+	// Timeout bounds each call to the daemon, zero means no timeout
+	Timeout time.Duration
+	// Offline only reads the daemon's local blockstore, rather than letting it fetch missing blocks from its peers
+	// Otherwise a Get or GetMany of a key no peer has only fails once the daemon gives up or the Timeout is reached,
+	// so set it when the blocks are known to be local
+	// Has always reads the local blockstore only
+	Offline bool
+	// ReadOnly makes the ethdb return ipfsethdb.ErrReadOnly from all its writers
+	ReadOnly bool
+}
+
+var _ ethdb.Database = &Database{}
+
+// Database is the type that satisfies the ethdb.Database and ethdb.KeyValueStore interfaces for IPFS Ethereum data
+// stored in a Kubo daemon, reached over its HTTP RPC API
+type Database struct {
+	client   *client
+	local    *client
+	codec    keycodec.KeyCodec
+	maxConns int
+	readOnly bool
+}
+
+// NewKeyValueStore returns a ethdb.KeyValueStore interface for a Kubo daemon
+func NewKeyValueStore(config Config) (ethdb.KeyValueStore, error) {
+	return NewDatabaseWithKeyCodec(config, ipfsethdb.DefaultKeyCodec)
+}
+
+// NewDatabase returns a ethdb.Database interface for a Kubo daemon, with the key to CID mapping of ipfsethdb.Database
+func NewDatabase(config Config) (ethdb.Database, error) {
+	return NewDatabaseWithKeyCodec(config, ipfsethdb.DefaultKeyCodec)
+}
+
+// NewDatabaseWithKeyCodec returns a Database for a Kubo daemon that addresses blocks by the CIDs of the KeyCodec
+func NewDatabaseWithKeyCodec(config Config, codec keycodec.KeyCodec) (*Database, error) {
+	if config.URL == "" {
+		return nil, errNoURL
+	}
+	if config.MaxConns <= 0 {
+		config.MaxConns = DefaultConfig.MaxConns
+	}
+	httpClient := &http.Client{
+		Timeout: config.Timeout,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxConnsPerHost:     config.MaxConns,
+			MaxIdleConnsPerHost: config.MaxConns,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	api := strings.TrimSuffix(config.URL, "/")
+	return &Database{
+		client:   &client{http: httpClient, api: api, offline: config.Offline},
+		local:    &client{http: httpClient, api: api, offline: true},
+		codec:    codec,
+		maxConns: config.MaxConns,
+		readOnly: config.ReadOnly,
+	}, nil
+}
+
+func (d *Database) ModifyAncients(f func(ethdb.AncientWriteOp) error) (int64, error) {
+	return 0, errNotSupported
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the key-value data store
+// This only operates on the daemon's local blockstore
+func (d *Database) Has(key []byte) (bool, error) {
+	c, err := d.codec.CID(key)
+	if err != nil {
+		return false, err
+	}
+	return d.local.stat(context.Background(), c)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key if it's present in the key-value data store
+func (d *Database) Get(key []byte) ([]byte, error) {
+	c, err := d.codec.CID(key)
+	if err != nil {
+		return nil, err
+	}
+	return d.client.get(context.Background(), c)
+}
+
+// GetMany retrieves the values for the given keys, with up to MaxConns calls to the daemon at a time
+// The returned values are in the same order as the keys, with a nil entry for each key that could not be found
+// Unless the config is Offline the daemon searches its peers for the missing keys, which can take up to the Timeout
+// The remaining calls are cancelled by the first error
+func (d *Database) GetMany(keys [][]byte) ([][]byte, error) {
+	cids := make([]cid.Cid, len(keys))
+	for i, key := range keys {
+		c, err := d.codec.CID(key)
+		if err != nil {
+			return nil, err
+		}
+		cids[i] = c
+	}
+	values := make([][]byte, len(keys))
+	err := forEach(context.Background(), len(cids), d.maxConns, func(ctx context.Context, i int) error {
+		data, err := d.client.get(ctx, cids[i])
+		if IsNotFound(err) {
+			return nil
+		}
+		values[i] = data
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value
+func (d *Database) Put(key []byte, value []byte) error {
+	if d.readOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	c, err := d.codec.CID(key)
+	if err != nil {
+		return err
+	}
+	return d.client.put(context.Background(), c, value)
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the key-value data store
+func (d *Database) Delete(key []byte) error {
+	if d.readOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	c, err := d.codec.CID(key)
+	if err != nil {
+		return err
+	}
+	return d.client.rm(context.Background(), []cid.Cid{c})
+}
+
+// Stat satisfies the ethdb.Stater interface
+// Stat returns the "size" of the daemon's repo in bytes, or whether the database is "readonly"
+func (d *Database) Stat(property string) (string, error) {
+	switch strings.ToLower(property) {
+	case "size":
+		return d.client.repoSize(context.Background())
+	case ipfsethdb.ReadOnlyProperty:
+		return strconv.FormatBool(d.readOnly), nil
+	default:
+		return "", fmt.Errorf("unknown database property")
+	}
+}
+
+// Compact satisfies the ethdb.Compacter interface
+// Compact flattens the underlying data store for the given key range
+func (d *Database) Compact(start []byte, limit []byte) error {
+	return errNotSupported
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a write-only database that buffers changes to its host db
+// until a final write is called
+func (d *Database) NewBatch() ethdb.Batch {
+	if d.readOnly {
+		return ipfsethdb.ReadOnlyBatch{}
+	}
+	return &Batch{db: d}
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface.
+// NewBatchWithSize creates a write-only database batch with pre-allocated buffer.
+func (d *Database) NewBatchWithSize(size int) ethdb.Batch {
+	if d.readOnly {
+		return ipfsethdb.ReadOnlyBatch{}
+	}
+	return &Batch{db: d, ops: make([]batchOp, 0, size)}
+}
+
+// NewIterator satisfies the ethdb.Iteratee interface
+// it creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix, starting at a particular
+// initial key (or after, if it does not exist).
+//
+// Note: This method assumes that the prefix is NOT part of the start, so there's
+// no need for the caller to prepend the prefix to the start
+func (d *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	return &Iterator{db: d, prefix: prefix, currentKey: start}
+}
+
+// Close satisfies the io.Closer interface
+// Close closes the idle connections to the daemon
+func (d *Database) Close() error {
+	d.client.http.CloseIdleConnections()
+	return nil
+}
+
+// HasAncient satisfies the ethdb.AncientReader interface
+// HasAncient returns an indicator whether the specified data exists in the ancient store
+func (d *Database) HasAncient(kind string, number uint64) (bool, error) {
+	return false, errNotSupported
+}
+
+// Ancient satisfies the ethdb.AncientReader interface
+// Ancient retrieves an ancient binary blob from the append-only immutable files
+func (d *Database) Ancient(kind string, number uint64) ([]byte, error) {
+	return nil, errNotSupported
+}
+
+// Ancients satisfies the ethdb.AncientReader interface
+// Ancients returns the ancient item numbers in the ancient store
+func (d *Database) Ancients() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// Tail satisfies the ethdb.AncientReader interface.
+// Tail returns the number of first stored item in the freezer.
+func (d *Database) Tail() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientSize satisfies the ethdb.AncientReader interface
+// AncientSize returns the ancient size of the specified category
+func (d *Database) AncientSize(kind string) (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientRange retrieves all the items in a range, starting from the index 'start'.
+// It will return
+//  - at most 'count' items,
+//  - at least 1 item (even if exceeding the maxBytes), but will otherwise
+//   return as many items as fit into maxBytes.
+func (d *Database) AncientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	return nil, errNotSupported
+}
+
+// ReadAncients applies the provided AncientReader function
+func (d *Database) ReadAncients(fn func(ethdb.AncientReaderOp) error) (err error) {
+	return errNotSupported
+}
+
+// TruncateHead satisfies the ethdb.AncientWriter interface.
+// TruncateHead discards all but the first n ancient data from the ancient store.
+func (d *Database) TruncateHead(n uint64) error {
+	return errNotSupported
+}
+
+// TruncateTail satisfies the ethdb.AncientWriter interface.
+// TruncateTail discards the first n ancient data from the ancient store.
+func (d *Database) TruncateTail(n uint64) error {
+	return errNotSupported
+}
+
+// Sync satisfies the ethdb.AncientWriter interface
+// Sync flushes all in-memory ancient store data to disk
+func (d *Database) Sync() error {
+	return errNotSupported
+}
+
+// MigrateTable satisfies the ethdb.AncientWriter interface.
+// MigrateTable processes and migrates entries of a given table to a new format.
+func (d *Database) MigrateTable(string, func([]byte) ([]byte, error)) error {
+	return errNotSupported
+}
+
+// NewSnapshot satisfies the ethdb.Snapshotter interface.
+// NewSnapshot creates a database snapshot based on the current state.
+func (d *Database) NewSnapshot() (ethdb.Snapshot, error) {
+	return nil, errNotSupported
+}
+
+// AncientDatadir returns an error as we don't have a backing chain freezer.
+func (d *Database) AncientDatadir() (string, error) {
+	return "", errNotSupported
+}
+
+// forEach calls fn for each index below n, with up to limit calls at a time, and returns the first error
+// The first error cancels the context passed to the calls in flight, and no further calls are made
+func forEach(ctx context.Context, n, limit int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		sem      = make(chan struct{}, limit)
+	)
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(ctx, i); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,157 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package kuboethdb_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	kuboethdb "github.com/cerc-io/ipfs-ethdb/v5/kubo"
+)
+
+var (
+	testHeader    = types.Header{Number: big.NewInt(1337)}
+	testValue, _  = rlp.EncodeToBytes(&testHeader)
+	testEthKey    = testHeader.Hash().Bytes()
+	testHeader2   = types.Header{Number: big.NewInt(2)}
+	testValue2, _ = rlp.EncodeToBytes(&testHeader2)
+	testEthKey2   = testHeader2.Hash().Bytes()
+)
+
+var _ = Describe("Database", func() {
+	var (
+		daemon   *fakeDaemon
+		config   kuboethdb.Config
+		database ethdb.Database
+	)
+
+	BeforeEach(func() {
+		daemon = newFakeDaemon()
+		config = kuboethdb.DefaultConfig
+		config.URL = daemon.URL
+		var err error
+		database, err = kuboethdb.NewDatabase(config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(database.Close()).To(Succeed())
+		daemon.Close()
+	})
+
+	It("puts and gets blocks under the CIDs of ipfsethdb.Database", func() {
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		val, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+
+		c, err := ipfsethdb.DefaultKeyCodec.CID(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(daemon.blocks).To(HaveKeyWithValue(string(c.Hash()), testValue))
+	})
+
+	It("reports missing blocks", func() {
+		has, err := database.Has(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+		_, err = database.Get(testEthKey)
+		Expect(kuboethdb.IsNotFound(err)).To(BeTrue())
+
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		has, err = database.Has(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+	})
+
+	It("gets many blocks, with nil for the missing ones", func() {
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		values, err := database.(*kuboethdb.Database).GetMany([][]byte{testEthKey2, testEthKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{nil, testValue}))
+	})
+
+	It("deletes blocks, including ones the daemon doesn't have", func() {
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		Expect(database.Delete(testEthKey)).To(Succeed())
+		Expect(database.Has(testEthKey)).To(BeFalse())
+		Expect(database.Delete(testEthKey2)).To(Succeed())
+	})
+
+	It("writes only the last operation on each key of a batch", func() {
+		Expect(database.Put(testEthKey2, testValue2)).To(Succeed())
+		batch := database.NewBatch()
+		Expect(batch.Put(testEthKey, testValue)).To(Succeed())
+		Expect(batch.Delete(testEthKey)).To(Succeed())
+		Expect(batch.Put(testEthKey, testValue)).To(Succeed())
+		Expect(batch.Delete(testEthKey2)).To(Succeed())
+		Expect(batch.ValueSize()).To(Equal(2 * len(testValue)))
+		Expect(daemon.Calls("block/put")).To(Equal(1))
+
+		Expect(batch.Write()).To(Succeed())
+		Expect(daemon.Calls("block/put")).To(Equal(2))
+		Expect(daemon.Calls("block/rm")).To(Equal(1))
+		Expect(database.Get(testEthKey)).To(Equal(testValue))
+		Expect(database.Has(testEthKey2)).To(BeFalse())
+
+		batch.Reset()
+		Expect(batch.ValueSize()).To(Equal(0))
+	})
+
+	It("reports the repo size", func() {
+		Expect(database.Put(testEthKey, testValue)).To(Succeed())
+		Expect(database.Stat("size")).To(Equal(strconv.Itoa(len(testValue))))
+	})
+
+	It("rejects writes in read-only mode", func() {
+		config.ReadOnly = true
+		readOnly, err := kuboethdb.NewDatabase(config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readOnly.Put(testEthKey, testValue)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(readOnly.NewBatch().Write()).To(MatchError(ipfsethdb.ErrReadOnly))
+	})
+
+	It("surfaces the daemon's errors", func() {
+		daemon.Close()
+		_, err := database.Get(testEthKey)
+		Expect(err).To(HaveOccurred())
+		Expect(kuboethdb.IsNotFound(err)).To(BeFalse())
+	})
+
+	It("cancels the remaining calls on the first error", func() {
+		failure := errors.New("failure")
+		var started int32
+		err := kuboethdb.ForEach(context.Background(), 100, 4, func(ctx context.Context, i int) error {
+			atomic.AddInt32(&started, 1)
+			if i == 0 {
+				return failure
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		Expect(err).To(MatchError(failure))
+		Expect(atomic.LoadInt32(&started)).To(BeNumerically("<", 100))
+	})
+})
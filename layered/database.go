@@ -0,0 +1,189 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package layered reads from a primary ethdb.Database, falling back to another on a miss
+package layered
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	log "github.com/sirupsen/logrus"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+)
+
+// FallbackPrefix routes Stat properties to the fallback database, e.g. "fallback.exchange"
+const FallbackPrefix = "fallback."
+
+// Config holds the layering settings
+type Config struct {
+	// Backfill, if set, is written the values found in the fallback, so that the primary serves them the next time
+	// e.g. pgipfsethdb.Database.AtBlockNumber, which files them under an explicit block number
+	// Backfill errors are logged and counted rather than failing the read
+	Backfill ethdb.KeyValueWriter
+}
+
+// Stats holds the layering counters
+type Stats struct {
+	PrimaryHits    uint64
+	FallbackHits   uint64
+	Misses         uint64
+	Backfilled     uint64
+	BackfillErrors uint64
+}
+
+var _ ethdb.Database = &Database{}
+
+// Database is an ethdb.Database that reads from a primary database and, for the keys it misses, from a fallback
+// e.g. a pgipfsethdb.Database in front of an ipfsethdb.Database whose blockservice can fetch blocks over the exchange
+// Writes, batches, iterators and the ancient store are those of the primary
+type Database struct {
+	ethdb.Database
+	fallback ethdb.Database
+	config   Config
+
+	primaryHits, fallbackHits, misses, backfilled, backfillErrors uint64
+}
+
+// NewDatabase returns an ethdb.Database reading from the primary and falling back to the fallback
+func NewDatabase(primary, fallback ethdb.Database, config Config) *Database {
+	return &Database{Database: primary, fallback: fallback, config: config}
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the primary or the fallback
+func (d *Database) Has(key []byte) (bool, error) {
+	has, err := d.Database.Has(key)
+	if err == nil && has {
+		return true, nil
+	}
+	fallbackHas, fallbackErr := d.fallback.Has(key)
+	switch {
+	case fallbackErr != nil:
+		return false, combine(err, fallbackErr)
+	case !fallbackHas && err != nil:
+		return false, err
+	}
+	return fallbackHas, nil
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key from the primary, or from the fallback if the primary fails to return it
+func (d *Database) Get(key []byte) ([]byte, error) {
+	val, err := d.Database.Get(key)
+	if err == nil {
+		atomic.AddUint64(&d.primaryHits, 1)
+		return val, nil
+	}
+	val, fallbackErr := d.fallback.Get(key)
+	if fallbackErr != nil {
+		atomic.AddUint64(&d.misses, 1)
+		return nil, combine(err, fallbackErr)
+	}
+	atomic.AddUint64(&d.fallbackHits, 1)
+	d.backfill(key, val)
+	return val, nil
+}
+
+// GetMany retrieves the values for the given keys from the primary, and those it doesn't have from the fallback
+// The returned values are in the same order as the keys, with a nil entry for each key that neither has
+func (d *Database) GetMany(keys [][]byte) ([][]byte, error) {
+	values, err := ipfsethdb.GetMany(d.Database, keys)
+	if err != nil {
+		// the primary is unavailable, so look all the keys up in the fallback
+		values = make([][]byte, len(keys))
+	}
+	var missing [][]byte
+	var indices []int
+	for i, val := range values {
+		if val == nil {
+			missing = append(missing, keys[i])
+			indices = append(indices, i)
+		}
+	}
+	atomic.AddUint64(&d.primaryHits, uint64(len(keys)-len(missing)))
+	if len(missing) == 0 {
+		return values, nil
+	}
+	found, fallbackErr := ipfsethdb.GetMany(d.fallback, missing)
+	if fallbackErr != nil {
+		return nil, combine(err, fallbackErr)
+	}
+	for j, val := range found {
+		if val == nil {
+			atomic.AddUint64(&d.misses, 1)
+			continue
+		}
+		atomic.AddUint64(&d.fallbackHits, 1)
+		values[indices[j]] = val
+		d.backfill(missing[j], val)
+	}
+	return values, nil
+}
+
+// Stat satisfies the ethdb.Stater interface
+// Stat returns a particular internal stat of the primary, or of the fallback for properties prefixed with "fallback."
+func (d *Database) Stat(property string) (string, error) {
+	if strings.HasPrefix(property, FallbackPrefix) {
+		return d.fallback.Stat(strings.TrimPrefix(property, FallbackPrefix))
+	}
+	return d.Database.Stat(property)
+}
+
+// Stats returns the layering counters
+func (d *Database) Stats() Stats {
+	return Stats{
+		PrimaryHits:    atomic.LoadUint64(&d.primaryHits),
+		FallbackHits:   atomic.LoadUint64(&d.fallbackHits),
+		Misses:         atomic.LoadUint64(&d.misses),
+		Backfilled:     atomic.LoadUint64(&d.backfilled),
+		BackfillErrors: atomic.LoadUint64(&d.backfillErrors),
+	}
+}
+
+// Close satisfies the io.Closer interface
+// Close closes both the primary and the fallback
+func (d *Database) Close() error {
+	err := d.Database.Close()
+	if fallbackErr := d.fallback.Close(); err == nil {
+		err = fallbackErr
+	}
+	return err
+}
+
+// backfill writes a value found in the fallback to the backfill writer, if there is one
+func (d *Database) backfill(key, val []byte) {
+	if d.config.Backfill == nil {
+		return
+	}
+	if err := d.config.Backfill.Put(key, val); err != nil {
+		atomic.AddUint64(&d.backfillErrors, 1)
+		log.Warnf("backfilling key %x: %v", key, err)
+		return
+	}
+	atomic.AddUint64(&d.backfilled, 1)
+}
+
+// combine returns the fallback's error, annotated with the primary's error if it had one
+func combine(primaryErr, fallbackErr error) error {
+	if primaryErr == nil {
+		return fallbackErr
+	}
+	return fmt.Errorf("%w (primary: %v)", fallbackErr, primaryErr)
+}
@@ -0,0 +1,157 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package layered_test
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/layered"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var (
+	testHeader    = types.Header{Number: big.NewInt(1337)}
+	testValue, _  = rlp.EncodeToBytes(&testHeader)
+	testEthKey    = testHeader.Hash().Bytes()
+	otherHeader   = types.Header{Number: big.NewInt(1338)}
+	otherValue, _ = rlp.EncodeToBytes(&otherHeader)
+	otherEthKey   = otherHeader.Hash().Bytes()
+)
+
+var _ = Describe("Database", func() {
+	var (
+		primary, fallback ethdb.Database
+		database          *layered.Database
+	)
+
+	BeforeEach(func() {
+		primary, fallback = rawdb.NewMemoryDatabase(), rawdb.NewMemoryDatabase()
+		database = layered.NewDatabase(primary, fallback, layered.Config{})
+		Expect(fallback.Put(testEthKey, testValue)).To(Succeed())
+	})
+
+	It("falls back to the fallback for the keys the primary misses", func() {
+		Expect(primary.Put(otherEthKey, otherValue)).To(Succeed())
+		val, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		val, err = database.Get(otherEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(otherValue))
+		_, err = database.Get([]byte("missing"))
+		Expect(err).To(HaveOccurred())
+
+		has, err := database.Has(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+		Expect(primary.Has(testEthKey)).To(BeFalse())
+		Expect(database.Stats()).To(Equal(layered.Stats{PrimaryHits: 1, FallbackHits: 1, Misses: 1}))
+	})
+
+	It("writes to the primary only", func() {
+		Expect(database.Put(otherEthKey, otherValue)).To(Succeed())
+		Expect(primary.Has(otherEthKey)).To(BeTrue())
+		Expect(fallback.Has(otherEthKey)).To(BeFalse())
+	})
+
+	It("backfills the primary with what it fetches, if asked to", func() {
+		database = layered.NewDatabase(primary, fallback, layered.Config{Backfill: primary})
+		values, err := database.GetMany([][]byte{testEthKey, []byte("missing")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{testValue, nil}))
+		Expect(primary.Get(testEthKey)).To(Equal(testValue))
+		Expect(database.Stats().Backfilled).To(Equal(uint64(1)))
+	})
+
+	It("routes prefixed Stat properties to the fallback", func() {
+		database = layered.NewDatabase(primary, ipfsethdb.ReadOnly(fallback), layered.Config{})
+		Expect(database.Stat(layered.FallbackPrefix + ipfsethdb.ReadOnlyProperty)).To(Equal("true"))
+	})
+})
+
+var _ = Describe("Database over Postgres and a blockservice", func() {
+	var (
+		db           *sqlx.DB
+		err          error
+		blockService *ipfsethdb.MockBlockservice
+		primary      *pgipfsethdb.Database
+		database     *layered.Database
+		cacheConfig  = pgipfsethdb.CacheConfig{
+			Name:           "layered",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		}
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		blockService = ipfsethdb.NewMockBlockservice().(*ipfsethdb.MockBlockservice)
+		Expect(ipfsethdb.NewDatabase(blockService).Put(testEthKey, testValue)).To(Succeed())
+
+		primary = pgipfsethdb.NewDatabase(db, cacheConfig).(*pgipfsethdb.Database)
+		backfill := primary.AtBlockNumber(testHeader.Number)
+		database = layered.NewDatabase(primary, ipfsethdb.NewDatabase(blockService), layered.Config{Backfill: backfill})
+	})
+	AfterEach(func() {
+		groupcache.DeregisterGroup(cacheConfig.Name)
+		Expect(shared.ResetTestDB(db)).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("fetches the blocks Postgres doesn't have through the blockservice, and backfills them", func() {
+		val, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		Expect(database.Stats().Backfilled).To(Equal(uint64(1)))
+
+		// the block is now served by Postgres
+		blockService.SetError(errors.New("exchange offline"))
+		val, err = database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		has, err := database.Has(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+	})
+
+	It("counts backfills without a block number as errors, rather than failing the reads", func() {
+		database = layered.NewDatabase(primary, ipfsethdb.NewDatabase(blockService), layered.Config{Backfill: primary})
+		val, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		values, err := database.GetMany([][]byte{testEthKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{testValue}))
+		Expect(database.Stats().Backfilled).To(BeZero())
+		Expect(database.Stats().BackfillErrors).To(Equal(uint64(2)))
+		Expect(primary.Has(testEthKey)).To(BeFalse())
+	})
+})
@@ -0,0 +1,436 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package mirror fans the writes to an ethdb.Database out to secondary databases
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	log "github.com/sirupsen/logrus"
+)
+
+// Consistency is the guarantee a write gives about the secondaries when it returns
+type Consistency int
+
+const (
+	// All writes to every backend before returning, failing if any of them fails
+	All Consistency = iota
+	// PrimaryAsync writes to the primary before returning, and queues the writes to the secondaries
+	// Secondary writes that fail are retried in the background, so that a lagging secondary doesn't hold up the primary
+	PrimaryAsync
+)
+
+var (
+	errInvalidConfig = errors.New("queue size, max attempts and report size must be positive")
+	errClosed        = errors.New("mirror is closed")
+
+	DefaultConfig = Config{
+		Consistency:    All,
+		QueueSize:      1024,
+		MaxAttempts:    5,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		ReportSize:     1024,
+	}
+)
+
+// Config holds the mirroring settings
+type Config struct {
+	Consistency Consistency
+	// QueueSize is the number of writes that can be pending for each secondary with PrimaryAsync,
+	// writes block once a secondary's queue is full
+	QueueSize int
+	// MaxAttempts is the number of times a queued write is tried before it is reported as a divergence
+	MaxAttempts int
+	// InitialBackoff is the wait before retrying a queued write, it doubles on each subsequent retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// ReportSize is the number of divergences kept for Report, the oldest are dropped first
+	ReportSize int
+}
+
+// Divergence is a key whose value in a secondary may differ from the primary's
+type Divergence struct {
+	// Backend is the index of the secondary, 0 is the first secondary
+	Backend int
+	Key     []byte
+	// Reason describes the failed write or the mismatch
+	Reason string
+	Time   time.Time
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("backend %d key %x: %s", d.Backend, d.Key, d.Reason)
+}
+
+// BackendStats holds the counters of a secondary
+type BackendStats struct {
+	// Pending is the number of queued writes
+	Pending int
+	Applied uint64
+	Retried uint64
+	Failed  uint64
+}
+
+// Report lists the divergences recorded since the last Reset, and the state of each secondary
+type Report struct {
+	Divergences []Divergence
+	// Dropped is the number of divergences that didn't fit in the report
+	Dropped  uint64
+	Backends []BackendStats
+}
+
+// op is a key to put, or to delete
+type op struct {
+	key, value []byte
+	delete     bool
+}
+
+// write is a list of ops applied together, a single op with Put or Delete and more with a batch
+type write []op
+
+func (w write) apply(db ethdb.KeyValueStore) error {
+	if len(w) == 1 {
+		if w[0].delete {
+			return db.Delete(w[0].key)
+		}
+		return db.Put(w[0].key, w[0].value)
+	}
+	b := db.NewBatchWithSize(len(w))
+	for _, o := range w {
+		var err error
+		if o.delete {
+			err = b.Delete(o.key)
+		} else {
+			err = b.Put(o.key, o.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return b.Write()
+}
+
+type secondary struct {
+	ethdb.Database
+	queue chan write
+	// pending counts the queued writes, including the one being applied
+	pending                  int64
+	applied, retried, failed uint64
+}
+
+var _ ethdb.Database = &Database{}
+
+// Database is an ethdb.Database that writes to a primary database and mirrors the writes to secondaries
+// e.g. a pgipfsethdb.Database alongside an ipfsethdb.Database while moving from an IPFS repo to Postgres
+// Reads, iterators and the ancient store are those of the primary
+type Database struct {
+	ethdb.Database
+	secondaries []*secondary
+	config      Config
+
+	mu          sync.Mutex
+	divergences []Divergence
+	dropped     uint64
+
+	// closeMu guards closing the queues, writes hold it for reading while they queue
+	closeMu sync.RWMutex
+	closed  bool
+
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// NewDatabase returns an ethdb.Database writing to the primary and mirroring to the secondaries
+func NewDatabase(primary ethdb.Database, secondaries []ethdb.Database, config Config) (*Database, error) {
+	if config.QueueSize <= 0 || config.MaxAttempts <= 0 || config.ReportSize <= 0 {
+		return nil, errInvalidConfig
+	}
+	d := &Database{Database: primary, config: config}
+	for _, db := range secondaries {
+		s := &secondary{Database: db}
+		if config.Consistency == PrimaryAsync {
+			s.queue = make(chan write, config.QueueSize)
+			d.wg.Add(1)
+			go d.work(len(d.secondaries), s)
+		}
+		d.secondaries = append(d.secondaries, s)
+	}
+	return d, nil
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put inserts the given value into the primary, and mirrors it to the secondaries
+func (d *Database) Put(key []byte, value []byte) error {
+	if err := d.Database.Put(key, value); err != nil {
+		return err
+	}
+	return d.mirror(write{{key: common.CopyBytes(key), value: common.CopyBytes(value)}})
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the primary, and from the secondaries
+func (d *Database) Delete(key []byte) error {
+	if err := d.Database.Delete(key); err != nil {
+		return err
+	}
+	return d.mirror(write{{key: common.CopyBytes(key), delete: true}})
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a batch that is written to the primary and mirrored to the secondaries
+func (d *Database) NewBatch() ethdb.Batch {
+	return &batch{Batch: d.Database.NewBatch(), db: d}
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface
+// NewBatchWithSize creates a mirrored batch with a pre-allocated buffer
+func (d *Database) NewBatchWithSize(size int) ethdb.Batch {
+	return &batch{Batch: d.Database.NewBatchWithSize(size), db: d, ops: make(write, 0, size)}
+}
+
+// Compare looks the keys up in the primary and each secondary, and returns the keys whose values differ
+// The divergences are not added to the report
+func (d *Database) Compare(keys [][]byte) ([]Divergence, error) {
+	var divergences []Divergence
+	for _, key := range keys {
+		want, err := get(d.Database, key)
+		if err != nil {
+			return nil, err
+		}
+		for i, s := range d.secondaries {
+			got, err := get(s, key)
+			reason := ""
+			switch {
+			case err != nil:
+				reason = err.Error()
+			case want == nil && got != nil:
+				reason = "not deleted"
+			case want != nil && got == nil:
+				reason = "missing"
+			case !bytes.Equal(want, got):
+				reason = "value differs"
+			default:
+				continue
+			}
+			divergences = append(divergences, Divergence{Backend: i, Key: key, Reason: reason, Time: time.Now()})
+		}
+	}
+	return divergences, nil
+}
+
+// Report returns the divergences recorded from failed writes to the secondaries, and the state of each secondary
+func (d *Database) Report() Report {
+	d.mu.Lock()
+	report := Report{Divergences: append([]Divergence(nil), d.divergences...), Dropped: d.dropped}
+	d.mu.Unlock()
+	for _, s := range d.secondaries {
+		report.Backends = append(report.Backends, BackendStats{
+			Pending: int(atomic.LoadInt64(&s.pending)),
+			Applied: atomic.LoadUint64(&s.applied),
+			Retried: atomic.LoadUint64(&s.retried),
+			Failed:  atomic.LoadUint64(&s.failed),
+		})
+	}
+	return report
+}
+
+// Flush waits for the writes queued for the secondaries to be applied, or to fail
+func (d *Database) Flush(ctx context.Context) error {
+	for _, s := range d.secondaries {
+		for atomic.LoadInt64(&s.pending) > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	return nil
+}
+
+// Reset clears the divergences of the report, e.g. once they have been repaired
+func (d *Database) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.divergences, d.dropped = nil, 0
+}
+
+// Close satisfies the io.Closer interface
+// Close waits for the queued writes to be applied, and closes the primary and the secondaries
+func (d *Database) Close() error {
+	d.once.Do(func() {
+		d.closeMu.Lock()
+		d.closed = true
+		for _, s := range d.secondaries {
+			if s.queue != nil {
+				close(s.queue)
+			}
+		}
+		d.closeMu.Unlock()
+		d.wg.Wait()
+	})
+	err := d.Database.Close()
+	for _, s := range d.secondaries {
+		if closeErr := s.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// mirror applies the write to the secondaries, or queues it with PrimaryAsync
+func (d *Database) mirror(w write) error {
+	if d.config.Consistency == PrimaryAsync {
+		d.closeMu.RLock()
+		defer d.closeMu.RUnlock()
+		if d.closed {
+			return errClosed
+		}
+		for _, s := range d.secondaries {
+			atomic.AddInt64(&s.pending, 1)
+			s.queue <- w
+		}
+		return nil
+	}
+	var firstErr error
+	for i, s := range d.secondaries {
+		if err := w.apply(s); err != nil {
+			atomic.AddUint64(&s.failed, 1)
+			d.diverged(i, w, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("mirroring to backend %d: %w", i, err)
+			}
+			continue
+		}
+		atomic.AddUint64(&s.applied, 1)
+	}
+	return firstErr
+}
+
+// work applies the queued writes to a secondary, in order, retrying those that fail
+func (d *Database) work(i int, s *secondary) {
+	defer d.wg.Done()
+	for w := range s.queue {
+		d.applyQueued(i, s, w)
+		atomic.AddInt64(&s.pending, -1)
+	}
+}
+
+// applyQueued applies a queued write to a secondary, retrying it if it fails
+func (d *Database) applyQueued(i int, s *secondary, w write) {
+	backoff := d.config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := w.apply(s)
+		if err == nil {
+			atomic.AddUint64(&s.applied, 1)
+			return
+		}
+		if attempt >= d.config.MaxAttempts {
+			atomic.AddUint64(&s.failed, 1)
+			d.diverged(i, w, err)
+			return
+		}
+		atomic.AddUint64(&s.retried, 1)
+		time.Sleep(backoff)
+		if backoff *= 2; d.config.MaxBackoff > 0 && backoff > d.config.MaxBackoff {
+			backoff = d.config.MaxBackoff
+		}
+	}
+}
+
+// diverged records the keys of a write that failed on a secondary
+func (d *Database) diverged(i int, w write, err error) {
+	log.Warnf("mirroring %d keys to backend %d: %v", len(w), i, err)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for _, o := range w {
+		reason := "put failed: " + err.Error()
+		if o.delete {
+			reason = "delete failed: " + err.Error()
+		}
+		d.divergences = append(d.divergences, Divergence{Backend: i, Key: o.key, Reason: reason, Time: now})
+	}
+	if over := len(d.divergences) - d.config.ReportSize; over > 0 {
+		d.divergences = append(d.divergences[:0:0], d.divergences[over:]...)
+		d.dropped += uint64(over)
+	}
+}
+
+// get returns the value of the key, or nil if the store doesn't have it
+func get(db ethdb.KeyValueReader, key []byte) ([]byte, error) {
+	has, err := db.Has(key)
+	if err != nil || !has {
+		return nil, err
+	}
+	return db.Get(key)
+}
+
+// batch wraps the primary's batch, recording its operations so they can be mirrored when it is written
+type batch struct {
+	ethdb.Batch
+	db  *Database
+	ops write
+}
+
+// Put satisfies the ethdb.Batch interface
+// Put inserts the given value into the batch for later committing
+func (b *batch) Put(key []byte, value []byte) error {
+	if err := b.Batch.Put(key, value); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, op{key: common.CopyBytes(key), value: common.CopyBytes(value)})
+	return nil
+}
+
+// Delete satisfies the ethdb.Batch interface
+// Delete inserts a key removal into the batch for later committing
+func (b *batch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, op{key: common.CopyBytes(key), delete: true})
+	return nil
+}
+
+// Write satisfies the ethdb.Batch interface
+// Write flushes the batch to the primary, and mirrors it to the secondaries
+func (b *batch) Write() error {
+	if err := b.Batch.Write(); err != nil {
+		return err
+	}
+	if len(b.ops) == 0 {
+		return nil
+	}
+	return b.db.mirror(b.ops)
+}
+
+// Reset satisfies the ethdb.Batch interface
+// Reset resets the batch for reuse
+func (b *batch) Reset() {
+	b.Batch.Reset()
+	b.ops = b.ops[:0:0]
+}
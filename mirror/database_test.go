@@ -0,0 +1,126 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mirror_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/mirror"
+)
+
+var errFlaky = errors.New("backend unavailable")
+
+// flaky is a database whose writes fail until it has been written to a set number of times
+type flaky struct {
+	ethdb.Database
+	failures int64
+}
+
+func (f *flaky) Put(key []byte, value []byte) error {
+	if atomic.AddInt64(&f.failures, -1) >= 0 {
+		return errFlaky
+	}
+	return f.Database.Put(key, value)
+}
+
+var _ = Describe("Database", func() {
+	var (
+		primary, secondary ethdb.Database
+		config             mirror.Config
+		key, value         = []byte("key"), []byte("value")
+	)
+
+	BeforeEach(func() {
+		primary, secondary = rawdb.NewMemoryDatabase(), rawdb.NewMemoryDatabase()
+		config = mirror.DefaultConfig
+		config.InitialBackoff = time.Millisecond
+	})
+
+	It("writes to all the backends", func() {
+		database, err := mirror.NewDatabase(primary, []ethdb.Database{secondary}, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Put(key, value)).To(Succeed())
+		batch := database.NewBatch()
+		Expect(batch.Put([]byte("other"), value)).To(Succeed())
+		Expect(batch.Delete(key)).To(Succeed())
+		Expect(batch.Write()).To(Succeed())
+
+		for _, db := range []ethdb.Database{primary, secondary} {
+			Expect(db.Has(key)).To(BeFalse())
+			Expect(db.Get([]byte("other"))).To(Equal(value))
+		}
+		divergences, err := database.Compare([][]byte{key, []byte("other")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(divergences).To(BeEmpty())
+	})
+
+	It("fails writes that a secondary fails with All, and reports them", func() {
+		database, err := mirror.NewDatabase(primary, []ethdb.Database{&flaky{Database: secondary, failures: 1}}, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Put(key, value)).To(MatchError(errFlaky))
+		Expect(primary.Get(key)).To(Equal(value))
+
+		report := database.Report()
+		Expect(report.Divergences).To(HaveLen(1))
+		Expect(report.Divergences[0].Key).To(Equal(key))
+		Expect(report.Backends).To(Equal([]mirror.BackendStats{{Failed: 1}}))
+		divergences, err := database.Compare([][]byte{key})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(divergences).To(HaveLen(1))
+		Expect(divergences[0].Reason).To(Equal("missing"))
+
+		database.Reset()
+		Expect(database.Report().Divergences).To(BeEmpty())
+	})
+
+	It("retries the writes to lagging secondaries with PrimaryAsync", func() {
+		config.Consistency = mirror.PrimaryAsync
+		database, err := mirror.NewDatabase(primary, []ethdb.Database{&flaky{Database: secondary, failures: 2}}, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Put(key, value)).To(Succeed())
+		Expect(database.Flush(context.Background())).To(Succeed())
+
+		Expect(secondary.Get(key)).To(Equal(value))
+		report := database.Report()
+		Expect(report.Divergences).To(BeEmpty())
+		Expect(report.Backends).To(Equal([]mirror.BackendStats{{Applied: 1, Retried: 2}}))
+		Expect(database.Close()).To(Succeed())
+		Expect(database.Put(key, value)).To(HaveOccurred())
+	})
+
+	It("reports the writes that run out of attempts", func() {
+		config.Consistency = mirror.PrimaryAsync
+		config.MaxAttempts = 2
+		database, err := mirror.NewDatabase(primary, []ethdb.Database{&flaky{Database: secondary, failures: 2}}, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Put(key, value)).To(Succeed())
+		Expect(database.Flush(context.Background())).To(Succeed())
+
+		report := database.Report()
+		Expect(report.Divergences).To(HaveLen(1))
+		Expect(report.Backends[0].Failed).To(Equal(uint64(1)))
+		Expect(secondary.Has(key)).To(BeFalse())
+	})
+})
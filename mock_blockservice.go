@@ -71,6 +71,7 @@ func (mbs *MockBlockservice) GetBlock(ctx context.Context, c cid.Cid) (blocks.Bl
 func (mbs *MockBlockservice) GetBlocks(ctx context.Context, cs []cid.Cid) <-chan blocks.Block {
 	blockChan := make(chan blocks.Block)
 	go func() {
+		defer close(blockChan)
 		for _, c := range cs {
 			if b, err := mbs.blockStore.Get(ctx, c); err == nil {
 				blockChan <- b
@@ -0,0 +1,275 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package keymigration rewrites the postgres/v0 CID string keys of a blocks table into the multihash keys used by postgres/v1
+package keymigration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+const (
+	// the checkpoints are kept in the key migrations table of the target, see shared.Config.KeyMigrationsTableName
+	checkpointTableExistsPgStr = "SELECT to_regclass($1) IS NOT NULL"
+	getCheckpointPgStr         = "SELECT block_number, key, migrated, skipped FROM %s WHERE name = $1"
+	putCheckpointPgStr         = `INSERT INTO %s (name, block_number, key, migrated, skipped) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (name) DO UPDATE SET block_number = $2, key = $3, migrated = $4, skipped = $5, updated_at = now()`
+	deleteCheckpointPgStr = "DELETE FROM %s WHERE name = $1"
+
+	// nextRowsPgStr pages through the rows that don't have a multihash key yet, in (block_number, key) order
+	nextRowsPgStr = `SELECT key, data, block_number FROM %s
+WHERE (block_number, key) > ($1, $2) AND block_number <= $3 AND key NOT LIKE '/blocks/%%'
+ORDER BY block_number, key LIMIT $4`
+	insertRowsPgStr = `INSERT INTO %s (key, data, block_number)
+SELECT * FROM unnest($1::TEXT[], $2::BYTEA[], $3::BIGINT[]) ON CONFLICT DO NOTHING`
+	deleteRowsPgStr = `DELETE FROM %s WHERE (key, block_number) IN (SELECT * FROM unnest($1::TEXT[], $2::BIGINT[]))`
+)
+
+var (
+	// ErrNoCheckpointTable is returned when the target's schema doesn't have the checkpoint table yet
+	ErrNoCheckpointTable = errors.New("the key migrations table does not exist, create it with migrations.Migrate")
+
+	DefaultConfig = Config{
+		Source:    shared.DefaultConfig,
+		Target:    shared.DefaultConfig,
+		BatchSize: 1000,
+	}
+)
+
+// Config holds the settings of a key migration
+type Config struct {
+	// Source is the table with the postgres/v0 CID string keys
+	Source shared.Config
+	// Target is the table the rows are written to with multihash keys, the same as Source to rewrite the keys in place
+	Target shared.Config
+	// DeleteSource removes the migrated rows from a separate Source table, they are always removed when migrating in place
+	DeleteSource bool
+	// BatchSize is the number of rows migrated, and checkpointed, in each transaction
+	BatchSize int
+	// FromBlock and ToBlock bound the block numbers of the rows that are migrated, a zero ToBlock means no upper bound
+	FromBlock, ToBlock uint64
+	// Progress, if set, is called after each batch is committed
+	Progress func(Progress)
+}
+
+// Name identifies the migration's checkpoint, it is the same for all migrations between the same tables
+func (c Config) Name() string {
+	return c.Source.TableName() + " -> " + c.Target.TableName()
+}
+
+func (c Config) inPlace() bool {
+	return c.Source.TableName() == c.Target.TableName()
+}
+
+// Progress reports how far a migration has got
+type Progress struct {
+	// BlockNumber is the block number of the last row migrated or skipped
+	BlockNumber uint64
+	// Migrated is the number of rows written with a multihash key
+	Migrated int64
+	// Skipped is the number of rows left as they are, as their key is not a CID
+	Skipped int64
+	// Done is set once there are no rows left to migrate
+	Done bool
+}
+
+// V1Key converts a postgres/v0 CID string key into the equivalent postgres/v1 multihash key
+func V1Key(v0Key string) (string, error) {
+	c, err := cid.Decode(v0Key)
+	if err != nil {
+		return "", err
+	}
+	return pgipfsethdb.MultihashKeyFromCID(c), nil
+}
+
+// Migrate rewrites the keys of the rows in the source table, in block number order, until none are left or the context
+// is cancelled
+// Each batch is committed along with a checkpoint, so an interrupted migration resumes where it left off when it is run
+// again with the same source and target tables
+func Migrate(ctx context.Context, db *sqlx.DB, config Config) (Progress, error) {
+	if err := config.Source.Validate(); err != nil {
+		return Progress{}, err
+	}
+	if err := config.Target.Validate(); err != nil {
+		return Progress{}, err
+	}
+	if config.BatchSize <= 0 {
+		return Progress{}, fmt.Errorf("invalid batch size %d", config.BatchSize)
+	}
+	var exists bool
+	if err := db.Get(&exists, checkpointTableExistsPgStr, config.Target.KeyMigrationsTableName()); err != nil {
+		return Progress{}, err
+	}
+	if !exists {
+		return Progress{}, fmt.Errorf("%w: %s", ErrNoCheckpointTable, config.Target.KeyMigrationsTableName())
+	}
+
+	m := migration{
+		db:         db,
+		config:     config,
+		nextRows:   fmt.Sprintf(nextRowsPgStr, config.Source.TableName()),
+		insertRows: fmt.Sprintf(insertRowsPgStr, config.Target.TableName()),
+		deleteRows: fmt.Sprintf(deleteRowsPgStr, config.Source.TableName()),
+		toBlock:    config.ToBlock,
+	}
+	if m.toBlock == 0 || m.toBlock > math.MaxInt64 {
+		m.toBlock = math.MaxInt64
+	}
+	if err := m.loadCheckpoint(); err != nil {
+		return Progress{}, err
+	}
+	for !m.progress.Done {
+		if err := ctx.Err(); err != nil {
+			return m.progress, err
+		}
+		if err := m.step(ctx); err != nil {
+			return m.progress, err
+		}
+		if config.Progress != nil {
+			config.Progress(m.progress)
+		}
+	}
+	return m.progress, nil
+}
+
+// Reset deletes the checkpoint of the migration, so that it starts from the beginning the next time it is run
+func Reset(db *sqlx.DB, config Config) error {
+	_, err := db.Exec(fmt.Sprintf(deleteCheckpointPgStr, config.Target.KeyMigrationsTableName()), config.Name())
+	return err
+}
+
+type migration struct {
+	db                               *sqlx.DB
+	config                           Config
+	nextRows, insertRows, deleteRows string
+	toBlock                          uint64
+
+	// the cursor is the (block_number, key) of the last row migrated or skipped
+	cursorBlock uint64
+	cursorKey   string
+	progress    Progress
+}
+
+func (m *migration) loadCheckpoint() error {
+	var checkpoint struct {
+		BlockNumber uint64 `db:"block_number"`
+		Key         string `db:"key"`
+		Migrated    int64  `db:"migrated"`
+		Skipped     int64  `db:"skipped"`
+	}
+	err := m.db.Get(&checkpoint, fmt.Sprintf(getCheckpointPgStr, m.config.Target.KeyMigrationsTableName()), m.config.Name())
+	switch {
+	case err == nil:
+		log.Infof("resuming key migration %s from block %d", m.config.Name(), checkpoint.BlockNumber)
+		m.cursorBlock, m.cursorKey = checkpoint.BlockNumber, checkpoint.Key
+		m.progress = Progress{BlockNumber: checkpoint.BlockNumber, Migrated: checkpoint.Migrated, Skipped: checkpoint.Skipped}
+		if m.cursorBlock < m.config.FromBlock {
+			m.cursorBlock, m.cursorKey = m.config.FromBlock, ""
+		}
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		m.cursorBlock = m.config.FromBlock
+		return nil
+	default:
+		return err
+	}
+}
+
+// step migrates the next batch of rows and checkpoints it in a single transaction
+func (m *migration) step(ctx context.Context) (err error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	rows, err := tx.QueryxContext(ctx, m.nextRows, m.cursorBlock, m.cursorKey, m.toBlock, m.config.BatchSize)
+	if err != nil {
+		return err
+	}
+	var (
+		oldKeys, newKeys []string
+		data             [][]byte
+		blockNumbers     []int64
+		read             int
+		progress         = m.progress
+		cursorBlock      = m.cursorBlock
+		cursorKey        = m.cursorKey
+	)
+	for rows.Next() {
+		var key string
+		var value []byte
+		var blockNumber uint64
+		if err = rows.Scan(&key, &value, &blockNumber); err != nil {
+			rows.Close()
+			return err
+		}
+		read++
+		cursorBlock, cursorKey = blockNumber, key
+		newKey, decodeErr := V1Key(key)
+		if decodeErr != nil {
+			log.Warnf("skipping key %s at block %d, it is not a CID: %v", key, blockNumber, decodeErr)
+			progress.Skipped++
+			continue
+		}
+		oldKeys = append(oldKeys, key)
+		newKeys = append(newKeys, newKey)
+		data = append(data, value)
+		blockNumbers = append(blockNumbers, int64(blockNumber))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(newKeys) > 0 {
+		if _, err = tx.ExecContext(ctx, m.insertRows, pq.Array(newKeys), pq.ByteaArray(data), pq.Array(blockNumbers)); err != nil {
+			return err
+		}
+		if m.config.inPlace() || m.config.DeleteSource {
+			if _, err = tx.ExecContext(ctx, m.deleteRows, pq.Array(oldKeys), pq.Array(blockNumbers)); err != nil {
+				return err
+			}
+		}
+		progress.Migrated += int64(len(newKeys))
+	}
+	progress.BlockNumber = cursorBlock
+	progress.Done = read < m.config.BatchSize
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(putCheckpointPgStr, m.config.Target.KeyMigrationsTableName()), m.config.Name(), cursorBlock, cursorKey, progress.Migrated, progress.Skipped); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	m.cursorBlock, m.cursorKey, m.progress = cursorBlock, cursorKey, progress
+	return nil
+}
@@ -0,0 +1,229 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package keymigration_test
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/keymigration"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/migrations"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	v0 "github.com/cerc-io/ipfs-ethdb/v5/postgres/v0"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+type testBlock struct {
+	ethKey, value []byte
+	cid           cid.Cid
+	blockNumber   int64
+}
+
+func newTestBlock(blockNumber int64) testBlock {
+	header := types.Header{Number: big.NewInt(blockNumber)}
+	value, err := rlp.EncodeToBytes(&header)
+	Expect(err).ToNot(HaveOccurred())
+	ethKey := header.Hash().Bytes()
+	c, err := v0.CIDFromKeccak256(ethKey, cid.EthBlock)
+	Expect(err).ToNot(HaveOccurred())
+	return testBlock{ethKey: ethKey, value: value, cid: c, blockNumber: blockNumber}
+}
+
+var _ = Describe("V1Key", func() {
+	It("converts a CID string key to the multihash key of the same hash", func() {
+		block := newTestBlock(1)
+		mhKey, err := pgipfsethdb.MultihashKeyFromKeccak256(block.ethKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		key, err := keymigration.V1Key(block.cid.String())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(key).To(Equal(mhKey))
+	})
+
+	It("rejects keys that aren't CIDs", func() {
+		_, err := keymigration.V1Key("not a cid")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Migrate", func() {
+	var (
+		db          *sqlx.DB
+		err         error
+		blocks      []testBlock
+		ctx         = context.Background()
+		v0Config    = shared.Config{Schema: "ipld", Table: "v0_blocks"}
+		v1Config    = shared.Config{Schema: "ipld", Table: "v1_blocks"}
+		cacheConfig = pgipfsethdb.CacheConfig{
+			Name:           "keymigration",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		}
+
+		expectReadable = func(config shared.Config) {
+			database, err := pgipfsethdb.NewDatabaseWithConfig(db, config, cacheConfig)
+			Expect(err).ToNot(HaveOccurred())
+			defer groupcache.DeregisterGroup(cacheConfig.Name)
+			for _, block := range blocks {
+				val, err := database.Get(block.ethKey)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(val).To(Equal(block.value))
+			}
+		}
+		count = func(config shared.Config) (n int) {
+			Expect(db.Get(&n, "SELECT count(*) FROM "+config.TableName())).To(Succeed())
+			return n
+		}
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(migrations.Migrate(db, v0Config, migrations.LatestVersion)).To(Succeed())
+		Expect(migrations.Migrate(db, v1Config, migrations.LatestVersion)).To(Succeed())
+
+		blocks = nil
+		for i := int64(1); i <= 5; i++ {
+			block := newTestBlock(i)
+			blocks = append(blocks, block)
+			_, err = db.Exec("INSERT INTO ipld.v0_blocks (key, data, block_number) VALUES ($1, $2, $3)",
+				block.cid.String(), block.value, block.blockNumber)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		_, err = db.Exec("INSERT INTO ipld.v0_blocks (key, data, block_number) VALUES ('not a cid', '\\x01', 3)")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(migrations.Migrate(db, v0Config, 0)).To(Succeed())
+		Expect(migrations.Migrate(db, v1Config, 0)).To(Succeed())
+		// leave the test db as ipld-eth-db sets it up, with an externally managed schema
+		_, err = db.Exec("DROP TABLE ipld.ethdb_schema_version")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("rewrites the keys in place", func() {
+		config := keymigration.DefaultConfig
+		config.Source, config.Target = v0Config, v0Config
+		config.BatchSize = 2
+		var reports []keymigration.Progress
+		config.Progress = func(p keymigration.Progress) { reports = append(reports, p) }
+
+		progress, err := keymigration.Migrate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress).To(Equal(keymigration.Progress{BlockNumber: 5, Migrated: 5, Skipped: 1, Done: true}))
+		Expect(reports).To(HaveLen(4))
+		Expect(reports[0].BlockNumber).To(Equal(uint64(2)))
+
+		expectReadable(v0Config)
+		Expect(count(v0Config)).To(Equal(6))
+	})
+
+	It("copies the rows into a new table, optionally deleting them from the source", func() {
+		config := keymigration.DefaultConfig
+		config.Source, config.Target = v0Config, v1Config
+		progress, err := keymigration.Migrate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Migrated).To(Equal(int64(5)))
+		expectReadable(v1Config)
+		Expect(count(v0Config)).To(Equal(6))
+
+		_, err = db.Exec("TRUNCATE " + v1Config.TableName())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keymigration.Reset(db, config)).To(Succeed())
+		config.DeleteSource = true
+		_, err = keymigration.Migrate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		expectReadable(v1Config)
+		Expect(count(v0Config)).To(Equal(1))
+	})
+
+	It("resumes from its checkpoint", func() {
+		config := keymigration.DefaultConfig
+		config.Source, config.Target = v0Config, v1Config
+		config.BatchSize = 1
+		cancelCtx, cancel := context.WithCancel(ctx)
+		config.Progress = func(keymigration.Progress) { cancel() }
+
+		progress, err := keymigration.Migrate(cancelCtx, db, config)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(progress.Migrated).To(Equal(int64(1)))
+		Expect(count(v1Config)).To(Equal(1))
+
+		config.Progress = nil
+		progress, err = keymigration.Migrate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress).To(Equal(keymigration.Progress{BlockNumber: 5, Migrated: 5, Skipped: 1, Done: true}))
+		expectReadable(v1Config)
+	})
+
+	It("only migrates the rows in the block range", func() {
+		config := keymigration.DefaultConfig
+		config.Source, config.Target = v0Config, v1Config
+		config.FromBlock, config.ToBlock = 2, 4
+		progress, err := keymigration.Migrate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Migrated).To(Equal(int64(3)))
+		Expect(count(v1Config)).To(Equal(3))
+	})
+
+	It("lets v1 read both key forms part way through the migration", func() {
+		config := keymigration.DefaultConfig
+		config.Source, config.Target = v0Config, v0Config
+		config.ToBlock = 2
+		_, err = keymigration.Migrate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+
+		dualRead := v0Config
+		dualRead.DualRead = true
+		expectReadable(dualRead)
+
+		database, err := pgipfsethdb.NewDatabaseWithConfig(db, dualRead, cacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+		defer groupcache.DeregisterGroup(cacheConfig.Name)
+		has, err := database.Has(blocks[4].ethKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+		values, err := database.(*pgipfsethdb.Database).GetMany([][]byte{blocks[0].ethKey, blocks[4].ethKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{blocks[0].value, blocks[4].value}))
+
+		pool, err := shared.TestPgxPool()
+		Expect(err).ToNot(HaveOccurred())
+		pgxCacheConfig := cacheConfig
+		pgxCacheConfig.Name = "pgx_" + cacheConfig.Name
+		pgxDatabase, err := pgipfsethdb.NewPgxDatabaseWithConfig(pool, dualRead, pgxCacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+		defer pgxDatabase.Close()
+		has, err = pgxDatabase.Has(blocks[4].ethKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+		Expect(pgxDatabase.Get(blocks[4].ethKey)).To(Equal(blocks[4].value))
+		values, err = pgxDatabase.(*pgipfsethdb.PgxDatabase).GetMany([][]byte{blocks[0].ethKey, blocks[4].ethKey})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{blocks[0].value, blocks[4].value}))
+	})
+})
@@ -0,0 +1,143 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package keyrotation re-encrypts the values of the existing rows of a blocks table with the current key of an encrypted.KeyProvider
+package keyrotation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/encrypted"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var DefaultConfig = Config{
+	Table:     shared.DefaultConfig,
+	EthKey:    keycodec.DigestFromKey,
+	BatchSize: 1000,
+}
+
+// Config holds the settings of a key rotation
+type Config struct {
+	// Table is the blocks table whose rows are re-encrypted
+	Table shared.Config
+	// KeyProvider supplies the current key, and the keys the rows were sealed with
+	KeyProvider encrypted.KeyProvider
+	// EthKey returns the ethdb key of a row's key, which its value is sealed for
+	// keycodec.DigestFromKey by default, for tables written with a keycodec.Multihash codec
+	EthKey func(key string) ([]byte, error)
+	// EncryptPlaintext also seals the values that aren't sealed, e.g. the rows written before encryption was enabled
+	// Otherwise they are left as they are
+	EncryptPlaintext bool
+	// BatchSize is the number of rows read, and updated, in each transaction
+	BatchSize int
+	// FromBlock and ToBlock bound the block numbers of the rows that are re-encrypted, a zero ToBlock means no upper bound
+	// A job that is interrupted can be resumed from the BlockNumber of its last Progress
+	FromBlock, ToBlock uint64
+	// Pause is the time waited between batches, to limit the load the job puts on the database
+	Pause time.Duration
+	// Progress, if set, is called after each batch is committed
+	Progress func(Progress)
+}
+
+// Progress reports how far a rotation has got
+type Progress struct {
+	// BlockNumber is the block number of the last row read
+	BlockNumber uint64
+	// Resealed is the number of rows re-encrypted with the current key
+	Resealed int64
+	// Encrypted is the number of plaintext rows sealed, with EncryptPlaintext
+	Encrypted int64
+	// Skipped is the number of rows left as they are, as they are already sealed with the current key or are plaintext
+	Skipped int64
+	// Done is set once all the rows in the range have been read
+	Done bool
+}
+
+// Rotate re-encrypts the rows of the table with the current key, in block number order,
+// until none are left or the context is cancelled
+// It is meant to be run in the background after a new key is made current, while the encrypted ethdbs can still open
+// the rows sealed with the earlier keys; once it is done the earlier keys can be retired
+// A row that is sealed with a key the provider doesn't have, or that fails to open, stops the rotation with an error
+// Rows are rewritten in place, so the rotation is safe to run again over rows it has already re-encrypted
+func Rotate(ctx context.Context, db *sqlx.DB, config Config) (Progress, error) {
+	pager := shared.Pager{
+		Table:     config.Table,
+		BatchSize: config.BatchSize,
+		FromBlock: config.FromBlock,
+		ToBlock:   config.ToBlock,
+		Pause:     config.Pause,
+	}
+	if err := pager.Validate(); err != nil {
+		return Progress{}, err
+	}
+	if config.EthKey == nil {
+		return Progress{}, errors.New("an EthKey function is required")
+	}
+	sealer, err := encrypted.NewSealer(config.KeyProvider)
+	if err != nil {
+		return Progress{}, err
+	}
+	var progress, pending Progress
+	rewrite := func(rows []shared.Row) ([]shared.Row, error) {
+		var updates []shared.Row
+		pending = progress
+		for _, row := range rows {
+			// sealed values don't compress, so they are written uncompressed
+			value := shared.Decompress(row.Data)
+			if _, err := encrypted.KeyID(value); errors.Is(err, encrypted.ErrNotSealed) && !config.EncryptPlaintext {
+				pending.Skipped++
+				continue
+			}
+			key, err := config.EthKey(row.Key)
+			if err != nil {
+				return nil, fmt.Errorf("re-encrypting row %s at block %d: %w", row.Key, row.BlockNumber, err)
+			}
+			resealed, changed, err := sealer.Reseal(key, value)
+			switch {
+			case errors.Is(err, encrypted.ErrNotSealed):
+				if resealed, err = sealer.Seal(key, value); err != nil {
+					return nil, err
+				}
+				pending.Encrypted++
+			case err != nil:
+				return nil, fmt.Errorf("re-encrypting row %s at block %d: %w", row.Key, row.BlockNumber, err)
+			case changed:
+				pending.Resealed++
+			default:
+				pending.Skipped++
+				continue
+			}
+			updates = append(updates, shared.Row{Key: row.Key, Data: resealed, BlockNumber: row.BlockNumber})
+		}
+		return updates, nil
+	}
+	committed := func(blockNumber uint64, done bool) {
+		progress = pending
+		progress.BlockNumber, progress.Done = blockNumber, done
+		if config.Progress != nil {
+			config.Progress(progress)
+		}
+	}
+	err = pager.Run(ctx, db, rewrite, committed)
+	return progress, err
+}
@@ -0,0 +1,151 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package keyrotation_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/encrypted"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/keyrotation"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var _ = Describe("Rotate", func() {
+	var (
+		db          *sqlx.DB
+		err         error
+		ctx         = context.Background()
+		ring        *encrypted.KeyRing
+		keys        [][]byte
+		values      [][]byte
+		cacheConfig = pgipfsethdb.CacheConfig{
+			Name:           "keyrotation",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		}
+
+		newStore = func() *encrypted.KeyValueStore {
+			database, err := pgipfsethdb.NewKeyValueStoreWithConfig(db, pgipfsethdb.DefaultConfig, cacheConfig)
+			Expect(err).ToNot(HaveOccurred())
+			kvs, err := encrypted.NewKeyValueStore(database, ring, encrypted.Config{})
+			Expect(err).ToNot(HaveOccurred())
+			return kvs
+		}
+		expectReadable = func() {
+			kvs := newStore()
+			defer groupcache.DeregisterGroup(cacheConfig.Name)
+			for i, key := range keys {
+				val, err := kvs.Get(key)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(val).To(Equal(values[i]))
+			}
+		}
+		keyIDs = func() map[uint32]int {
+			var stored [][]byte
+			Expect(db.Select(&stored, "SELECT data FROM ipld.blocks")).To(Succeed())
+			ids := make(map[uint32]int)
+			for _, data := range stored {
+				if id, err := encrypted.KeyID(data); err == nil {
+					ids[id]++
+				}
+			}
+			return ids
+		}
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		ring, err = encrypted.NewKeyRing(1, map[uint32][]byte{1: bytes.Repeat([]byte{1}, 32)})
+		Expect(err).ToNot(HaveOccurred())
+
+		keys, values = nil, nil
+		kvs := newStore()
+		database := kvs.KeyValueStore.(*pgipfsethdb.Database)
+		for i := 1; i <= 5; i++ {
+			database.BlockNumber = big.NewInt(int64(i))
+			key := bytes.Repeat([]byte{byte(i)}, 32)
+			value := []byte(fmt.Sprintf("mockValue%d", i))
+			Expect(kvs.Put(key, value)).To(Succeed())
+			keys, values = append(keys, key), append(values, value)
+		}
+		groupcache.DeregisterGroup(cacheConfig.Name)
+		Expect(ring.Add(2, bytes.Repeat([]byte{2}, 32))).To(Succeed())
+		Expect(ring.SetCurrent(2)).To(Succeed())
+	})
+	AfterEach(func() {
+		Expect(shared.ResetTestDB(db)).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("re-encrypts the rows with the current key", func() {
+		config := keyrotation.DefaultConfig
+		config.KeyProvider = ring
+		config.BatchSize = 2
+		progress, err := keyrotation.Rotate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress).To(Equal(keyrotation.Progress{BlockNumber: 5, Resealed: 5, Done: true}))
+		Expect(keyIDs()).To(Equal(map[uint32]int{2: 5}))
+		expectReadable()
+
+		progress, err = keyrotation.Rotate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Skipped).To(Equal(int64(5)))
+	})
+
+	It("seals plaintext rows if asked to", func() {
+		plainKey := bytes.Repeat([]byte{9}, 32)
+		dbKey, err := keycodec.Multihash{}.Key(plainKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = db.Exec("INSERT INTO ipld.blocks (key, data, block_number) VALUES ($1, 'value', 3)", dbKey)
+		Expect(err).ToNot(HaveOccurred())
+		config := keyrotation.DefaultConfig
+		config.KeyProvider = ring
+		progress, err := keyrotation.Rotate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Skipped).To(Equal(int64(1)))
+
+		config.EncryptPlaintext = true
+		progress, err = keyrotation.Rotate(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Encrypted).To(Equal(int64(1)))
+		Expect(keyIDs()).To(Equal(map[uint32]int{2: 6}))
+		keys, values = append(keys, plainKey), append(values, []byte("value"))
+		expectReadable()
+	})
+
+	It("stops at rows sealed with a key the provider doesn't have", func() {
+		other, err := encrypted.NewKeyRing(3, map[uint32][]byte{3: bytes.Repeat([]byte{3}, 32)})
+		Expect(err).ToNot(HaveOccurred())
+		config := keyrotation.DefaultConfig
+		config.KeyProvider = other
+		_, err = keyrotation.Rotate(ctx, db, config)
+		Expect(err).To(MatchError(encrypted.ErrUnknownKey))
+		Expect(keyIDs()).To(Equal(map[uint32]int{1: 5}))
+	})
+})
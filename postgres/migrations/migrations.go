@@ -0,0 +1,264 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package migrations creates and upgrades the Postgres schema used by the ipfs-ethdb Postgres ethdbs
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+const (
+	// the version table is in the schema of the blocks table, and records the version of each blocks table in it
+	createVersionTablePgStr = `CREATE SCHEMA IF NOT EXISTS %[1]s;
+CREATE TABLE IF NOT EXISTS %[1]s.ethdb_schema_version (
+    table_name TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (table_name, version)
+)`
+	// lockPgStr serializes concurrent migrations, the key is arbitrary but fixed
+	lockPgStr          = "SELECT pg_advisory_xact_lock(7306229845036381537)"
+	versionPgStr       = "SELECT coalesce(max(version), 0) FROM %s.ethdb_schema_version WHERE table_name = $1"
+	insertVersionPgStr = "INSERT INTO %s.ethdb_schema_version (table_name, version) VALUES ($1, $2)"
+	deleteVersionPgStr = "DELETE FROM %s.ethdb_schema_version WHERE table_name = $1 AND version = $2"
+
+	tableExistsPgStr = "SELECT to_regclass($1) IS NOT NULL"
+)
+
+var (
+	// ErrSchemaOutdated is returned when the schema is older than this version of the library requires
+	ErrSchemaOutdated = errors.New("blocks table schema is older than this version of ipfs-ethdb requires")
+	// ErrSchemaMissing is returned when neither the blocks table nor the schema version table exist
+	ErrSchemaMissing = errors.New("blocks table does not exist")
+
+	migrations = mustLoad()
+	// LatestVersion is the schema version this version of the library requires
+	LatestVersion = len(migrations)
+)
+
+// migration is a schema version, and the SQL that upgrades the previous version to it and that downgrades it again
+// The SQL is a template over the names of a blocks table's objects
+type migration struct {
+	version  int
+	name     string
+	up, down *template.Template
+}
+
+// names are the quoted names of the objects of a blocks table that the migrations create
+type names struct {
+	Schema           string
+	Blocks           string
+	BlockNumberIndex string
+	KeyMigrations    string
+}
+
+func newNames(config shared.Config) names {
+	return names{
+		Schema:           pq.QuoteIdentifier(config.Schema),
+		Blocks:           config.TableName(),
+		BlockNumberIndex: pq.QuoteIdentifier(config.Table + "_block_number_index"),
+		KeyMigrations:    config.KeyMigrationsTableName(),
+	}
+}
+
+// render returns the SQL of the template for the names
+func render(t *template.Template, n names) (string, error) {
+	var sql strings.Builder
+	if err := t.Execute(&sql, n); err != nil {
+		return "", err
+	}
+	return sql.String(), nil
+}
+
+// mustLoad parses the embedded migrations, named <version>_<name>.up.sql and <version>_<name>.down.sql
+// The versions must be numbered from 1 without gaps
+func mustLoad() []migration {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		panic(err)
+	}
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, rest, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			panic(fmt.Sprintf("migration %s is not named <version>_<name>.<up|down>.sql", name))
+		}
+		contents, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			panic(err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+		t := template.Must(template.New(name).Option("missingkey=error").Parse(string(contents)))
+		switch {
+		case strings.HasSuffix(rest, ".up.sql"):
+			m.name, m.up = strings.TrimSuffix(rest, ".up.sql"), t
+		case strings.HasSuffix(rest, ".down.sql"):
+			m.down = t
+		default:
+			panic(fmt.Sprintf("migration %s is not named <version>_<name>.<up|down>.sql", name))
+		}
+	}
+	loaded := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		loaded = append(loaded, *m)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].version < loaded[j].version })
+	for i, m := range loaded {
+		if m.version != i+1 || m.up == nil || m.down == nil {
+			panic(fmt.Sprintf("migration %d is missing, or lacks an up or down script", i+1))
+		}
+	}
+	return loaded
+}
+
+// Version returns the schema version of the blocks table named by the config, 0 if no migrations have been applied to it
+func Version(db *sqlx.DB, config shared.Config) (int, error) {
+	if err := config.Validate(); err != nil {
+		return 0, err
+	}
+	schema := pq.QuoteIdentifier(config.Schema)
+	var exists bool
+	if err := db.Get(&exists, tableExistsPgStr, schema+".ethdb_schema_version"); err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	return version, db.Get(&version, fmt.Sprintf(versionPgStr, schema), config.Table)
+}
+
+// Migrate upgrades or downgrades the schema of the blocks table named by the config to the target version,
+// use LatestVersion for the current schema
+// Each migration is applied in its own transaction, so an error leaves the schema at the last version that succeeded
+// Concurrent migrations are serialized with an advisory lock
+func Migrate(db *sqlx.DB, config shared.Config, targetVersion int) error {
+	if targetVersion < 0 || targetVersion > LatestVersion {
+		return fmt.Errorf("unknown schema version %d, the latest is %d", targetVersion, LatestVersion)
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if _, err := db.Exec(fmt.Sprintf(createVersionTablePgStr, pq.QuoteIdentifier(config.Schema))); err != nil {
+		return err
+	}
+	for {
+		done, err := step(db, config, targetVersion)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// step applies the next migration towards the target version in a transaction, it returns whether the target was reached
+func step(db *sqlx.DB, config shared.Config, targetVersion int) (done bool, err error) {
+	n := newNames(config)
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+	if _, err = tx.Exec(lockPgStr); err != nil {
+		return false, err
+	}
+	var version int
+	if err = tx.Get(&version, fmt.Sprintf(versionPgStr, n.Schema), config.Table); err != nil {
+		return false, err
+	}
+	if version > len(migrations) {
+		return false, fmt.Errorf("schema version %d of %s is newer than this version of ipfs-ethdb supports, the latest is %d",
+			version, n.Blocks, LatestVersion)
+	}
+	var sql string
+	switch {
+	case version == targetVersion:
+		return true, tx.Commit()
+	case version < targetVersion:
+		m := migrations[version]
+		log.Infof("applying schema migration %d %s to %s", m.version, m.name, n.Blocks)
+		if sql, err = render(m.up, n); err != nil {
+			return false, err
+		}
+		if _, err = tx.Exec(sql); err != nil {
+			return false, fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		}
+		_, err = tx.Exec(fmt.Sprintf(insertVersionPgStr, n.Schema), config.Table, m.version)
+	default:
+		m := migrations[version-1]
+		log.Infof("reverting schema migration %d %s of %s", m.version, m.name, n.Blocks)
+		if sql, err = render(m.down, n); err != nil {
+			return false, err
+		}
+		if _, err = tx.Exec(sql); err != nil {
+			return false, fmt.Errorf("reverting migration %d %s: %w", m.version, m.name, err)
+		}
+		_, err = tx.Exec(fmt.Sprintf(deleteVersionPgStr, n.Schema), config.Table, m.version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
+}
+
+// Check returns an error if the schema of the blocks table named by the config is older than this version of the library requires
+// A blocks table that exists but has no schema version, such as one set up by ipld-eth-db, is assumed to be managed
+// externally and passes the check
+func Check(db *sqlx.DB, config shared.Config) error {
+	version, err := Version(db, config)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		var exists bool
+		if err := db.Get(&exists, tableExistsPgStr, config.TableName()); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s, create the schema with migrations.Migrate", ErrSchemaMissing, config.TableName())
+		}
+		log.Debugf("%s has no schema version, assuming it is managed externally", config.TableName())
+		return nil
+	}
+	if version < LatestVersion {
+		return fmt.Errorf("%w: the schema is at version %d and version %d is required, upgrade it with migrations.Migrate",
+			ErrSchemaOutdated, version, LatestVersion)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package migrations_test
+
+import (
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/migrations"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var _ = Describe("Migrations", func() {
+	It("rejects unknown versions", func() {
+		Expect(migrations.Migrate(nil, shared.DefaultConfig, -1)).To(HaveOccurred())
+		Expect(migrations.Migrate(nil, shared.DefaultConfig, migrations.LatestVersion+1)).To(HaveOccurred())
+	})
+
+	Describe("with a database", func() {
+		var (
+			db     *sqlx.DB
+			err    error
+			config = shared.Config{Schema: "ipld", Table: "migrations_blocks"}
+
+			exists = func(name string) (exists bool) {
+				Expect(db.Get(&exists, "SELECT to_regclass($1) IS NOT NULL", name)).To(Succeed())
+				return exists
+			}
+			indexExists = func() bool {
+				return exists("ipld.migrations_blocks_block_number_index")
+			}
+		)
+
+		BeforeEach(func() {
+			db, err = shared.TestDB()
+			Expect(err).ToNot(HaveOccurred())
+		})
+		AfterEach(func() {
+			Expect(migrations.Migrate(db, config, 0)).To(Succeed())
+			// leave the test db as ipld-eth-db sets it up, with an externally managed schema
+			_, err = db.Exec("DROP TABLE IF EXISTS ipld.ethdb_schema_version")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("passes the check for an externally managed schema", func() {
+			Expect(migrations.Check(db, shared.DefaultConfig)).To(Succeed())
+			version, err := migrations.Version(db, shared.DefaultConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version).To(Equal(0))
+		})
+
+		It("fails the check if the blocks table doesn't exist", func() {
+			err = migrations.Check(db, config)
+			Expect(err).To(MatchError(migrations.ErrSchemaMissing))
+		})
+
+		It("migrates the table named by the config to the latest version", func() {
+			Expect(migrations.Migrate(db, config, migrations.LatestVersion)).To(Succeed())
+			version, err := migrations.Version(db, config)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version).To(Equal(migrations.LatestVersion))
+			Expect(exists(config.TableName())).To(BeTrue())
+			Expect(exists(config.KeyMigrationsTableName())).To(BeTrue())
+			Expect(indexExists()).To(BeTrue())
+			Expect(migrations.Check(db, config)).To(Succeed())
+
+			// the versions are recorded per table
+			version, err = migrations.Version(db, shared.DefaultConfig)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(version).To(Equal(0))
+
+			// migrating again is a no-op
+			Expect(migrations.Migrate(db, config, migrations.LatestVersion)).To(Succeed())
+		})
+
+		It("downgrades, and fails the check until the schema is upgraded again", func() {
+			Expect(migrations.Migrate(db, config, migrations.LatestVersion)).To(Succeed())
+			Expect(migrations.Migrate(db, config, 1)).To(Succeed())
+			Expect(indexExists()).To(BeFalse())
+			Expect(exists(config.KeyMigrationsTableName())).To(BeFalse())
+			Expect(migrations.Check(db, config)).To(MatchError(migrations.ErrSchemaOutdated))
+
+			Expect(migrations.Migrate(db, config, migrations.LatestVersion)).To(Succeed())
+			Expect(migrations.Check(db, config)).To(Succeed())
+		})
+
+		It("fails to migrate a schema newer than it supports", func() {
+			Expect(migrations.Migrate(db, config, migrations.LatestVersion)).To(Succeed())
+			_, err = db.Exec("INSERT INTO ipld.ethdb_schema_version (table_name, version) VALUES ($1, $2)",
+				config.Table, migrations.LatestVersion+1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(migrations.Migrate(db, config, migrations.LatestVersion)).To(MatchError(ContainSubstring("is newer than")))
+			Expect(migrations.Migrate(db, config, 0)).To(HaveOccurred())
+
+			_, err = db.Exec("DELETE FROM ipld.ethdb_schema_version WHERE table_name = $1 AND version = $2",
+				config.Table, migrations.LatestVersion+1)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("removes the objects it created when downgraded to version 0", func() {
+			Expect(migrations.Migrate(db, config, migrations.LatestVersion)).To(Succeed())
+			Expect(migrations.Migrate(db, config, 0)).To(Succeed())
+			Expect(exists(config.TableName())).To(BeFalse())
+			Expect(exists(config.KeyMigrationsTableName())).To(BeFalse())
+			Expect(indexExists()).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS {{.Blocks}} (
+    block_number BIGINT NOT NULL,
+    key TEXT NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (key, block_number)
+);
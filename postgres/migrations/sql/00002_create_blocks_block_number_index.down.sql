@@ -0,0 +1 @@
+DROP INDEX {{.Schema}}.{{.BlockNumberIndex}};
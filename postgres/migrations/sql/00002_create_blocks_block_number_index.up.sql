@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS {{.BlockNumberIndex}} ON {{.Blocks}} USING brin (block_number);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS {{.KeyMigrations}} (
+    name TEXT PRIMARY KEY,
+    block_number BIGINT NOT NULL,
+    key TEXT NOT NULL,
+    migrated BIGINT NOT NULL DEFAULT 0,
+    skipped BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
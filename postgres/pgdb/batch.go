@@ -0,0 +1,368 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"database/sql"
+	"errors"
+	"math/big"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var _ ethdb.Batch = &Batch{}
+
+// Batch is the type that satisfies the ethdb.Batch interface for PG-IPFS Ethereum data using a direct Postgres connection
+// The operations are executed in a transaction as they are added, and also recorded so that the transaction
+// can be replayed on a new connection if it fails with a transient error
+// The transaction is begun by the first operation, so an idle batch doesn't hold a pooled connection
+type Batch struct {
+	db        *sqlx.DB
+	tx        *sqlx.Tx
+	ownTx     bool
+	callerTx  bool // the tx is a TxDatabase's, which the caller commits
+	stmts     *shared.Statements
+	ownStmts  bool
+	codec     keycodec.KeyCodec
+	retry     shared.RetryConfig
+	verify    bool
+	compress  shared.Compression
+	router    Router
+	index     *IndexContext
+	txState   *txState
+	savepoint string
+	ops       []batchOp
+	flushed   int
+
+	// boundTx is the transaction boundPut and boundDelete are bound to, they are bound again when it changes
+	boundTx               *sqlx.Tx
+	boundPut, boundDelete *sqlx.Stmt
+	valueSize             int
+
+	// created is the stack the batch was created from, recorded when leak detection is enabled
+	created []byte
+
+	blockNumber *big.Int
+}
+
+type batchOp struct {
+	key, value []byte
+	stored     []byte // the value as it is written, i.e. compressed
+	dbKey      string
+	index      IndexContext
+	delete     bool
+}
+
+// NewBatch returns a ethdb.Batch interface for PG-IPFS, storing blocks under the keys of the KeyCodec
+// The batch writes to the default ipld.blocks table, use Database.NewBatch to write to a configured table
+// A batch over a provided transaction is not retried, as the transaction can't be replayed, and Write commits it,
+// after which the batch begins its own transactions; use Database.WithTx for batches that leave committing to the caller
+func NewBatch(db *sqlx.DB, tx *sqlx.Tx, codec keycodec.KeyCodec, blockNumber *big.Int) ethdb.Batch {
+	return newBatch(db, tx, codec, blockNumber, shared.NewStatements(db, DefaultConfig), true, DefaultConfig)
+}
+
+// NewBatchWithConfig returns a Batch over the blocks table named by the config
+// The statements are prepared up front, so an error is returned if the table does not exist
+func NewBatchWithConfig(db *sqlx.DB, tx *sqlx.Tx, codec keycodec.KeyCodec, blockNumber *big.Int, config Config) (*Batch, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.ReadOnly {
+		return nil, ipfsethdb.ErrReadOnly
+	}
+	stmts := shared.NewStatements(db, config)
+	if err := stmts.Prepare(); err != nil {
+		return nil, err
+	}
+	return newBatch(db, tx, codec, blockNumber, stmts, true, config), nil
+}
+
+// newBatch returns a Batch using the provided statements and the retry and leak detection settings of the config
+// If ownStmts is set the batch closes the statements once it is written
+func newBatch(db *sqlx.DB, tx *sqlx.Tx, codec keycodec.KeyCodec, blockNumber *big.Int, stmts *shared.Statements, ownStmts bool, config Config) *Batch {
+	b := &Batch{
+		db:          db,
+		tx:          tx,
+		ownTx:       tx == nil,
+		stmts:       stmts,
+		ownStmts:    ownStmts,
+		codec:       codec,
+		retry:       config.Retry,
+		verify:      config.Verify,
+		compress:    config.Compression,
+		blockNumber: blockNumber,
+	}
+	if config.DetectLeaks && b.ownTx {
+		b.created = debug.Stack()
+		runtime.SetFinalizer(b, (*Batch).leaked)
+	}
+	return b
+}
+
+// leaked is called when a batch with leak detection enabled is garbage collected
+// A transaction that was never written or reset is logged and rolled back, returning its connection to the pool
+func (b *Batch) leaked() {
+	if b.tx == nil {
+		return
+	}
+	log.Warnf("batch with %d pending operations was never written or reset, rolling it back; created at:\n%s",
+		len(b.ops)-b.flushed, b.created)
+	b.tx.Rollback()
+	if b.ownStmts {
+		b.stmts.Close()
+	}
+}
+
+// Put satisfies the ethdb.Batch interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value, or whatever the KeyCodec expects
+// A batch created by a Database with a Router also indexes the block in the CID table for its codec, in the same transaction
+func (b *Batch) Put(key []byte, value []byte) (err error) {
+	if b.blockNumber == nil {
+		return ErrNoBlockNumber
+	}
+	dbKey, err := b.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	if b.verify {
+		if err := keycodec.Verify(b.codec, key, value); err != nil {
+			return err
+		}
+	}
+	value = common.CopyBytes(value)
+	stored, err := b.compress.Compress(value)
+	if err != nil {
+		return err
+	}
+	if err := b.apply(batchOp{key: common.CopyBytes(key), value: value, stored: stored, dbKey: dbKey, index: b.indexContext()}); err != nil {
+		return err
+	}
+	b.valueSize += len(value)
+	return nil
+}
+
+// Delete satisfies the ethdb.Batch interface
+// Delete removes the key from the key-value data store
+func (b *Batch) Delete(key []byte) (err error) {
+	dbKey, err := b.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	return b.apply(batchOp{key: common.CopyBytes(key), dbKey: dbKey, delete: true})
+}
+
+// apply executes the operation in the batch's transaction and records it
+// On a transient error the transaction is replayed on a new connection, in line with the retry policy
+// The batch's own transaction, or savepoint in the caller's transaction, is begun by the first operation
+func (b *Batch) apply(op batchOp) error {
+	if b.txState != nil && b.savepoint == "" {
+		if err := b.setSavepoint(); err != nil {
+			return err
+		}
+	}
+	b.ops = append(b.ops, op)
+	first := true
+	err := b.retryTx(func() error {
+		if !first {
+			return b.replay()
+		}
+		first = false
+		if b.tx == nil {
+			var err error
+			if b.tx, err = b.db.Beginx(); err != nil {
+				return err
+			}
+		}
+		return b.exec(op)
+	})
+	if err != nil {
+		b.ops = b.ops[:len(b.ops)-1]
+	}
+	return err
+}
+
+// exec executes the operation in the batch's transaction
+func (b *Batch) exec(op batchOp) error {
+	if op.delete {
+		stmt, err := b.bind(&b.boundDelete, (*shared.Statements).Delete)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(op.dbKey)
+		return err
+	}
+	stmt, err := b.bind(&b.boundPut, (*shared.Statements).Put)
+	if err != nil {
+		return err
+	}
+	if _, err = stmt.Exec(op.dbKey, op.stored, b.blockNumber.Uint64()); err != nil || b.router == nil {
+		return err
+	}
+	c, err := b.codec.CID(op.key)
+	if err != nil {
+		return err
+	}
+	return b.router.index(b.tx, IndexedBlock{CID: c, Data: op.value, BlockNumber: b.blockNumber.Uint64(), IndexContext: op.index})
+}
+
+// bind returns the prepared statement bound to the batch's transaction, binding it once per transaction
+func (b *Batch) bind(bound **sqlx.Stmt, query statement) (*sqlx.Stmt, error) {
+	if b.boundTx != b.tx {
+		b.boundTx, b.boundPut, b.boundDelete = b.tx, nil, nil
+	}
+	if *bound == nil {
+		stmt, err := query(b.stmts)
+		if err != nil {
+			return nil, err
+		}
+		*bound = b.tx.Stmtx(stmt)
+	}
+	return *bound, nil
+}
+
+// indexContext returns the current IndexContext of the batch's Database
+func (b *Batch) indexContext() IndexContext {
+	if b.index == nil {
+		return IndexContext{}
+	}
+	return *b.index
+}
+
+// replay abandons the current transaction and executes the operations recorded since the last write in a new one
+// This is safe because the puts do nothing on conflict and the deletes are by key
+func (b *Batch) replay() error {
+	if b.tx != nil {
+		b.tx.Rollback()
+	}
+	var err error
+	if b.tx, err = b.db.Beginx(); err != nil {
+		return err
+	}
+	for _, op := range b.ops[b.flushed:] {
+		if err := b.exec(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryTx runs the operation with the retry policy, or just once if the batch's transaction was provided by the caller
+func (b *Batch) retryTx(op func() error) error {
+	if !b.ownTx {
+		return op()
+	}
+	return b.retry.Retry(op)
+}
+
+// ValueSize satisfies the ethdb.Batch interface
+// ValueSize retrieves the amount of data queued up for writing
+// The returned value is the total byte length of all data queued to write
+func (b *Batch) ValueSize() int {
+	return b.valueSize
+}
+
+// Write satisfies the ethdb.Batch interface
+// Write flushes any accumulated data to disk
+func (b *Batch) Write() error {
+	if b.callerTx {
+		// the caller commits its own transaction
+		return b.releaseSavepoint()
+	}
+	if b.tx == nil {
+		return nil
+	}
+	if !b.ownTx {
+		// the transaction provided to NewBatch can't be replayed, so it is committed without retries
+		if err := b.tx.Commit(); err != nil {
+			return err
+		}
+		b.tx = nil
+		b.ownTx = true
+		b.flushed = len(b.ops)
+		if b.ownStmts {
+			return b.stmts.Close()
+		}
+		return nil
+	}
+	first := true
+	err := b.retryTx(func() error {
+		if !first {
+			if err := b.replay(); err != nil {
+				return err
+			}
+		}
+		first = false
+		return b.tx.Commit()
+	})
+	if err != nil {
+		return err
+	}
+	b.tx = nil
+	b.flushed = len(b.ops)
+	if b.ownStmts {
+		return b.stmts.Close()
+	}
+	return nil
+}
+
+// Replay satisfies the ethdb.Batch interface
+// Replay replays the batch contents
+func (b *Batch) Replay(w ethdb.KeyValueWriter) error {
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = w.Delete(op.key)
+		} else {
+			err = w.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset satisfies the ethdb.Batch interface
+// Reset resets the batch for reuse
+// This should be called after every write
+// Operations that haven't been written are rolled back, for a savepoint batch by rolling back to its savepoint
+func (b *Batch) Reset() {
+	switch {
+	case b.txState != nil:
+		if err := b.resetSavepoint(); err != nil {
+			log.Warn("Failed to roll back batch to its savepoint: ", err)
+		}
+	case b.ownTx && b.tx != nil:
+		if err := b.tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Warn("Failed to roll back batch: ", err)
+		}
+		b.tx = nil
+	}
+	b.ops = b.ops[:0]
+	b.flushed = 0
+	b.valueSize = 0
+}
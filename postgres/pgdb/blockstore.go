@@ -0,0 +1,254 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/mailgun/groupcache/v2"
+	log "github.com/sirupsen/logrus"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+)
+
+var errBlockstoreCodec = errors.New("a blockstore needs the keycodec.Multihash key codec")
+
+var _ blockstore.Blockstore = &Blockstore{}
+
+// Blockstore is the type that satisfies the blockstore.Blockstore interface over the blocks table of a Database
+// Blocks are stored under the multihash keys of their CIDs, so a blockservice or IPFS node can serve the blocks written
+// through the ethdb, and the ethdb can read the blocks added through the blockstore
+// The keys are listed as raw v1 CIDs, as the table doesn't record the codecs of the blocks
+type Blockstore struct {
+	db          *Database
+	blockNumber *big.Int
+	index       IndexContext
+	hashOnRead  uint32
+}
+
+// Blockstore returns a blockstore.Blockstore over the database's blocks table
+// It shares the database's cache, replicas, Router and Config, and writes blocks at the BlockNumber, and indexes them
+// with the Index, that the database has when the blockstore is created; writes fail with ErrNoBlockNumber if it is unset
+// The database needs the keycodec.Multihash key codec, e.g. postgres/v1
+func (d *Database) Blockstore() (*Blockstore, error) {
+	if _, ok := d.codec.(keycodec.Multihash); !ok {
+		return nil, errBlockstoreCodec
+	}
+	return &Blockstore{db: d, blockNumber: copyBlockNumber(d.BlockNumber), index: d.Index}, nil
+}
+
+// copyBlockNumber returns a copy of the block number, or nil if it is unset
+func copyBlockNumber(blockNumber *big.Int) *big.Int {
+	if blockNumber == nil {
+		return nil
+	}
+	return new(big.Int).Set(blockNumber)
+}
+
+// Has satisfies the blockstore.Blockstore interface
+// Has returns whether the block is in the table
+func (b *Blockstore) Has(_ context.Context, c cid.Cid) (bool, error) {
+	return b.db.hasKey(keycodec.MultihashKey(c.Hash()))
+}
+
+// Get satisfies the blockstore.Blockstore interface
+// Get returns the block, or ipld.ErrNotFound if it is not in the table
+// The block is checked against its CID if HashOnRead is enabled or Config.Verify is set
+func (b *Blockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	if !c.Defined() {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*500)
+	defer cancel()
+
+	var data []byte
+	err := b.db.cache.Get(ctx, keycodec.MultihashKey(c.Hash()), groupcache.AllocatingByteSliceSink(&data))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ipld.ErrNotFound{Cid: c}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if atomic.LoadUint32(&b.hashOnRead) == 1 || b.db.config.Verify {
+		sum, err := c.Prefix().Sum(data)
+		if err != nil {
+			return nil, err
+		}
+		if !sum.Equals(c) {
+			return nil, blockstore.ErrHashMismatch
+		}
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// GetSize satisfies the blockstore.Blockstore interface
+// GetSize returns the size of the block's data, or ipld.ErrNotFound if it is not in the table
+// The data is read through the cache, as the table may hold it compressed
+func (b *Blockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
+	block, err := b.Get(ctx, c)
+	if err != nil {
+		return -1, err
+	}
+	return len(block.RawData()), nil
+}
+
+// Put satisfies the blockstore.Blockstore interface
+// Put writes the block, indexing it if the database's Router routes its codec
+func (b *Blockstore) Put(_ context.Context, block blocks.Block) error {
+	if b.db.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	if err := b.verifyPut(block); err != nil {
+		return err
+	}
+	return b.db.put(keycodec.MultihashKey(block.Cid().Hash()), block.Cid(), block.RawData(), b.blockNumber, b.index)
+}
+
+// PutMany satisfies the blockstore.Blockstore interface
+// PutMany writes the blocks, and their index rows, in a single transaction
+func (b *Blockstore) PutMany(_ context.Context, blks []blocks.Block) error {
+	if b.db.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	if b.blockNumber == nil {
+		return ErrNoBlockNumber
+	}
+	stored := make([][]byte, len(blks))
+	for i, block := range blks {
+		if err := b.verifyPut(block); err != nil {
+			return err
+		}
+		var err error
+		if stored[i], err = b.db.config.Compression.Compress(block.RawData()); err != nil {
+			return err
+		}
+	}
+	return b.db.config.Retry.Retry(func() error {
+		return b.putMany(blks, stored)
+	})
+}
+
+func (b *Blockstore) putMany(blks []blocks.Block, stored [][]byte) error {
+	stmt, err := b.db.stmts.Put()
+	if err != nil {
+		return err
+	}
+	tx, err := b.db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	put := tx.Stmtx(stmt)
+	blockNumber := b.blockNumber.Uint64()
+	for i, block := range blks {
+		if _, err := put.Exec(keycodec.MultihashKey(block.Cid().Hash()), stored[i], blockNumber); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if b.db.Router == nil {
+			continue
+		}
+		indexed := IndexedBlock{CID: block.Cid(), Data: block.RawData(), BlockNumber: blockNumber, IndexContext: b.index}
+		if err := b.db.Router.index(tx, indexed); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// verifyPut checks a block that is about to be written against its CID, when Config.Verify is set
+func (b *Blockstore) verifyPut(block blocks.Block) error {
+	if !b.db.config.Verify {
+		return nil
+	}
+	sum, err := block.Cid().Prefix().Sum(block.RawData())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum.Hash(), block.Cid().Hash()) {
+		return keycodec.ErrHashMismatch
+	}
+	return nil
+}
+
+// DeleteBlock satisfies the blockstore.Blockstore interface
+// DeleteBlock removes the block from the table and the cache
+func (b *Blockstore) DeleteBlock(_ context.Context, c cid.Cid) error {
+	if b.db.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	return b.db.deleteKey(keycodec.MultihashKey(c.Hash()))
+}
+
+// AllKeysChan satisfies the blockstore.Blockstore interface
+// AllKeysChan streams the keys of the table as raw v1 CIDs, skipping keys that are not multihash keys
+// Each key is listed once, however many block numbers it is stored at
+// The channel is closed when the keys are exhausted or the context is done
+func (b *Blockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	query := fmt.Sprintf("SELECT DISTINCT key FROM %s WHERE key LIKE '/blocks/%%'", b.db.config.TableName())
+	rows, err := b.db.db.QueryxContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan cid.Cid)
+	go func() {
+		defer close(out)
+		defer rows.Close()
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				log.Error("Failed to scan blockstore key: ", err)
+				return
+			}
+			mh, err := keycodec.MultihashFromKey(key)
+			if err != nil {
+				continue
+			}
+			select {
+			case out <- cid.NewCidV1(cid.Raw, mh):
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Error("Failed to list blockstore keys: ", err)
+		}
+	}()
+	return out, nil
+}
+
+// HashOnRead satisfies the blockstore.Blockstore interface
+// HashOnRead sets whether blocks are checked against their CIDs when they are read
+func (b *Blockstore) HashOnRead(enabled bool) {
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&b.hashOnRead, v)
+}
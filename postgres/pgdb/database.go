@@ -0,0 +1,734 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mailgun/groupcache/v2"
+	log "github.com/sirupsen/logrus"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/migrations"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var (
+	// ErrNoBlockNumber is returned by writes when the block number to write the blocks at is not set
+	ErrNoBlockNumber = errors.New("the block number to write at is not set")
+
+	errNotSupported  = errors.New("this operation is not supported")
+	errDualReadCodec = errors.New("dual reads need the keycodec.Multihash key codec")
+)
+
+var (
+	dbSizePgStr = "SELECT pg_database_size(current_database())"
+
+	DefaultConfig = shared.DefaultConfig
+
+	DefaultCacheConfig = CacheConfig{
+		Name:           "db",
+		Size:           3000000, // 3MB
+		ExpiryDuration: time.Hour,
+	}
+)
+
+var _ ethdb.Database = &Database{}
+
+// Database is the type that satisfies the ethdb.Database and ethdb.KeyValueStore interfaces for PG-IPFS Ethereum data using a direct Postgres connection
+// The KeyCodec decides the keys blocks are stored under, the postgres/v0 and postgres/v1 packages are presets of it
+type Database struct {
+	db     *sqlx.DB
+	stmts  *shared.Statements
+	codec  keycodec.KeyCodec
+	config Config
+	cache  *groupcache.Group
+
+	// partitioning is discovered by the constructors that take a Config, and is nil otherwise
+	partitioning *shared.Partitioning
+	cacheExpiry  time.Duration
+
+	replicas  []*replica
+	selection ReplicaSelection
+	next      uint64
+
+	BlockNumber *big.Int
+	// Router, if set, routes blocks to the CID tables by codec, see DefaultRouter
+	// It needs a KeyCodec that derives CIDs from the keys
+	Router Router
+	// Index is the context of the blocks being put, for the Router
+	Index IndexContext
+}
+
+func (d *Database) ModifyAncients(f func(ethdb.AncientWriteOp) error) (int64, error) {
+	return 0, errNotSupported
+}
+
+// Config holds the schema and table names of the IPFS blocks table
+type Config = shared.Config
+
+type CacheConfig struct {
+	Name           string
+	Size           int
+	ExpiryDuration time.Duration
+}
+
+// NewKeyValueStore returns a ethdb.KeyValueStore interface for PG-IPFS, storing blocks under the keys of the KeyCodec
+// The schema of the default table is checked, but as no error is returned a failed check is only logged,
+// use the constructors that take a Config to fail on it
+func NewKeyValueStore(db *sqlx.DB, codec keycodec.KeyCodec, cacheConfig CacheConfig) ethdb.KeyValueStore {
+	return newDatabase(db, codec, cacheConfig)
+}
+
+// NewDatabase returns a ethdb.Database interface for PG-IPFS, storing blocks under the keys of the KeyCodec
+// The schema of the default table is checked, but as no error is returned a failed check is only logged,
+// use the constructors that take a Config to fail on it
+func NewDatabase(db *sqlx.DB, codec keycodec.KeyCodec, cacheConfig CacheConfig) ethdb.Database {
+	return newDatabase(db, codec, cacheConfig)
+}
+
+func newDatabase(db *sqlx.DB, codec keycodec.KeyCodec, cacheConfig CacheConfig) *Database {
+	if err := migrations.Check(db, DefaultConfig); err != nil {
+		log.Warnf("checking the schema of %s: %v", DefaultConfig.TableName(), err)
+	}
+	database := Database{db: db, stmts: shared.NewStatements(db, DefaultConfig), codec: codec, config: DefaultConfig}
+	database.InitCache(cacheConfig)
+
+	return &database
+}
+
+// NewKeyValueStoreWithConfig returns a ethdb.KeyValueStore interface for PG-IPFS over the blocks table named by the config
+func NewKeyValueStoreWithConfig(db *sqlx.DB, codec keycodec.KeyCodec, config Config, cacheConfig CacheConfig) (ethdb.KeyValueStore, error) {
+	return newDatabaseWithConfig(db, codec, config, cacheConfig)
+}
+
+// NewDatabaseWithConfig returns a ethdb.Database interface for PG-IPFS over the blocks table named by the config
+// The statements are prepared up front, so an error is returned if the table does not exist
+// The schema version is checked first, so an outdated schema fails with migrations.ErrSchemaOutdated
+func NewDatabaseWithConfig(db *sqlx.DB, codec keycodec.KeyCodec, config Config, cacheConfig CacheConfig) (ethdb.Database, error) {
+	return newDatabaseWithConfig(db, codec, config, cacheConfig)
+}
+
+func newDatabaseWithConfig(db *sqlx.DB, codec keycodec.KeyCodec, config Config, cacheConfig CacheConfig) (*Database, error) {
+	if err := checkConfig(db, codec, config); err != nil {
+		return nil, err
+	}
+	database := Database{db: db, stmts: shared.NewStatements(db, config), codec: codec, config: config}
+	if err := database.stmts.Prepare(); err != nil {
+		return nil, err
+	}
+	if err := database.discoverPartitioning(); err != nil {
+		return nil, err
+	}
+	database.InitCache(cacheConfig)
+
+	return &database, nil
+}
+
+// checkConfig checks the config is valid for the KeyCodec, and that the schema is up to date
+func checkConfig(db *sqlx.DB, codec keycodec.KeyCodec, config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if _, ok := codec.(keycodec.Multihash); config.DualRead && !ok {
+		// the legacy keys are derived from the multihash in the key
+		return errDualReadCodec
+	}
+	return migrations.Check(db, config)
+}
+
+func (d *Database) InitCache(cacheConfig CacheConfig) {
+	d.cacheExpiry = cacheConfig.ExpiryDuration
+	d.cache = groupcache.NewGroup(cacheConfig.Name, int64(cacheConfig.Size), groupcache.GetterFunc(
+		func(_ context.Context, id string, dest groupcache.Sink) error {
+			val, err := d.dbGet(id)
+
+			if err != nil {
+				return err
+			}
+
+			// Set the value in the groupcache, with expiry
+			if err := dest.SetBytes(val, time.Now().Add(cacheConfig.ExpiryDuration)); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	))
+}
+
+func (d *Database) GetCacheStats() groupcache.Stats {
+	return d.cache.Stats
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the key-value data store
+func (d *Database) Has(key []byte) (bool, error) {
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return false, err
+	}
+	return d.hasKey(dbKey)
+}
+
+// hasKey retrieves if a db key is present, from a replica and then the primary
+func (d *Database) hasKey(dbKey string) (bool, error) {
+	if r := d.reader(); r != nil {
+		// a replica miss may just be replication lag, so fall back to the primary
+		exists, err := d.has(r.stmts, (*shared.Statements).Has, dbKey)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	exists, err := d.has(d.stmts, (*shared.Statements).Has, dbKey)
+	if err != nil || exists || !d.config.DualRead {
+		return exists, err
+	}
+	_, err = d.getLegacy(dbKey)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// statement returns one of the prepared Statements
+type statement func(*shared.Statements) (*sqlx.Stmt, error)
+
+func (d *Database) has(stmts *shared.Statements, query statement, args ...interface{}) (exists bool, err error) {
+	err = d.config.Retry.Retry(func() error {
+		stmt, err := query(stmts)
+		if err != nil {
+			return err
+		}
+		return stmt.Get(&exists, args...)
+	})
+	return exists, err
+}
+
+// Get retrieves the given key if it's present in the key-value data store
+func (d *Database) dbGet(key string) ([]byte, error) {
+	if r := d.reader(); r != nil {
+		// a replica miss may just be replication lag, so fall back to the primary
+		data, err := d.get(r.stmts, (*shared.Statements).Get, key)
+		if err != sql.ErrNoRows {
+			return data, err
+		}
+	}
+	data, err := d.get(d.stmts, (*shared.Statements).Get, key)
+	if err == sql.ErrNoRows && d.config.DualRead {
+		data, err = d.getLegacy(key)
+	}
+	if err == sql.ErrNoRows {
+		log.Warn("Database miss for key", key)
+	}
+
+	return data, err
+}
+
+func (d *Database) get(stmts *shared.Statements, query statement, args ...interface{}) (data []byte, err error) {
+	err = d.config.Retry.Retry(func() error {
+		stmt, err := query(stmts)
+		if err != nil {
+			return err
+		}
+		return stmt.Get(&data, args...)
+	})
+	return shared.Decompress(data), err
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key if it's present in the key-value data store
+func (d *Database) Get(key []byte) ([]byte, error) {
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	var data []byte
+	err = d.cache.Get(ctx, dbKey, groupcache.AllocatingByteSliceSink(&data))
+	return verified(d.codec, d.config, key, data, err)
+}
+
+// GetMany retrieves the values for the given keys with a single query
+// The returned values are in the same order as the keys, with a nil entry for each key that is not present
+// Values that are found are also added to the cache
+func (d *Database) GetMany(keys [][]byte) ([][]byte, error) {
+	dbKeys, err := keycodec.Keys(d.codec, keys)
+	if err != nil {
+		return nil, err
+	}
+	values, err := d.getManyCached(dbKeys, (*shared.Statements).GetMany)
+	return verifiedMany(d.codec, d.config, keys, values, err)
+}
+
+// getManyCached looks up the keys with the GetMany query, or its AtRange variant with the extra range arguments,
+// on a replica and then on the primary for the keys the replica doesn't have yet
+// The values are returned in the order of the keys, and added to the cache
+func (d *Database) getManyCached(dbKeys []string, query statement, args ...interface{}) ([][]byte, error) {
+	found := make(map[string][]byte, len(dbKeys))
+	missing := dbKeys
+	if r := d.reader(); r != nil {
+		if err := d.getMany(r.stmts, query, missing, found, args...); err != nil {
+			return nil, err
+		}
+		// fall back to the primary for the keys the replica doesn't have yet
+		missing = missing[:0:0]
+		for _, dbKey := range dbKeys {
+			if _, ok := found[dbKey]; !ok {
+				missing = append(missing, dbKey)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		if err := d.getMany(d.stmts, query, missing, found, args...); err != nil {
+			return nil, err
+		}
+	}
+	if d.config.DualRead {
+		if err := d.getManyLegacy(dbKeys, found); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	values := make([][]byte, len(dbKeys))
+	for i, dbKey := range dbKeys {
+		data, ok := found[dbKey]
+		if !ok {
+			continue
+		}
+		values[i] = data
+		if err := d.cache.Set(ctx, dbKey, data, time.Now().Add(d.cacheExpiry), false); err != nil {
+			log.Warn("Failed to cache value for key ", dbKey, ": ", err)
+		}
+	}
+	return values, nil
+}
+
+func (d *Database) getMany(stmts *shared.Statements, query statement, dbKeys []string, found map[string][]byte, args ...interface{}) error {
+	return d.config.Retry.Retry(func() error {
+		stmt, err := query(stmts)
+		if err != nil {
+			return err
+		}
+		rows, err := stmt.Queryx(append([]interface{}{pq.Array(dbKeys)}, args...)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var dbKey string
+			var data []byte
+			if err := rows.Scan(&dbKey, &data); err != nil {
+				return err
+			}
+			found[dbKey] = shared.Decompress(data)
+		}
+		return rows.Err()
+	})
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value, or whatever the KeyCodec expects
+// With a Router the block is also indexed in the CID table for its codec, in the same transaction
+func (d *Database) Put(key []byte, value []byte) error {
+	return d.putAt(key, value, d.BlockNumber, d.Index)
+}
+
+var _ ethdb.KeyValueWriter = &BlockWriter{}
+
+// BlockWriter is the type that satisfies the ethdb.KeyValueWriter interface for writes to a Database at a fixed
+// block number, e.g. the values a layered.Database backfills
+type BlockWriter struct {
+	db          *Database
+	blockNumber *big.Int
+	index       IndexContext
+}
+
+// AtBlockNumber returns a writer that puts values at the block number, and indexes them with the Index the database has
+// when the writer is created; its puts fail with ErrNoBlockNumber if the block number is nil
+func (d *Database) AtBlockNumber(blockNumber *big.Int) *BlockWriter {
+	return &BlockWriter{db: d, blockNumber: copyBlockNumber(blockNumber), index: d.Index}
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put inserts the given value into the key-value data store at the writer's block number
+func (w *BlockWriter) Put(key []byte, value []byte) error {
+	return w.db.putAt(key, value, w.blockNumber, w.index)
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the key-value data store, at whatever block numbers it was written
+func (w *BlockWriter) Delete(key []byte) error {
+	return w.db.Delete(key)
+}
+
+// putAt writes the value under the key at the block number, indexing it with the context if there is a Router
+func (d *Database) putAt(key, value []byte, blockNumber *big.Int, index IndexContext) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	if err := verifyPut(d.codec, d.config, key, value); err != nil {
+		return err
+	}
+	c := cid.Undef
+	if d.Router != nil {
+		if c, err = d.codec.CID(key); err != nil {
+			return err
+		}
+	}
+	return d.put(dbKey, c, value, blockNumber, index)
+}
+
+// put writes the value under the db key at the block number, indexing it with the context if the Router routes the CID
+// The CID is only used by a Router, and can be cid.Undef otherwise
+func (d *Database) put(dbKey string, c cid.Cid, value []byte, blockNumber *big.Int, index IndexContext) error {
+	if blockNumber == nil {
+		return ErrNoBlockNumber
+	}
+	stored, err := d.config.Compression.Compress(value)
+	if err != nil {
+		return err
+	}
+	if d.Router != nil && c.Defined() && d.Router.routes(c) {
+		block := IndexedBlock{CID: c, Data: value, BlockNumber: blockNumber.Uint64(), IndexContext: index}
+		return d.config.Retry.Retry(func() error {
+			return d.putIndexed(dbKey, stored, block)
+		})
+	}
+	return d.config.Retry.Retry(func() error {
+		stmt, err := d.stmts.Put()
+		if err != nil {
+			return err
+		}
+		_, err = stmt.Exec(dbKey, stored, blockNumber.Uint64())
+		return err
+	})
+}
+
+// putIndexed writes the block, as it is stored, and its index row in a transaction
+func (d *Database) putIndexed(dbKey string, stored []byte, block IndexedBlock) error {
+	stmt, err := d.stmts.Put()
+	if err != nil {
+		return err
+	}
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Stmtx(stmt).Exec(dbKey, stored, block.BlockNumber); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := d.Router.index(tx, block); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the key-value data store
+func (d *Database) Delete(key []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	return d.deleteKey(dbKey)
+}
+
+// deleteKey removes the db key from the table and the cache
+func (d *Database) deleteKey(dbKey string) error {
+	stmt, err := d.stmts.Delete()
+	if err != nil {
+		return err
+	}
+	_, err = stmt.Exec(dbKey)
+	if err != nil {
+		return err
+	}
+
+	// Remove from cache.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	err = d.cache.Remove(ctx, dbKey)
+
+	return err
+}
+
+// DatabaseProperty enum type
+type DatabaseProperty int
+
+const (
+	Unknown DatabaseProperty = iota
+	Size
+	Idle
+	InUse
+	MaxIdleClosed
+	MaxLifetimeClosed
+	MaxOpenConnections
+	OpenConnections
+	WaitCount
+	WaitDuration
+	PartitioningScheme
+	ReadOnlyMode
+)
+
+// DatabasePropertyFromString helper function
+func DatabasePropertyFromString(property string) (DatabaseProperty, error) {
+	switch strings.ToLower(property) {
+	case "size":
+		return Size, nil
+	case "idle":
+		return Idle, nil
+	case "inuse":
+		return InUse, nil
+	case "maxidleclosed":
+		return MaxIdleClosed, nil
+	case "maxlifetimeclosed":
+		return MaxLifetimeClosed, nil
+	case "maxopenconnections":
+		return MaxOpenConnections, nil
+	case "openconnections":
+		return OpenConnections, nil
+	case "waitcount":
+		return WaitCount, nil
+	case "waitduration":
+		return WaitDuration, nil
+	case "partitioning":
+		return PartitioningScheme, nil
+	case ipfsethdb.ReadOnlyProperty:
+		return ReadOnlyMode, nil
+	default:
+		return Unknown, fmt.Errorf("unknown database property")
+	}
+}
+
+// Stat satisfies the ethdb.Stater interface
+// Stat returns a particular internal stat of the database
+// Properties report on the primary pool unless prefixed with a pool name, e.g. "replica0.idle" or "primary.idle"
+func (d *Database) Stat(property string) (string, error) {
+	db := d.db
+	if poolName, poolProperty, ok := strings.Cut(property, "."); ok {
+		var err error
+		if db, err = d.pool(poolName); err != nil {
+			return "", err
+		}
+		property = poolProperty
+	}
+	prop, err := DatabasePropertyFromString(property)
+	if err != nil {
+		return "", err
+	}
+	switch prop {
+	case Size:
+		var byteSize string
+		return byteSize, db.Get(&byteSize, dbSizePgStr)
+	case Idle:
+		return strconv.Itoa(db.Stats().Idle), nil
+	case InUse:
+		return strconv.Itoa(db.Stats().InUse), nil
+	case MaxIdleClosed:
+		return strconv.FormatInt(db.Stats().MaxIdleClosed, 10), nil
+	case MaxLifetimeClosed:
+		return strconv.FormatInt(db.Stats().MaxLifetimeClosed, 10), nil
+	case MaxOpenConnections:
+		return strconv.Itoa(db.Stats().MaxOpenConnections), nil
+	case OpenConnections:
+		return strconv.Itoa(db.Stats().OpenConnections), nil
+	case WaitCount:
+		return strconv.FormatInt(db.Stats().WaitCount, 10), nil
+	case WaitDuration:
+		return db.Stats().WaitDuration.String(), nil
+	case PartitioningScheme:
+		if d.partitioning == nil {
+			return "unknown", nil
+		}
+		return d.partitioning.String(), nil
+	case ReadOnlyMode:
+		return strconv.FormatBool(d.config.ReadOnly), nil
+	default:
+		return "", fmt.Errorf("unhandled database property")
+	}
+}
+
+// Compact satisfies the ethdb.Compacter interface
+// Compact flattens the underlying data store for the given key range
+func (d *Database) Compact(start []byte, limit []byte) error {
+	return errNotSupported
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a write-only database that buffers changes to its host db
+// until a final write is called
+func (d *Database) NewBatch() ethdb.Batch {
+	if d.config.ReadOnly {
+		return ipfsethdb.ReadOnlyBatch{}
+	}
+	return d.newBatch(nil)
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface.
+// NewBatchWithSize creates a write-only database batch with pre-allocated buffer.
+func (d *Database) NewBatchWithSize(size int) ethdb.Batch {
+	return d.NewBatch()
+}
+
+// newBatch returns a Batch over the Database's statements, routing blocks with the Database's Router
+// A nil tx gives a batch with its own transaction
+func (d *Database) newBatch(tx *sqlx.Tx) *Batch {
+	b := newBatch(d.db, tx, d.codec, d.BlockNumber, d.stmts, false, d.config)
+	b.router = d.Router
+	b.index = &d.Index
+	return b
+}
+
+// NewIterator satisfies the ethdb.Iteratee interface
+// it creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix, starting at a particular
+// initial key (or after, if it does not exist).
+//
+// Note: This method assumes that the prefix is NOT part of the start, so there's
+// no need for the caller to prepend the prefix to the start
+func (d *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	if r := d.reader(); r != nil {
+		return newIterator(start, prefix, r.db, d.codec, r.stmts, false)
+	}
+	return newIterator(start, prefix, d.db, d.codec, d.stmts, false)
+}
+
+// Close satisfies the io.Closer interface
+// Close closes the prepared statements and the db connections
+func (d *Database) Close() error {
+	for _, r := range d.replicas {
+		if err := r.stmts.Close(); err != nil {
+			return err
+		}
+		if err := r.db.Close(); err != nil {
+			return err
+		}
+	}
+	if err := d.stmts.Close(); err != nil {
+		return err
+	}
+	return d.db.DB.Close()
+}
+
+// HasAncient satisfies the ethdb.AncientReader interface
+// HasAncient returns an indicator whether the specified data exists in the ancient store
+func (d *Database) HasAncient(kind string, number uint64) (bool, error) {
+	return false, errNotSupported
+}
+
+// Ancient satisfies the ethdb.AncientReader interface
+// Ancient retrieves an ancient binary blob from the append-only immutable files
+func (d *Database) Ancient(kind string, number uint64) ([]byte, error) {
+	return nil, errNotSupported
+}
+
+// Ancients satisfies the ethdb.AncientReader interface
+// Ancients returns the ancient item numbers in the ancient store
+func (d *Database) Ancients() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// Tail satisfies the ethdb.AncientReader interface.
+// Tail returns the number of first stored item in the freezer.
+func (d *Database) Tail() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientSize satisfies the ethdb.AncientReader interface
+// AncientSize returns the ancient size of the specified category
+func (d *Database) AncientSize(kind string) (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientRange retrieves all the items in a range, starting from the index 'start'.
+// It will return
+//   - at most 'count' items,
+//   - at least 1 item (even if exceeding the maxBytes), but will otherwise
+//     return as many items as fit into maxBytes.
+func (d *Database) AncientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	return nil, errNotSupported
+}
+
+// ReadAncients applies the provided AncientReader function
+func (d *Database) ReadAncients(fn func(ethdb.AncientReaderOp) error) (err error) {
+	return errNotSupported
+}
+
+// TruncateHead satisfies the ethdb.AncientWriter interface.
+// TruncateHead discards all but the first n ancient data from the ancient store.
+func (d *Database) TruncateHead(n uint64) error {
+	return errNotSupported
+}
+
+// TruncateTail satisfies the ethdb.AncientWriter interface.
+// TruncateTail discards the first n ancient data from the ancient store.
+func (d *Database) TruncateTail(n uint64) error {
+	return errNotSupported
+}
+
+// Sync satisfies the ethdb.AncientWriter interface
+// Sync flushes all in-memory ancient store data to disk
+func (d *Database) Sync() error {
+	return errNotSupported
+}
+
+// MigrateTable satisfies the ethdb.AncientWriter interface.
+// MigrateTable processes and migrates entries of a given table to a new format.
+func (d *Database) MigrateTable(string, func([]byte) ([]byte, error)) error {
+	return errNotSupported
+}
+
+// NewSnapshot satisfies the ethdb.Snapshotter interface.
+// NewSnapshot creates a database snapshot based on the current state.
+func (d *Database) NewSnapshot() (ethdb.Snapshot, error) {
+	return nil, errNotSupported
+}
+
+// AncientDatadir returns an error as we don't have a backing chain freezer.
+func (d *Database) AncientDatadir() (string, error) {
+	return "", errNotSupported
+}
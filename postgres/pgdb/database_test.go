@@ -0,0 +1,163 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	"github.com/multiformats/go-multihash"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/pgdb"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+// prefixCodec is a KeyCodec for a store that keeps blocks under its own key prefix
+type prefixCodec struct{}
+
+func (prefixCodec) Key(key []byte) (string, error) {
+	return "/custom/" + string(key), nil
+}
+
+func (prefixCodec) CID([]byte) (cid.Cid, error) {
+	return cid.Cid{}, keycodec.ErrNoCID
+}
+
+var _ = Describe("Database", func() {
+	var (
+		db    *sqlx.DB
+		err   error
+		value = []byte("value")
+		key   = []byte("some-key")
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		groupcache.DeregisterGroup(pgdb.DefaultCacheConfig.Name)
+		Expect(shared.ResetTestDB(db)).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("stores blocks under the keys of a custom KeyCodec", func() {
+		database, err := pgdb.NewDatabaseWithConfig(db, prefixCodec{}, pgdb.DefaultConfig, pgdb.DefaultCacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+		database.(*pgdb.Database).BlockNumber = big.NewInt(1)
+
+		Expect(database.Put(key, value)).To(Succeed())
+		var stored []byte
+		Expect(db.Get(&stored, "SELECT data FROM ipld.blocks WHERE key = $1", "/custom/some-key")).To(Succeed())
+		Expect(stored).To(Equal(value))
+
+		got, err := database.Get(key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(Equal(value))
+
+		batch := database.NewBatch()
+		Expect(batch.Delete(key)).To(Succeed())
+		Expect(batch.Write()).To(Succeed())
+		has, err := database.Has(key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+	})
+
+	It("rejects a Router with a KeyCodec that doesn't derive CIDs", func() {
+		database := pgdb.NewDatabase(db, keycodec.Raw{}, pgdb.DefaultCacheConfig).(*pgdb.Database)
+		database.BlockNumber = big.NewInt(1)
+		database.Router = pgdb.DefaultRouter()
+		Expect(database.Put(key, value)).To(MatchError(keycodec.ErrNoCID))
+	})
+
+	It("verifies values against their keys with the hasher of the KeyCodec", func() {
+		config := pgdb.DefaultConfig
+		config.Verify = true
+		database, err := pgdb.NewDatabaseWithConfig(db, keycodec.Multihash{Code: multihash.SHA2_256}, config, pgdb.DefaultCacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+		database.(*pgdb.Database).BlockNumber = big.NewInt(1)
+
+		shaKey := sha256.Sum256(value)
+		Expect(database.Put(shaKey[:], []byte("other"))).To(MatchError(keycodec.ErrHashMismatch))
+		Expect(database.Put(shaKey[:], value)).To(Succeed())
+
+		// a value corrupted in the table is rejected when it is read
+		mh, _ := multihash.Encode(shaKey[:], multihash.SHA2_256)
+		_, err = db.Exec("UPDATE ipld.blocks SET data = $1 WHERE key = $2", []byte("other"), keycodec.MultihashKey(mh))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = database.Get(shaKey[:])
+		Expect(err).To(MatchError(keycodec.ErrHashMismatch))
+	})
+
+	It("compresses the values it writes and decompresses them when they are read", func() {
+		config := pgdb.DefaultConfig
+		config.Compression = shared.Zstd
+		database, err := pgdb.NewDatabaseWithConfig(db, prefixCodec{}, config, pgdb.DefaultCacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+		database.(*pgdb.Database).BlockNumber = big.NewInt(1)
+
+		large := bytes.Repeat(value, 100)
+		batch := database.NewBatch()
+		Expect(batch.Put(key, large)).To(Succeed())
+		Expect(batch.Write()).To(Succeed())
+		var stored []byte
+		Expect(db.Get(&stored, "SELECT data FROM ipld.blocks WHERE key = $1", "/custom/some-key")).To(Succeed())
+		Expect(shared.IsCompressed(stored)).To(BeTrue())
+
+		got, err := database.Get(key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(Equal(large))
+	})
+
+	It("rejects writes in read-only mode, over read-only sessions", func() {
+		config := pgdb.DefaultConfig
+		config.ReadOnly = true
+		readOnlyDB, err := shared.ConnectReadOnly(shared.TestConnectStr)
+		Expect(err).ToNot(HaveOccurred())
+		defer readOnlyDB.Close()
+		_, err = readOnlyDB.Exec("INSERT INTO ipld.blocks (key, data, block_number) VALUES ('key', '\\x01', 1)")
+		Expect(err).To(HaveOccurred())
+
+		database, err := pgdb.NewDatabaseWithConfig(readOnlyDB, prefixCodec{}, config, pgdb.DefaultCacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Put(key, value)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(database.Delete(key)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(database.NewBatch().Put(key, value)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(database.(*pgdb.Database).WithTx(nil).Put(key, value)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(database.Stat(ipfsethdb.ReadOnlyProperty)).To(Equal("true"))
+		has, err := database.Has(key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+	})
+
+	It("only dual reads with the multihash KeyCodec", func() {
+		config := pgdb.DefaultConfig
+		config.DualRead = true
+		_, err := pgdb.NewDatabaseWithConfig(db, keycodec.CID{}, config, pgdb.DefaultCacheConfig)
+		Expect(err).To(HaveOccurred())
+		_, err = pgdb.NewDatabaseWithConfig(db, keycodec.Multihash{Codec: cid.Raw}, config, pgdb.DefaultCacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+	})
+})
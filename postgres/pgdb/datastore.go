@@ -0,0 +1,324 @@
+// VulcanizeDB
+// Copyright © 2020 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var (
+	_ datastore.Batching = &Datastore{}
+	_ datastore.Batch    = &DatastoreBatch{}
+)
+
+// likeEscaper escapes the LIKE wildcards in a key prefix
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Datastore is the type that satisfies the datastore.Batching interface over the blocks table of a Database
+// The datastore keys are the db keys, so the "/blocks/<multihash>" keys of postgres/v1 are the keys a go-ipfs blockstore
+// writes, and the blockstore can be given the datastore directly
+// As go-datastore requires, a Put overwrites the value of the key: it replaces the key's rows, at whatever block numbers,
+// with a single row
+type Datastore struct {
+	db          *Database
+	blockNumber *big.Int
+}
+
+// Datastore returns a datastore.Batching over the database's blocks table
+// It shares the database's statements, cache, replicas and Config, and writes values at the BlockNumber the database has
+// when the datastore is created; writes fail with ErrNoBlockNumber if it is unset
+func (d *Database) Datastore() *Datastore {
+	return &Datastore{db: d, blockNumber: copyBlockNumber(d.BlockNumber)}
+}
+
+// Get satisfies the datastore.Read interface
+// Get returns the value for the key, or datastore.ErrNotFound if it is not in the table
+func (s *Datastore) Get(ctx context.Context, key datastore.Key) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*500)
+	defer cancel()
+
+	var data []byte
+	err := s.db.cache.Get(ctx, key.String(), groupcache.AllocatingByteSliceSink(&data))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, datastore.ErrNotFound
+	}
+	return data, err
+}
+
+// Has satisfies the datastore.Read interface
+// Has returns whether the key is in the table
+func (s *Datastore) Has(_ context.Context, key datastore.Key) (bool, error) {
+	return s.db.hasKey(key.String())
+}
+
+// GetSize satisfies the datastore.Read interface
+// GetSize returns the size of the value for the key, or datastore.ErrNotFound if it is not in the table
+// The value is read through the cache, as the table may hold it compressed
+func (s *Datastore) GetSize(ctx context.Context, key datastore.Key) (int, error) {
+	return datastore.GetBackedSize(ctx, s, key)
+}
+
+// Query satisfies the datastore.Read interface
+// Each key is returned once, with the value of its latest row, however many block numbers it is stored at
+// The prefix is always matched in SQL, and so are the limit, offset and key orders when there are no filters or
+// other orders, which are otherwise applied to the rows as they are read
+func (s *Datastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	pushDown := len(q.Filters) == 0
+	order := "key"
+	switch {
+	case len(q.Orders) == 0:
+	case len(q.Orders) == 1 && q.Orders[0] == query.OrderByKey{}:
+	case len(q.Orders) == 1 && q.Orders[0] == query.OrderByKeyDescending{}:
+		order = "key DESC"
+	default:
+		pushDown = false
+	}
+
+	columns := "key, data"
+	if q.KeysOnly && !q.ReturnsSizes {
+		columns = "key"
+	}
+	var conditions []string
+	var args []interface{}
+	if prefix := cleanPrefix(q.Prefix); prefix != "/" {
+		args = append(args, likeEscaper.Replace(prefix+"/")+"%")
+		conditions = append(conditions, fmt.Sprintf(`key LIKE $%d ESCAPE '\'`, len(args)))
+	}
+	// keys are ordered bytewise, as by query.OrderByKey, rather than by the collation of the database
+	pgStr := fmt.Sprintf(`SELECT DISTINCT ON (key COLLATE "C") %s FROM %s`, columns, s.db.config.TableName())
+	if len(conditions) > 0 {
+		pgStr += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	pgStr += " ORDER BY " + strings.Replace(order, "key", `key COLLATE "C"`, 1) + ", block_number DESC"
+	if pushDown && q.Limit > 0 {
+		args = append(args, q.Limit)
+		pgStr += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if pushDown && q.Offset > 0 {
+		args = append(args, q.Offset)
+		pgStr += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	var rows *sqlx.Rows
+	err := s.db.config.Retry.Retry(func() error {
+		var err error
+		rows, err = s.db.db.QueryxContext(ctx, pgStr, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	done := false
+	results := query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			if done {
+				return query.Result{}, false
+			}
+			if !rows.Next() {
+				done = true
+				if err := rows.Err(); err != nil {
+					return query.Result{Error: err}, true
+				}
+				return query.Result{}, false
+			}
+			var entry query.Entry
+			var data []byte
+			dest := []interface{}{&entry.Key}
+			if columns != "key" {
+				dest = append(dest, &data)
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return query.Result{Error: err}, true
+			}
+			entry.Size = -1
+			if data != nil {
+				data = shared.Decompress(data)
+				entry.Size = len(data)
+			}
+			if !q.KeysOnly {
+				entry.Value = data
+			}
+			return query.Result{Entry: entry}, true
+		},
+		Close: rows.Close,
+	})
+	if pushDown {
+		return results, nil
+	}
+	naive := q
+	naive.Prefix = ""
+	return query.ResultsReplaceQuery(query.NaiveQueryApply(naive, results), q), nil
+}
+
+// cleanPrefix cleans a query prefix as query.NaiveQueryApply does, so that a prefix of /bar matches /bar/baz but not /barbaz
+func cleanPrefix(prefix string) string {
+	if prefix == "" {
+		return "/"
+	}
+	if prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+	return path.Clean(prefix)
+}
+
+// Put satisfies the datastore.Write interface
+// Put replaces the value of the key with the value at the datastore's block number
+func (s *Datastore) Put(ctx context.Context, key datastore.Key, value []byte) error {
+	if s.db.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	b := s.batch()
+	if err := b.Put(ctx, key, value); err != nil {
+		return err
+	}
+	return b.Commit(ctx)
+}
+
+// Delete satisfies the datastore.Write interface
+// Delete removes the key from the table and the cache, whether or not it is present
+func (s *Datastore) Delete(_ context.Context, key datastore.Key) error {
+	if s.db.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	return s.db.deleteKey(key.String())
+}
+
+// Sync satisfies the datastore.Datastore interface
+// Sync does nothing, as Postgres has made the writes durable once they return
+func (s *Datastore) Sync(context.Context, datastore.Key) error {
+	return nil
+}
+
+// Close satisfies the io.Closer interface
+// Close does nothing, as the connection is owned by the Database
+func (s *Datastore) Close() error {
+	return nil
+}
+
+// Batch satisfies the datastore.Batching interface
+// Batch returns a DatastoreBatch, which writes its operations in a single transaction on Commit
+func (s *Datastore) Batch(context.Context) (datastore.Batch, error) {
+	if s.db.config.ReadOnly {
+		return nil, ipfsethdb.ErrReadOnly
+	}
+	return s.batch(), nil
+}
+
+func (s *Datastore) batch() *DatastoreBatch {
+	return &DatastoreBatch{db: s.db, blockNumber: s.blockNumber}
+}
+
+// DatastoreBatch is the type that satisfies the datastore.Batch interface for a Datastore
+// The operations are buffered until Commit, which writes them in order in a single transaction
+type DatastoreBatch struct {
+	db          *Database
+	blockNumber *big.Int
+	ops         []datastoreOp
+}
+
+type datastoreOp struct {
+	key    string
+	value  []byte
+	delete bool
+}
+
+// Put satisfies the datastore.Batch interface
+// Put queues the value to replace the value of the key
+func (b *DatastoreBatch) Put(_ context.Context, key datastore.Key, value []byte) error {
+	stored, err := b.db.config.Compression.Compress(value)
+	if err != nil {
+		return err
+	}
+	b.ops = append(b.ops, datastoreOp{key: key.String(), value: stored})
+	return nil
+}
+
+// Delete satisfies the datastore.Batch interface
+// Delete queues the key to be removed
+func (b *DatastoreBatch) Delete(_ context.Context, key datastore.Key) error {
+	b.ops = append(b.ops, datastoreOp{key: key.String(), delete: true})
+	return nil
+}
+
+// Commit satisfies the datastore.Batch interface
+// Commit writes the queued operations in a transaction, retried as a whole, and removes the keys written from the cache
+// The batch is emptied once it has been committed
+func (b *DatastoreBatch) Commit(ctx context.Context) error {
+	if b.blockNumber == nil {
+		for _, op := range b.ops {
+			if !op.delete {
+				return ErrNoBlockNumber
+			}
+		}
+	}
+	if err := b.db.config.Retry.Retry(b.commit); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*500)
+	defer cancel()
+	for _, op := range b.ops {
+		if err := b.db.cache.Remove(ctx, op.key); err != nil {
+			return err
+		}
+	}
+	b.ops = nil
+	return nil
+}
+
+func (b *DatastoreBatch) commit() error {
+	put, err := b.db.stmts.Put()
+	if err != nil {
+		return err
+	}
+	del, err := b.db.stmts.Delete()
+	if err != nil {
+		return err
+	}
+	tx, err := b.db.db.Beginx()
+	if err != nil {
+		return err
+	}
+	put, del = tx.Stmtx(put), tx.Stmtx(del)
+	for _, op := range b.ops {
+		// a put deletes the key's rows first, so that it overwrites the value whatever block number it was written at
+		_, err = del.Exec(op.key)
+		if err == nil && !op.delete {
+			_, err = put.Exec(op.key, op.value, b.blockNumber.Uint64())
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
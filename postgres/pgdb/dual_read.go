@@ -0,0 +1,88 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"database/sql"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+// getLegacy looks up a multihash db key in its postgres/v0 CID string forms on the primary, for Config.DualRead
+func (d *Database) getLegacy(mhKey string) ([]byte, error) {
+	return getLegacy(d.primaryGetMany, mhKey)
+}
+
+// getManyLegacy looks up the multihash db keys that are not yet found in their postgres/v0 CID string forms on the primary,
+// adding the values to found under the multihash db keys
+func (d *Database) getManyLegacy(mhKeys []string, found map[string][]byte) error {
+	return getManyLegacy(d.primaryGetMany, mhKeys, found)
+}
+
+// primaryGetMany adds the values of the db keys present on the primary to found
+func (d *Database) primaryGetMany(keys []string, found map[string][]byte) error {
+	return d.getMany(d.stmts, (*shared.Statements).GetMany, keys, found)
+}
+
+// getLegacy looks up a multihash db key in its postgres/v0 CID string forms with the getMany of an ethdb
+func getLegacy(getMany func(keys []string, found map[string][]byte) error, mhKey string) ([]byte, error) {
+	keys, err := legacyKeys(mhKey)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string][]byte, 1)
+	if err := getMany(keys, found); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if data, ok := found[key]; ok {
+			return data, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// getManyLegacy looks up the multihash db keys that are not yet found in their postgres/v0 CID string forms with the
+// getMany of an ethdb, adding the values to found under the multihash db keys
+func getManyLegacy(getMany func(keys []string, found map[string][]byte) error, mhKeys []string, found map[string][]byte) error {
+	byLegacyKey := make(map[string]string)
+	var keys []string
+	for _, mhKey := range mhKeys {
+		if _, ok := found[mhKey]; ok {
+			continue
+		}
+		legacy, err := legacyKeys(mhKey)
+		if err != nil {
+			return err
+		}
+		for _, key := range legacy {
+			byLegacyKey[key] = mhKey
+		}
+		keys = append(keys, legacy...)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	legacyFound := make(map[string][]byte, len(keys))
+	if err := getMany(keys, legacyFound); err != nil {
+		return err
+	}
+	for key, data := range legacyFound {
+		found[byLegacyKey[key]] = data
+	}
+	return nil
+}
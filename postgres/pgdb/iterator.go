@@ -14,12 +14,14 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-package pgipfsethdb
+package pgdb
 
 import (
 	"github.com/ethereum/go-ethereum/ethdb"
-	"github.com/ipfs/go-cid"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
 )
 
 var _ ethdb.Iterator = &Iterator{}
@@ -31,14 +33,28 @@ var _ ethdb.Iterator = &Iterator{}
 // from the ethdb.KeyValueStoreand ethdb.Database interfaces)
 type Iterator struct {
 	db                 *sqlx.DB
+	tx                 *sqlx.Tx // the transaction of a TxDatabase, the values are read in it if set
+	stmts              *shared.Statements
+	ownStmts           bool
+	codec              keycodec.KeyCodec
 	currentKey, prefix []byte
 	err                error
 }
 
-// NewIterator returns an ethdb.Iterator interface for PG-IPFS
-func NewIterator(start, prefix []byte, db *sqlx.DB) ethdb.Iterator {
+// NewIterator returns an ethdb.Iterator interface for PG-IPFS, reading blocks under the keys of the KeyCodec
+// The iterator reads from the default ipld.blocks table, use Database.NewIterator to read from a configured table
+func NewIterator(start, prefix []byte, db *sqlx.DB, codec keycodec.KeyCodec) ethdb.Iterator {
+	return newIterator(start, prefix, db, codec, shared.NewStatements(db, DefaultConfig), true)
+}
+
+// newIterator returns an Iterator using the provided statements
+// If ownStmts is set the iterator closes the statements and the db when it is released
+func newIterator(start, prefix []byte, db *sqlx.DB, codec keycodec.KeyCodec, stmts *shared.Statements, ownStmts bool) *Iterator {
 	return &Iterator{
 		db:         db,
+		stmts:      stmts,
+		ownStmts:   ownStmts,
+		codec:      codec,
 		prefix:     prefix,
 		currentKey: start,
 	}
@@ -73,19 +89,30 @@ func (i *Iterator) Key() []byte {
 // The caller should not modify the contents of the returned slice
 // and its contents may change on the next call to Next
 func (i *Iterator) Value() []byte {
-	c, err := cid.Cast(i.currentKey)
+	dbKey, err := i.codec.Key(i.currentKey)
 	if err != nil {
 		i.err = err
 		return nil
 	}
+	stmt, err := i.stmts.Get()
+	if err != nil {
+		i.err = err
+		return nil
+	}
+	if i.tx != nil {
+		stmt = i.tx.Stmtx(stmt)
+	}
 	var data []byte
-	i.err = i.db.Get(&data, getPgStr, c)
-	return data
+	i.err = stmt.Get(&data, dbKey)
+	return shared.Decompress(data)
 }
 
 // Release satisfies the ethdb.Iterator interface
 // Release releases associated resources
 // Release should always succeed and can be called multiple times without causing error
 func (i *Iterator) Release() {
-	i.db.Close()
+	if i.ownStmts {
+		i.stmts.Close()
+		i.db.Close()
+	}
 }
@@ -0,0 +1,155 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+// discoverPartitioning looks up how the blocks table is partitioned on the primary
+func (d *Database) discoverPartitioning() (err error) {
+	d.partitioning, err = discoverPartitioning(d.db, d.config)
+	return err
+}
+
+// discoverPartitioning looks up how the blocks table named by the config is partitioned
+func discoverPartitioning(db *sqlx.DB, config Config) (*shared.Partitioning, error) {
+	partitioning, err := shared.DiscoverPartitioning(db, config)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("blocks table %s partitioning: %s", config.TableName(), partitioning)
+	return &partitioning, nil
+}
+
+// Partitioning returns how the blocks table is partitioned
+// It is only discovered by the constructors that take a Config, ok is false otherwise
+func (d *Database) Partitioning() (partitioning shared.Partitioning, ok bool) {
+	if d.partitioning == nil {
+		return shared.Partitioning{}, false
+	}
+	return *d.partitioning, true
+}
+
+// HasAtRange retrieves if a key is present in the key-value data store at a block number between lo and hi inclusive
+// The range is part of the query whatever the layout of the table, and lets Postgres skip the partitions,
+// or hypertable chunks, outside of it
+func (d *Database) HasAtRange(key []byte, lo, hi uint64) (bool, error) {
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return false, err
+	}
+	if r := d.reader(); r != nil {
+		// a replica miss may just be replication lag, so fall back to the primary
+		exists, err := d.has(r.stmts, (*shared.Statements).HasAtRange, dbKey, lo, hi)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return d.has(d.stmts, (*shared.Statements).HasAtRange, dbKey, lo, hi)
+}
+
+// GetAtRange retrieves the given key if it's present in the key-value data store at a block number between lo and hi inclusive
+// The range is part of the query whatever the layout of the table, and lets Postgres skip the partitions,
+// or hypertable chunks, outside of it
+// A value that is found is also added to the cache
+func (d *Database) GetAtRange(key []byte, lo, hi uint64) ([]byte, error) {
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if r := d.reader(); r != nil {
+		// a replica miss may just be replication lag, so fall back to the primary
+		data, err = d.get(r.stmts, (*shared.Statements).GetAtRange, dbKey, lo, hi)
+	}
+	if data == nil && (err == nil || err == sql.ErrNoRows) {
+		data, err = d.get(d.stmts, (*shared.Statements).GetAtRange, dbKey, lo, hi)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = verified(d.codec, d.config, key, data, nil); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	if err := d.cache.Set(ctx, dbKey, data, time.Now().Add(d.cacheExpiry), false); err != nil {
+		log.Warn("Failed to cache value for key ", dbKey, ": ", err)
+	}
+	return data, nil
+}
+
+// GetManyAtRange retrieves the values for the given keys at block numbers between lo and hi inclusive with a single query
+// The returned values are in the same order as the keys, with a nil entry for each key that is not present in the range
+func (d *Database) GetManyAtRange(keys [][]byte, lo, hi uint64) ([][]byte, error) {
+	dbKeys, err := keycodec.Keys(d.codec, keys)
+	if err != nil {
+		return nil, err
+	}
+	values, err := d.getManyCached(dbKeys, (*shared.Statements).GetManyAtRange, lo, hi)
+	return verifiedMany(d.codec, d.config, keys, values, err)
+}
+
+var _ ethdb.Database = &BlockRangeDatabase{}
+
+// BlockRangeDatabase is a view of a Database whose reads are hinted with a block number range
+// e.g. when walking the state trie of a known block, whose nodes were all written at or before it
+// Keys that are outside of the range are reported as missing
+type BlockRangeDatabase struct {
+	*Database
+	lo, hi uint64
+}
+
+// WithBlockRange returns a view of the database whose reads only consider the blocks written between lo and hi inclusive
+func (d *Database) WithBlockRange(lo, hi uint64) *BlockRangeDatabase {
+	return &BlockRangeDatabase{Database: d, lo: lo, hi: hi}
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the key-value data store within the view's block range
+func (d *BlockRangeDatabase) Has(key []byte) (bool, error) {
+	return d.HasAtRange(key, d.lo, d.hi)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key if it's present in the key-value data store within the view's block range
+func (d *BlockRangeDatabase) Get(key []byte) ([]byte, error) {
+	return d.GetAtRange(key, d.lo, d.hi)
+}
+
+// GetMany retrieves the values for the given keys within the view's block range with a single query
+func (d *BlockRangeDatabase) GetMany(keys [][]byte) ([][]byte, error) {
+	return d.GetManyAtRange(keys, d.lo, d.hi)
+}
+
+// Close satisfies the io.Closer interface
+// Close does nothing, the underlying Database is owned by the caller
+func (d *BlockRangeDatabase) Close() error {
+	return nil
+}
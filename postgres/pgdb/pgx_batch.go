@@ -0,0 +1,203 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var (
+	// CopyFromThreshold is the number of puts above which a delete-free PgxBatch is written with COPY rather than pipelined INSERTs
+	CopyFromThreshold = 512
+
+	pgxStagingTable = "pgipfsethdb_staging"
+)
+
+var _ ethdb.Batch = &PgxBatch{}
+
+type pgxBatchOp struct {
+	key, value []byte
+	stored     []byte // the value as it is written, i.e. compressed
+	dbKey      string
+	delete     bool
+}
+
+// PgxBatch is the type that satisfies the ethdb.Batch interface for PG-IPFS Ethereum data using a pgx connection pool
+// Unlike Batch, operations are buffered in memory and only sent to Postgres, in a single transaction, when Write is called
+// Small batches are pipelined with pgx.Batch, large batches of puts are bulk loaded with COPY
+type PgxBatch struct {
+	pool      *pgxpool.Pool
+	codec     keycodec.KeyCodec
+	config    Config
+	queries   shared.Queries
+	ops       []pgxBatchOp
+	deletes   int
+	valueSize int
+
+	blockNumber *big.Int
+}
+
+// NewPgxBatch returns a ethdb.Batch interface for PG-IPFS using a pgx connection pool, storing blocks under the keys of the KeyCodec
+func NewPgxBatch(pool *pgxpool.Pool, codec keycodec.KeyCodec, blockNumber *big.Int) ethdb.Batch {
+	return newPgxBatch(pool, codec, DefaultConfig, blockNumber, 0)
+}
+
+func newPgxBatch(pool *pgxpool.Pool, codec keycodec.KeyCodec, config Config, blockNumber *big.Int, size int) *PgxBatch {
+	return &PgxBatch{
+		pool:        pool,
+		codec:       codec,
+		config:      config,
+		queries:     shared.NewQueries(config),
+		ops:         make([]pgxBatchOp, 0, size),
+		blockNumber: blockNumber,
+	}
+}
+
+// Put satisfies the ethdb.Batch interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value, or whatever the KeyCodec expects
+func (b *PgxBatch) Put(key []byte, value []byte) (err error) {
+	if b.blockNumber == nil {
+		return ErrNoBlockNumber
+	}
+	dbKey, err := b.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	if err := verifyPut(b.codec, b.config, key, value); err != nil {
+		return err
+	}
+	value = common.CopyBytes(value)
+	stored, err := b.config.Compression.Compress(value)
+	if err != nil {
+		return err
+	}
+	b.ops = append(b.ops, pgxBatchOp{key: common.CopyBytes(key), value: value, stored: stored, dbKey: dbKey})
+	b.valueSize += len(value)
+	return nil
+}
+
+// Delete satisfies the ethdb.Batch interface
+// Delete removes the key from the key-value data store
+func (b *PgxBatch) Delete(key []byte) (err error) {
+	dbKey, err := b.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	b.ops = append(b.ops, pgxBatchOp{key: common.CopyBytes(key), dbKey: dbKey, delete: true})
+	b.deletes++
+	return nil
+}
+
+// ValueSize satisfies the ethdb.Batch interface
+// ValueSize retrieves the amount of data queued up for writing
+// The returned value is the total byte length of all data queued to write
+func (b *PgxBatch) ValueSize() int {
+	return b.valueSize
+}
+
+// Write satisfies the ethdb.Batch interface
+// Write flushes any accumulated data to disk
+func (b *PgxBatch) Write() error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	if b.blockNumber == nil && len(b.ops) > b.deletes {
+		return ErrNoBlockNumber
+	}
+	ctx := context.Background()
+	// the whole transaction is sent again on a transient error, as the operations are buffered
+	return b.config.Retry.Retry(func() error {
+		return pgx.BeginFunc(ctx, b.pool, func(tx pgx.Tx) error {
+			// COPY can't interleave deletes, so it is only used when the order of operations doesn't matter
+			if b.deletes == 0 && len(b.ops) > CopyFromThreshold {
+				return b.copyFrom(ctx, tx)
+			}
+			return b.sendBatch(ctx, tx)
+		})
+	})
+}
+
+// sendBatch pipelines the operations, in order, in a single round trip
+func (b *PgxBatch) sendBatch(ctx context.Context, tx pgx.Tx) error {
+	batch := &pgx.Batch{}
+	for _, op := range b.ops {
+		if op.delete {
+			batch.Queue(b.queries.Delete, op.dbKey)
+		} else {
+			batch.Queue(b.queries.Put, op.dbKey, op.stored, b.blockNumber.Uint64())
+		}
+	}
+	return tx.SendBatch(ctx, batch).Close()
+}
+
+// copyFrom bulk loads the puts into a temporary staging table and moves them into the blocks table,
+// as COPY itself has no equivalent of ON CONFLICT DO NOTHING
+func (b *PgxBatch) copyFrom(ctx context.Context, tx pgx.Tx) error {
+	table := b.config.TableName()
+	createStaging := fmt.Sprintf("CREATE TEMPORARY TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", pgxStagingTable, table)
+	if _, err := tx.Exec(ctx, createStaging); err != nil {
+		return err
+	}
+	blockNumber := b.blockNumber.Uint64()
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{pgxStagingTable}, []string{"key", "data", "block_number"},
+		pgx.CopyFromSlice(len(b.ops), func(i int) ([]any, error) {
+			return []any{b.ops[i].dbKey, b.ops[i].stored, blockNumber}, nil
+		}))
+	if err != nil {
+		return err
+	}
+	moveStaged := fmt.Sprintf("INSERT INTO %s (key, data, block_number) SELECT key, data, block_number FROM %s ON CONFLICT DO NOTHING", table, pgxStagingTable)
+	_, err = tx.Exec(ctx, moveStaged)
+	return err
+}
+
+// Replay satisfies the ethdb.Batch interface
+// Replay replays the batch contents
+func (b *PgxBatch) Replay(w ethdb.KeyValueWriter) error {
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = w.Delete(op.key)
+		} else {
+			err = w.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset satisfies the ethdb.Batch interface
+// Reset resets the batch for reuse
+// This should be called after every write
+func (b *PgxBatch) Reset() {
+	b.ops = b.ops[:0]
+	b.deletes = 0
+	b.valueSize = 0
+}
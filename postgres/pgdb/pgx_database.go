@@ -0,0 +1,465 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	log "github.com/sirupsen/logrus"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var _ ethdb.Database = &PgxDatabase{}
+
+// PgxDatabase is the type that satisfies the ethdb.Database and ethdb.KeyValueStore interfaces for PG-IPFS Ethereum data using a pgx connection pool
+// It reads and writes the same table layout as Database, but uses pgx's binary protocol and statement cache rather than lib/pq
+// It doesn't route blocks to the CID tables, use a Database with a Router for that
+type PgxDatabase struct {
+	pool        *pgxpool.Pool
+	codec       keycodec.KeyCodec
+	config      Config
+	queries     shared.Queries
+	cache       *groupcache.Group
+	cacheExpiry time.Duration
+
+	// partitioning is discovered by the constructors that take a Config, and is nil otherwise
+	partitioning *shared.Partitioning
+
+	BlockNumber *big.Int
+}
+
+// NewPgxKeyValueStore returns a ethdb.KeyValueStore interface for PG-IPFS using a pgx connection pool,
+// storing blocks under the keys of the KeyCodec
+func NewPgxKeyValueStore(pool *pgxpool.Pool, codec keycodec.KeyCodec, cacheConfig CacheConfig) ethdb.KeyValueStore {
+	return newPgxDatabase(pool, codec, DefaultConfig, cacheConfig)
+}
+
+// NewPgxDatabase returns a ethdb.Database interface for PG-IPFS using a pgx connection pool,
+// storing blocks under the keys of the KeyCodec
+func NewPgxDatabase(pool *pgxpool.Pool, codec keycodec.KeyCodec, cacheConfig CacheConfig) ethdb.Database {
+	return newPgxDatabase(pool, codec, DefaultConfig, cacheConfig)
+}
+
+// NewPgxDatabaseWithConfig returns a ethdb.Database interface for PG-IPFS using a pgx connection pool,
+// over the blocks table named by the config
+// The config and schema are checked, and the partitioning discovered, as by NewDatabaseWithConfig
+func NewPgxDatabaseWithConfig(pool *pgxpool.Pool, codec keycodec.KeyCodec, config Config, cacheConfig CacheConfig) (ethdb.Database, error) {
+	// the checks run on database/sql, over a connection of their own as a pgx pool can't be shared with it
+	db := sqlx.NewDb(stdlib.OpenDB(*pool.Config().ConnConfig), "pgx")
+	defer db.Close()
+	if err := checkConfig(db, codec, config); err != nil {
+		return nil, err
+	}
+	partitioning, err := discoverPartitioning(db, config)
+	if err != nil {
+		return nil, err
+	}
+	database := newPgxDatabase(pool, codec, config, cacheConfig)
+	database.partitioning = partitioning
+	return database, nil
+}
+
+func newPgxDatabase(pool *pgxpool.Pool, codec keycodec.KeyCodec, config Config, cacheConfig CacheConfig) *PgxDatabase {
+	database := PgxDatabase{
+		pool:    pool,
+		codec:   codec,
+		config:  config,
+		queries: shared.NewQueries(config),
+	}
+	database.InitCache(cacheConfig)
+
+	return &database
+}
+
+func (d *PgxDatabase) InitCache(cacheConfig CacheConfig) {
+	d.cacheExpiry = cacheConfig.ExpiryDuration
+	d.cache = groupcache.NewGroup(cacheConfig.Name, int64(cacheConfig.Size), groupcache.GetterFunc(
+		func(_ context.Context, id string, dest groupcache.Sink) error {
+			// the query isn't bound by the timeout of the cache lookup, so that it has the retry budget
+			val, err := d.dbGet(context.Background(), id)
+			if err != nil {
+				return err
+			}
+
+			// Set the value in the groupcache, with expiry
+			return dest.SetBytes(val, time.Now().Add(cacheConfig.ExpiryDuration))
+		},
+	))
+}
+
+func (d *PgxDatabase) GetCacheStats() groupcache.Stats {
+	return d.cache.Stats
+}
+
+// Partitioning returns how the blocks table is partitioned
+// It is only discovered by the constructors that take a Config, ok is false otherwise
+func (d *PgxDatabase) Partitioning() (partitioning shared.Partitioning, ok bool) {
+	if d.partitioning == nil {
+		return shared.Partitioning{}, false
+	}
+	return *d.partitioning, true
+}
+
+func (d *PgxDatabase) ModifyAncients(f func(ethdb.AncientWriteOp) error) (int64, error) {
+	return 0, errNotSupported
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the key-value data store
+func (d *PgxDatabase) Has(key []byte) (bool, error) {
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = d.config.Retry.Retry(func() error {
+		return d.pool.QueryRow(context.Background(), d.queries.Has, dbKey).Scan(&exists)
+	})
+	if err != nil || exists || !d.config.DualRead {
+		return exists, err
+	}
+	_, err = getLegacy(d.getMany(context.Background()), dbKey)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// dbGet retrieves the given key if it's present in the key-value data store
+// A missing key is reported as sql.ErrNoRows, as it is by Database
+func (d *PgxDatabase) dbGet(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := d.config.Retry.Retry(func() error {
+		return d.pool.QueryRow(ctx, d.queries.Get, key).Scan(&data)
+	})
+	if errors.Is(err, pgx.ErrNoRows) && d.config.DualRead {
+		// the legacy values are decompressed as they are read
+		if data, err = getLegacy(d.getMany(ctx), key); err == nil {
+			return data, nil
+		}
+	}
+	if errors.Is(err, pgx.ErrNoRows) || err == sql.ErrNoRows {
+		log.Warn("Database miss for key", key)
+		return nil, sql.ErrNoRows
+	}
+	return shared.Decompress(data), err
+}
+
+// getMany returns a lookup that adds the values of the db keys that are present to found, for the dual reads
+func (d *PgxDatabase) getMany(ctx context.Context) func(keys []string, found map[string][]byte) error {
+	return func(keys []string, found map[string][]byte) error {
+		return d.config.Retry.Retry(func() error {
+			values, err := d.dbGetMany(ctx, keys)
+			if err != nil {
+				return err
+			}
+			for key, data := range values {
+				found[key] = data
+			}
+			return nil
+		})
+	}
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key if it's present in the key-value data store
+func (d *PgxDatabase) Get(key []byte) ([]byte, error) {
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	var data []byte
+	err = d.cache.Get(ctx, dbKey, groupcache.AllocatingByteSliceSink(&data))
+	return verified(d.codec, d.config, key, data, err)
+}
+
+// GetMany retrieves the values for the given keys with a single query
+// The returned values are in the same order as the keys, with a nil entry for each key that is not present
+// Values that are found are also added to the cache
+func (d *PgxDatabase) GetMany(keys [][]byte) ([][]byte, error) {
+	dbKeys, err := keycodec.Keys(d.codec, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string][]byte, len(dbKeys))
+	if err := d.getMany(context.Background())(dbKeys, found); err != nil {
+		return nil, err
+	}
+	if d.config.DualRead {
+		if err := getManyLegacy(d.getMany(context.Background()), dbKeys, found); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	for dbKey, data := range found {
+		if err := d.cache.Set(ctx, dbKey, data, time.Now().Add(d.cacheExpiry), false); err != nil {
+			log.Warn("Failed to cache value for key ", dbKey, ": ", err)
+		}
+	}
+
+	values := make([][]byte, len(keys))
+	for i, dbKey := range dbKeys {
+		values[i] = found[dbKey]
+	}
+	return verifiedMany(d.codec, d.config, keys, values, nil)
+}
+
+// dbGetMany retrieves the values present in the key-value data store for the given keys
+func (d *PgxDatabase) dbGetMany(ctx context.Context, dbKeys []string) (map[string][]byte, error) {
+	rows, err := d.pool.Query(ctx, d.queries.GetMany, dbKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string][]byte, len(dbKeys))
+	for rows.Next() {
+		var dbKey string
+		var data []byte
+		if err := rows.Scan(&dbKey, &data); err != nil {
+			return nil, err
+		}
+		found[dbKey] = shared.Decompress(data)
+	}
+	return found, rows.Err()
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value, or whatever the KeyCodec expects
+func (d *PgxDatabase) Put(key []byte, value []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	if d.BlockNumber == nil {
+		return ErrNoBlockNumber
+	}
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	if err := verifyPut(d.codec, d.config, key, value); err != nil {
+		return err
+	}
+	stored, err := d.config.Compression.Compress(value)
+	if err != nil {
+		return err
+	}
+	return d.config.Retry.Retry(func() error {
+		_, err := d.pool.Exec(context.Background(), d.queries.Put, dbKey, stored, d.BlockNumber.Uint64())
+		return err
+	})
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the key-value data store
+func (d *PgxDatabase) Delete(key []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return err
+	}
+
+	if _, err = d.pool.Exec(context.Background(), d.queries.Delete, dbKey); err != nil {
+		return err
+	}
+
+	// Remove from cache.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	return d.cache.Remove(ctx, dbKey)
+}
+
+// Stat satisfies the ethdb.Stater interface
+// Stat returns a particular internal stat of the database
+func (d *PgxDatabase) Stat(property string) (string, error) {
+	prop, err := DatabasePropertyFromString(property)
+	if err != nil {
+		return "", err
+	}
+	stat := d.pool.Stat()
+	switch prop {
+	case Size:
+		var byteSize string
+		return byteSize, d.pool.QueryRow(context.Background(), dbSizePgStr).Scan(&byteSize)
+	case Idle:
+		return strconv.Itoa(int(stat.IdleConns())), nil
+	case InUse:
+		return strconv.Itoa(int(stat.AcquiredConns())), nil
+	case MaxIdleClosed:
+		return strconv.FormatInt(stat.MaxIdleDestroyCount(), 10), nil
+	case MaxLifetimeClosed:
+		return strconv.FormatInt(stat.MaxLifetimeDestroyCount(), 10), nil
+	case MaxOpenConnections:
+		return strconv.Itoa(int(stat.MaxConns())), nil
+	case OpenConnections:
+		return strconv.Itoa(int(stat.TotalConns())), nil
+	case WaitCount:
+		return strconv.FormatInt(stat.EmptyAcquireCount(), 10), nil
+	case WaitDuration:
+		return stat.AcquireDuration().String(), nil
+	case PartitioningScheme:
+		if d.partitioning == nil {
+			return "unknown", nil
+		}
+		return d.partitioning.String(), nil
+	case ReadOnlyMode:
+		return strconv.FormatBool(d.config.ReadOnly), nil
+	default:
+		return "", fmt.Errorf("unhandled database property")
+	}
+}
+
+// Compact satisfies the ethdb.Compacter interface
+// Compact flattens the underlying data store for the given key range
+func (d *PgxDatabase) Compact(start []byte, limit []byte) error {
+	return errNotSupported
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a write-only database that buffers changes to its host db
+// until a final write is called
+func (d *PgxDatabase) NewBatch() ethdb.Batch {
+	return d.NewBatchWithSize(0)
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface.
+// NewBatchWithSize creates a write-only database batch with pre-allocated buffer.
+func (d *PgxDatabase) NewBatchWithSize(size int) ethdb.Batch {
+	if d.config.ReadOnly {
+		return ipfsethdb.ReadOnlyBatch{}
+	}
+	return newPgxBatch(d.pool, d.codec, d.config, d.BlockNumber, size)
+}
+
+// NewIterator satisfies the ethdb.Iteratee interface
+// it creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix, starting at a particular
+// initial key (or after, if it does not exist).
+//
+// Note: This method assumes that the prefix is NOT part of the start, so there's
+// no need for the caller to prepend the prefix to the start
+func (d *PgxDatabase) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	return &pgxIterator{db: d, prefix: prefix, currentKey: start}
+}
+
+// Close satisfies the io.Closer interface.
+// Close closes the connection pool and deregisters from groupcache.
+func (d *PgxDatabase) Close() error {
+	groupcache.DeregisterGroup(d.cache.Name())
+	d.pool.Close()
+	return nil
+}
+
+// HasAncient satisfies the ethdb.AncientReader interface
+// HasAncient returns an indicator whether the specified data exists in the ancient store
+func (d *PgxDatabase) HasAncient(kind string, number uint64) (bool, error) {
+	return false, errNotSupported
+}
+
+// Ancient satisfies the ethdb.AncientReader interface
+// Ancient retrieves an ancient binary blob from the append-only immutable files
+func (d *PgxDatabase) Ancient(kind string, number uint64) ([]byte, error) {
+	return nil, errNotSupported
+}
+
+// Ancients satisfies the ethdb.AncientReader interface
+// Ancients returns the ancient item numbers in the ancient store
+func (d *PgxDatabase) Ancients() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// Tail satisfies the ethdb.AncientReader interface.
+// Tail returns the number of first stored item in the freezer.
+func (d *PgxDatabase) Tail() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientSize satisfies the ethdb.AncientReader interface
+// AncientSize returns the ancient size of the specified category
+func (d *PgxDatabase) AncientSize(kind string) (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientRange retrieves all the items in a range, starting from the index 'start'.
+func (d *PgxDatabase) AncientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	return nil, errNotSupported
+}
+
+// ReadAncients applies the provided AncientReader function
+func (d *PgxDatabase) ReadAncients(fn func(ethdb.AncientReaderOp) error) (err error) {
+	return errNotSupported
+}
+
+// TruncateHead satisfies the ethdb.AncientWriter interface.
+// TruncateHead discards all but the first n ancient data from the ancient store.
+func (d *PgxDatabase) TruncateHead(n uint64) error {
+	return errNotSupported
+}
+
+// TruncateTail satisfies the ethdb.AncientWriter interface.
+// TruncateTail discards the first n ancient data from the ancient store.
+func (d *PgxDatabase) TruncateTail(n uint64) error {
+	return errNotSupported
+}
+
+// Sync satisfies the ethdb.AncientWriter interface
+// Sync flushes all in-memory ancient store data to disk
+func (d *PgxDatabase) Sync() error {
+	return errNotSupported
+}
+
+// MigrateTable satisfies the ethdb.AncientWriter interface.
+// MigrateTable processes and migrates entries of a given table to a new format.
+func (d *PgxDatabase) MigrateTable(string, func([]byte) ([]byte, error)) error {
+	return errNotSupported
+}
+
+// NewSnapshot satisfies the ethdb.Snapshotter interface.
+// NewSnapshot creates a database snapshot based on the current state.
+func (d *PgxDatabase) NewSnapshot() (ethdb.Snapshot, error) {
+	return nil, errNotSupported
+}
+
+// AncientDatadir returns an error as we don't have a backing chain freezer.
+func (d *PgxDatabase) AncientDatadir() (string, error) {
+	return "", errNotSupported
+}
@@ -0,0 +1,112 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+// ReplicaSelection enum type
+type ReplicaSelection int
+
+const (
+	// RoundRobin cycles through the replicas
+	RoundRobin ReplicaSelection = iota
+	// LeastConnections picks the replica with the fewest connections in use
+	LeastConnections
+)
+
+// replica is a read-only pool and the statements prepared on it
+type replica struct {
+	db    *sqlx.DB
+	stmts *shared.Statements
+}
+
+// NewDatabaseWithReplicas returns a ethdb.Database interface for PG-IPFS that splits reads from writes
+// Put, Delete and batches go to the primary, while Has, Get, GetMany and iterators are served by the replicas
+// A key missing from a replica is looked up on the primary, to absorb replication lag
+func NewDatabaseWithReplicas(primary *sqlx.DB, replicas []*sqlx.DB, codec keycodec.KeyCodec, selection ReplicaSelection, config Config, cacheConfig CacheConfig) (ethdb.Database, error) {
+	if selection != RoundRobin && selection != LeastConnections {
+		return nil, fmt.Errorf("unknown replica selection %d", selection)
+	}
+	if err := checkConfig(primary, codec, config); err != nil {
+		return nil, err
+	}
+	database := Database{db: primary, stmts: shared.NewStatements(primary, config), codec: codec, config: config, selection: selection}
+	if err := database.stmts.Prepare(); err != nil {
+		return nil, err
+	}
+	if err := database.discoverPartitioning(); err != nil {
+		return nil, err
+	}
+	for _, db := range replicas {
+		r := &replica{db: db, stmts: shared.NewStatements(db, config)}
+		if err := r.stmts.Prepare(); err != nil {
+			return nil, err
+		}
+		database.replicas = append(database.replicas, r)
+	}
+	database.InitCache(cacheConfig)
+
+	return &database, nil
+}
+
+// reader returns the replica to serve the next read, or nil if reads go to the primary
+func (d *Database) reader() *replica {
+	switch len(d.replicas) {
+	case 0:
+		return nil
+	case 1:
+		return d.replicas[0]
+	}
+	if d.selection == LeastConnections {
+		least := d.replicas[0]
+		inUse := least.db.Stats().InUse
+		for _, r := range d.replicas[1:] {
+			if n := r.db.Stats().InUse; n < inUse {
+				least, inUse = r, n
+			}
+		}
+		return least
+	}
+	n := atomic.AddUint64(&d.next, 1)
+	return d.replicas[n%uint64(len(d.replicas))]
+}
+
+// pool returns the pool with the given name, "primary" or "replica<index>"
+func (d *Database) pool(name string) (*sqlx.DB, error) {
+	name = strings.ToLower(name)
+	if name == "primary" {
+		return d.db, nil
+	}
+	if strings.HasPrefix(name, "replica") {
+		index, err := strconv.Atoi(strings.TrimPrefix(name, "replica"))
+		if err == nil && index >= 0 && index < len(d.replicas) {
+			return d.replicas[index].db, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown database pool %s", name)
+}
@@ -0,0 +1,212 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	upsertHeaderCIDPgStr = `INSERT INTO eth.header_cids (block_number, block_hash, parent_hash, cid, td, node_ids, reward, state_root,
+tx_root, receipt_root, uncles_hash, bloom, timestamp, coinbase) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+ON CONFLICT (block_hash, block_number) DO UPDATE SET cid = EXCLUDED.cid`
+	upsertTransactionCIDPgStr = `INSERT INTO eth.transaction_cids (block_number, header_id, tx_hash, cid, dst, src, index, tx_type, value)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (tx_hash, header_id, block_number) DO UPDATE SET cid = EXCLUDED.cid`
+	upsertReceiptCIDPgStr = `INSERT INTO eth.receipt_cids (block_number, header_id, tx_id, cid, contract, post_state, post_status)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (tx_id, header_id, block_number) DO UPDATE SET cid = EXCLUDED.cid`
+	upsertStateCIDPgStr = `INSERT INTO eth.state_cids (block_number, header_id, state_leaf_key, cid, diff, removed)
+VALUES ($1, $2, $3, $4, true, false)
+ON CONFLICT (state_leaf_key, header_id, block_number) DO UPDATE SET cid = EXCLUDED.cid`
+	upsertStorageCIDPgStr = `INSERT INTO eth.storage_cids (block_number, header_id, state_leaf_key, storage_leaf_key, cid, diff, removed)
+VALUES ($1, $2, $3, $4, $5, true, false)
+ON CONFLICT (storage_leaf_key, state_leaf_key, header_id, block_number) DO UPDATE SET cid = EXCLUDED.cid`
+)
+
+var errMissingHeaderID = errors.New("index context is missing the header id")
+
+// IndexContext holds what is needed to index a block that can't be derived from the block itself
+// It is set on the Database, like the BlockNumber, before putting the blocks it applies to
+type IndexContext struct {
+	// HeaderID is the hash of the header the transactions, receipts and trie nodes belong to
+	HeaderID string
+	// TotalDifficulty, Reward and NodeIDs complete the header_cids row of a header
+	TotalDifficulty *big.Int
+	Reward          *big.Int
+	NodeIDs         []string
+	// TxID is the hash of the transaction a receipt belongs to, TxIndex is its index in the block and TxSrc its sender
+	TxID    string
+	TxIndex int64
+	TxSrc   string
+	// Contract is the address of the contract created by a receipt's transaction, if any
+	Contract string
+	// StateLeafKey and StorageLeafKey are the leaf keys of a state or storage trie leaf node
+	// Only leaf nodes are indexed, trie nodes put while they are empty are just written to ipld.blocks
+	StateLeafKey   string
+	StorageLeafKey string
+}
+
+// IndexedBlock is a CID-keyed block that is being written, with what is needed to index it
+type IndexedBlock struct {
+	CID         cid.Cid
+	Data        []byte
+	BlockNumber uint64
+	IndexContext
+}
+
+// Indexer inserts or updates the row indexing a block in one of the CID tables
+// It is called in the transaction the block is written in
+type Indexer interface {
+	Index(tx *sqlx.Tx, block IndexedBlock) error
+}
+
+// IndexerFunc adapts a function to the Indexer interface
+type IndexerFunc func(tx *sqlx.Tx, block IndexedBlock) error
+
+// Index calls the function
+func (f IndexerFunc) Index(tx *sqlx.Tx, block IndexedBlock) error {
+	return f(tx, block)
+}
+
+// Router maps CID codecs to the Indexers of their CID tables
+// Blocks with codecs that are not in the Router are only written to the blocks table
+type Router map[uint64]Indexer
+
+// DefaultRouter returns a Router that indexes the Ethereum codecs in the ipld-eth-db eth.*_cids tables
+// Headers, transactions and receipts are decoded for the columns they determine, the rest are taken from the IndexContext
+func DefaultRouter() Router {
+	return Router{
+		cid.EthBlock:       IndexerFunc(indexHeader),
+		cid.EthTx:          IndexerFunc(indexTransaction),
+		cid.EthTxReceipt:   IndexerFunc(indexReceipt),
+		cid.EthStateTrie:   IndexerFunc(indexStateNode),
+		cid.EthStorageTrie: IndexerFunc(indexStorageNode),
+	}
+}
+
+// index runs the Indexer for the block's codec, if there is one
+func (r Router) index(tx *sqlx.Tx, block IndexedBlock) error {
+	indexer, ok := r[block.CID.Type()]
+	if !ok {
+		return nil
+	}
+	if err := indexer.Index(tx, block); err != nil {
+		return fmt.Errorf("indexing %s: %w", block.CID, err)
+	}
+	return nil
+}
+
+// routes returns whether the Router has an Indexer for the CID's codec
+func (r Router) routes(c cid.Cid) bool {
+	_, ok := r[c.Type()]
+	return ok
+}
+
+func indexHeader(tx *sqlx.Tx, block IndexedBlock) error {
+	var header types.Header
+	if err := rlp.DecodeBytes(block.Data, &header); err != nil {
+		return err
+	}
+	_, err := tx.Exec(upsertHeaderCIDPgStr, block.BlockNumber, header.Hash().Hex(), header.ParentHash.Hex(), block.CID.String(),
+		bigString(block.TotalDifficulty), pq.Array(block.NodeIDs), bigString(block.Reward), header.Root.Hex(),
+		header.TxHash.Hex(), header.ReceiptHash.Hex(), header.UncleHash.Hex(), header.Bloom.Bytes(), header.Time,
+		header.Coinbase.Hex())
+	return err
+}
+
+func indexTransaction(tx *sqlx.Tx, block IndexedBlock) error {
+	if block.HeaderID == "" {
+		return errMissingHeaderID
+	}
+	var transaction types.Transaction
+	if err := transaction.UnmarshalBinary(block.Data); err != nil {
+		return err
+	}
+	var dst string
+	if to := transaction.To(); to != nil {
+		dst = to.Hex()
+	}
+	_, err := tx.Exec(upsertTransactionCIDPgStr, block.BlockNumber, block.HeaderID, transaction.Hash().Hex(),
+		block.CID.String(), dst, block.TxSrc, block.TxIndex, transaction.Type(), transaction.Value().String())
+	return err
+}
+
+func indexReceipt(tx *sqlx.Tx, block IndexedBlock) error {
+	if block.HeaderID == "" {
+		return errMissingHeaderID
+	}
+	var receipt types.Receipt
+	if err := receipt.UnmarshalBinary(block.Data); err != nil {
+		return err
+	}
+	var postState interface{}
+	if len(receipt.PostState) > 0 {
+		postState = common.BytesToHash(receipt.PostState).Hex()
+	}
+	_, err := tx.Exec(upsertReceiptCIDPgStr, block.BlockNumber, block.HeaderID, block.TxID, block.CID.String(),
+		nullString(block.Contract), postState, receipt.Status)
+	return err
+}
+
+func indexStateNode(tx *sqlx.Tx, block IndexedBlock) error {
+	if block.StateLeafKey == "" {
+		return nil
+	}
+	if block.HeaderID == "" {
+		return errMissingHeaderID
+	}
+	_, err := tx.Exec(upsertStateCIDPgStr, block.BlockNumber, block.HeaderID, block.StateLeafKey, block.CID.String())
+	return err
+}
+
+func indexStorageNode(tx *sqlx.Tx, block IndexedBlock) error {
+	if block.StorageLeafKey == "" {
+		return nil
+	}
+	if block.HeaderID == "" {
+		return errMissingHeaderID
+	}
+	_, err := tx.Exec(upsertStorageCIDPgStr, block.BlockNumber, block.HeaderID, block.StateLeafKey,
+		block.StorageLeafKey, block.CID.String())
+	return err
+}
+
+// nullString returns the string, or nil to leave the column NULL if it is empty
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// bigString returns the decimal string of the number, or nil to leave the column NULL
+func bigString(n *big.Int) interface{} {
+	if n == nil {
+		return nil
+	}
+	return n.String()
+}
@@ -0,0 +1,332 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var (
+	errNoSavepoint      = errors.New("batch does not have a savepoint to roll back to")
+	errSavepointWritten = errors.New("batch has already been written to the transaction")
+)
+
+// txState is the caller's transaction shared by a TxDatabase and its batches
+type txState struct {
+	tx         *sqlx.Tx
+	savepoints uint64
+}
+
+// nextSavepoint returns a savepoint name that is unique within the transaction
+func (t *txState) nextSavepoint() string {
+	return fmt.Sprintf("pgipfsethdb_%d", atomic.AddUint64(&t.savepoints, 1))
+}
+
+var _ ethdb.Database = &TxDatabase{}
+
+// TxDatabase is a view of a Database over a transaction owned by the caller
+// Reads and writes are executed in the transaction, so the IPLD blocks can be written atomically with other tables
+// such as eth.header_cids, and committing or rolling back the transaction is left to the caller
+// The views it promotes from Database, e.g. WithBlockRange, Blockstore and Datastore, use the pool rather than the transaction
+// The cache is bypassed, as the transaction's writes are not visible to other connections until it is committed
+type TxDatabase struct {
+	*Database
+	txState *txState
+
+	BlockNumber *big.Int
+}
+
+// WithTx returns a view of the database over the caller's transaction
+// The transaction must have been begun on the same sqlx.DB as the database's primary
+func (d *Database) WithTx(tx *sqlx.Tx) *TxDatabase {
+	return &TxDatabase{
+		Database:    d,
+		txState:     &txState{tx: tx},
+		BlockNumber: d.BlockNumber,
+	}
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the key-value data store
+func (d *TxDatabase) Has(key []byte) (bool, error) {
+	return d.has(key, (*shared.Statements).Has)
+}
+
+// HasAtRange retrieves if a key is present in the key-value data store at a block number between lo and hi inclusive
+func (d *TxDatabase) HasAtRange(key []byte, lo, hi uint64) (bool, error) {
+	return d.has(key, (*shared.Statements).HasAtRange, lo, hi)
+}
+
+// has runs the Has query, or its AtRange variant with the extra range arguments, in the transaction
+func (d *TxDatabase) has(key []byte, query statement, args ...interface{}) (exists bool, err error) {
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return false, err
+	}
+	stmt, err := query(d.stmts)
+	if err != nil {
+		return false, err
+	}
+	return exists, d.txState.tx.Stmtx(stmt).Get(&exists, append([]interface{}{dbKey}, args...)...)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key if it's present in the key-value data store
+func (d *TxDatabase) Get(key []byte) ([]byte, error) {
+	return d.get(key, (*shared.Statements).Get)
+}
+
+// GetAtRange retrieves the given key if it's present in the key-value data store at a block number between lo and hi inclusive
+func (d *TxDatabase) GetAtRange(key []byte, lo, hi uint64) ([]byte, error) {
+	return d.get(key, (*shared.Statements).GetAtRange, lo, hi)
+}
+
+// get runs the Get query, or its AtRange variant with the extra range arguments, in the transaction
+func (d *TxDatabase) get(key []byte, query statement, args ...interface{}) (data []byte, err error) {
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := query(d.stmts)
+	if err != nil {
+		return nil, err
+	}
+	err = d.txState.tx.Stmtx(stmt).Get(&data, append([]interface{}{dbKey}, args...)...)
+	return verified(d.codec, d.config, key, shared.Decompress(data), err)
+}
+
+// GetMany retrieves the values for the given keys with a single query
+// The returned values are in the same order as the keys, with a nil entry for each key that is not present
+func (d *TxDatabase) GetMany(keys [][]byte) ([][]byte, error) {
+	return d.getMany(keys, (*shared.Statements).GetMany)
+}
+
+// GetManyAtRange retrieves the values for the given keys at block numbers between lo and hi inclusive with a single query
+// The returned values are in the same order as the keys, with a nil entry for each key that is not present in the range
+func (d *TxDatabase) GetManyAtRange(keys [][]byte, lo, hi uint64) ([][]byte, error) {
+	return d.getMany(keys, (*shared.Statements).GetManyAtRange, lo, hi)
+}
+
+// getMany runs the GetMany query, or its AtRange variant with the extra range arguments, in the transaction
+func (d *TxDatabase) getMany(keys [][]byte, query statement, args ...interface{}) ([][]byte, error) {
+	dbKeys, err := keycodec.Keys(d.codec, keys)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := query(d.stmts)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.txState.tx.Stmtx(stmt).Queryx(append([]interface{}{pq.Array(dbKeys)}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string][]byte, len(keys))
+	for rows.Next() {
+		var dbKey string
+		var data []byte
+		if err := rows.Scan(&dbKey, &data); err != nil {
+			return nil, err
+		}
+		found[dbKey] = shared.Decompress(data)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(keys))
+	for i, dbKey := range dbKeys {
+		values[i] = found[dbKey]
+	}
+	return verifiedMany(d.codec, d.config, keys, values, nil)
+}
+
+// NewIterator satisfies the ethdb.Iteratee interface
+// NewIterator creates an iterator that reads its values in the transaction
+func (d *TxDatabase) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	it := newIterator(start, prefix, d.db, d.codec, d.stmts, false)
+	it.tx = d.txState.tx
+	return it
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value, or whatever the KeyCodec expects
+// With a Router the block is also indexed in the CID table for its codec
+func (d *TxDatabase) Put(key []byte, value []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	if d.BlockNumber == nil {
+		return ErrNoBlockNumber
+	}
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	if err := verifyPut(d.codec, d.config, key, value); err != nil {
+		return err
+	}
+	stored, err := d.config.Compression.Compress(value)
+	if err != nil {
+		return err
+	}
+	stmt, err := d.stmts.Put()
+	if err != nil {
+		return err
+	}
+	if _, err = d.txState.tx.Stmtx(stmt).Exec(dbKey, stored, d.BlockNumber.Uint64()); err != nil || d.Router == nil {
+		return err
+	}
+	c, err := d.codec.CID(key)
+	if err != nil {
+		return err
+	}
+	return d.Router.index(d.txState.tx, IndexedBlock{CID: c, Data: value, BlockNumber: d.BlockNumber.Uint64(), IndexContext: d.Index})
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the key-value data store
+func (d *TxDatabase) Delete(key []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	dbKey, err := d.codec.Key(key)
+	if err != nil {
+		return err
+	}
+	stmt, err := d.stmts.Delete()
+	if err != nil {
+		return err
+	}
+	if _, err = d.txState.tx.Stmtx(stmt).Exec(dbKey); err != nil {
+		return err
+	}
+
+	// Remove from cache, the value may have been cached before the transaction
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+	return d.cache.Remove(ctx, dbKey)
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a batch that writes straight into the caller's transaction
+// Writing the batch does not commit the transaction, use NewSavepointBatch for a batch that can be rolled back on its own
+func (d *TxDatabase) NewBatch() ethdb.Batch {
+	if d.config.ReadOnly {
+		return ipfsethdb.ReadOnlyBatch{}
+	}
+	return d.newBatch()
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface.
+// NewBatchWithSize creates a batch that writes straight into the caller's transaction
+func (d *TxDatabase) NewBatchWithSize(size int) ethdb.Batch {
+	return d.NewBatch()
+}
+
+func (d *TxDatabase) newBatch() *Batch {
+	b := d.Database.newBatch(d.txState.tx)
+	b.callerTx = true
+	b.blockNumber = d.BlockNumber
+	return b
+}
+
+// NewSavepointBatch creates a batch that sets a savepoint in the caller's transaction
+// Rollback discards the batch's operations, while Write releases the savepoint and keeps them in the transaction
+// A batch that is reused after Write sets a new savepoint on its next operation
+// Savepoint batches nest in the order they are created: rolling back a batch also discards the work of the batches
+// created after it, so nested batches should be written or rolled back before the batches they are nested in
+func (d *TxDatabase) NewSavepointBatch() (*Batch, error) {
+	if d.config.ReadOnly {
+		return nil, ipfsethdb.ErrReadOnly
+	}
+	b := d.newBatch()
+	b.txState = d.txState
+	if err := b.setSavepoint(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close satisfies the io.Closer interface
+// Close does nothing, the transaction and the database are owned by the caller
+func (d *TxDatabase) Close() error {
+	return nil
+}
+
+// Rollback discards the operations of a savepoint batch by rolling back to its savepoint
+// The savepoint is kept, so the batch can be reused
+func (b *Batch) Rollback() error {
+	if b.txState == nil {
+		return errNoSavepoint
+	}
+	if b.savepoint == "" {
+		return errSavepointWritten
+	}
+	if _, err := b.tx.Exec("ROLLBACK TO SAVEPOINT " + b.savepoint); err != nil {
+		return err
+	}
+	b.ops = b.ops[:0]
+	b.valueSize = 0
+	return nil
+}
+
+func (b *Batch) setSavepoint() error {
+	name := b.txState.nextSavepoint()
+	if _, err := b.tx.Exec("SAVEPOINT " + name); err != nil {
+		return err
+	}
+	b.savepoint = name
+	return nil
+}
+
+func (b *Batch) releaseSavepoint() error {
+	if b.savepoint == "" {
+		return nil
+	}
+	if _, err := b.tx.Exec("RELEASE SAVEPOINT " + b.savepoint); err != nil {
+		return err
+	}
+	b.savepoint = ""
+	return nil
+}
+
+// resetSavepoint discards the operations since the savepoint if the batch hasn't been written
+// A written batch sets a new savepoint on its next operation
+func (b *Batch) resetSavepoint() error {
+	if b.savepoint == "" {
+		return nil
+	}
+	_, err := b.tx.Exec("ROLLBACK TO SAVEPOINT " + b.savepoint)
+	return err
+}
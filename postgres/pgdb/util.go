@@ -0,0 +1,53 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	_ "github.com/lib/pq" //postgres driver
+)
+
+// legacyCodecs are the codecs of the Ethereum IPLD blocks, which postgres/v0 stored under CID string keys
+var legacyCodecs = []uint64{
+	cid.EthBlock,
+	cid.EthBlockList,
+	cid.EthTxTrie,
+	cid.EthTx,
+	cid.EthTxReceiptTrie,
+	cid.EthTxReceipt,
+	cid.EthStateTrie,
+	cid.EthAccountSnapshot,
+	cid.EthStorageTrie,
+}
+
+// legacyKeys returns the postgres/v0 CID string keys a keycodec.Multihash db key may have been stored under, one per Ethereum codec
+func legacyKeys(mhKey string) ([]string, error) {
+	mh, err := dshelp.DsKeyToMultihash(datastore.NewKey(strings.TrimPrefix(mhKey, blockstore.BlockPrefix.String())))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(legacyCodecs))
+	for i, codec := range legacyCodecs {
+		keys[i] = cid.NewCidV1(codec, mh).String()
+	}
+	return keys, nil
+}
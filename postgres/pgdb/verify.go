@@ -0,0 +1,59 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgdb
+
+import (
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+)
+
+// verified checks the value read for the key against it with the hasher of the KeyCodec, when Config.Verify is set
+// The value is dropped if it doesn't match, or if the read failed
+func verified(codec keycodec.KeyCodec, config Config, key, value []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if config.Verify {
+		if err := keycodec.Verify(codec, key, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// verifiedMany checks the values read for the keys, skipping the keys that are missing
+func verifiedMany(codec keycodec.KeyCodec, config Config, keys, values [][]byte, err error) ([][]byte, error) {
+	if err != nil || !config.Verify {
+		return values, err
+	}
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		if err := keycodec.Verify(codec, keys[i], value); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// verifyPut checks a value that is about to be written against its key, when Config.Verify is set
+func verifyPut(codec keycodec.KeyCodec, config Config, key, value []byte) error {
+	if !config.Verify {
+		return nil
+	}
+	return keycodec.Verify(codec, key, value)
+}
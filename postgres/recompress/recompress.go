@@ -0,0 +1,106 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package recompress converts the values of the existing rows of a blocks table to a compression algorithm
+package recompress
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var DefaultConfig = Config{
+	Table:       shared.DefaultConfig,
+	Compression: shared.Zstd,
+	BatchSize:   1000,
+}
+
+// Config holds the settings of a recompression job
+type Config struct {
+	// Table is the blocks table whose rows are converted
+	Table shared.Config
+	// Compression is the algorithm the values are converted to, NoCompression decompresses them
+	Compression shared.Compression
+	// BatchSize is the number of rows read, and updated, in each transaction
+	BatchSize int
+	// FromBlock and ToBlock bound the block numbers of the rows that are converted, a zero ToBlock means no upper bound
+	// A job that is interrupted can be resumed from the BlockNumber of its last Progress
+	FromBlock, ToBlock uint64
+	// Pause is the time waited between batches, to limit the load the job puts on the database
+	Pause time.Duration
+	// Progress, if set, is called after each batch is committed
+	Progress func(Progress)
+}
+
+// Progress reports how far a job has got
+type Progress struct {
+	// BlockNumber is the block number of the last row read
+	BlockNumber uint64
+	// Converted is the number of rows rewritten with the new algorithm
+	Converted int64
+	// Skipped is the number of rows left as they are, as they already use the algorithm or don't compress
+	Skipped int64
+	// SavedBytes is the reduction in the size of the rewritten values, negative when decompressing
+	SavedBytes int64
+	// Done is set once all the rows in the range have been read
+	Done bool
+}
+
+// Run converts the rows of the table, in block number order, until none are left or the context is cancelled
+// It is meant to be run in the background alongside the ethdbs, which read compressed and uncompressed rows alike
+// Rows are rewritten in place, so the job is safe to run again over rows it has already converted
+func Run(ctx context.Context, db *sqlx.DB, config Config) (Progress, error) {
+	var progress, pending Progress
+	pager := shared.Pager{
+		Table:     config.Table,
+		BatchSize: config.BatchSize,
+		FromBlock: config.FromBlock,
+		ToBlock:   config.ToBlock,
+		Pause:     config.Pause,
+	}
+	rewrite := func(rows []shared.Row) ([]shared.Row, error) {
+		var updates []shared.Row
+		pending = progress
+		for _, row := range rows {
+			converted, err := config.Compression.Compress(shared.Decompress(row.Data))
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(converted, row.Data) {
+				pending.Skipped++
+				continue
+			}
+			pending.Converted++
+			pending.SavedBytes += int64(len(row.Data) - len(converted))
+			updates = append(updates, shared.Row{Key: row.Key, Data: converted, BlockNumber: row.BlockNumber})
+		}
+		return updates, nil
+	}
+	committed := func(blockNumber uint64, done bool) {
+		progress = pending
+		progress.BlockNumber, progress.Done = blockNumber, done
+		if config.Progress != nil {
+			config.Progress(progress)
+		}
+	}
+	err := pager.Run(ctx, db, rewrite, committed)
+	return progress, err
+}
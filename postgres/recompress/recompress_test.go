@@ -0,0 +1,146 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package recompress_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/recompress"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var _ = Describe("Run", func() {
+	var (
+		db          *sqlx.DB
+		err         error
+		ctx         = context.Background()
+		keys        [][]byte
+		values      [][]byte
+		cacheConfig = pgipfsethdb.CacheConfig{
+			Name:           "recompress",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		}
+
+		expectReadable = func() {
+			database, err := pgipfsethdb.NewDatabaseWithConfig(db, pgipfsethdb.DefaultConfig, cacheConfig)
+			Expect(err).ToNot(HaveOccurred())
+			defer groupcache.DeregisterGroup(cacheConfig.Name)
+			for i, key := range keys {
+				val, err := database.Get(key)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(val).To(Equal(values[i]))
+			}
+		}
+		countCompressed = func() (n int) {
+			var stored [][]byte
+			Expect(db.Select(&stored, "SELECT data FROM ipld.blocks")).To(Succeed())
+			for _, data := range stored {
+				if shared.IsCompressed(data) {
+					n++
+				}
+			}
+			return n
+		}
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+
+		keys, values = nil, nil
+		for i := 1; i <= 5; i++ {
+			key := bytes.Repeat([]byte{byte(i)}, 32)
+			value := bytes.Repeat([]byte(fmt.Sprintf("mockValue%d", i)), 50)
+			mhKey, err := pgipfsethdb.MultihashKeyFromKeccak256(key)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = db.Exec("INSERT INTO ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", mhKey, value, i)
+			Expect(err).ToNot(HaveOccurred())
+			keys, values = append(keys, key), append(values, value)
+		}
+		// a value too small to shrink is left as it is
+		_, err = db.Exec("INSERT INTO ipld.blocks (key, data, block_number) VALUES ('small', '\\x01', 3)")
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(shared.ResetTestDB(db)).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("compresses the existing rows, and decompresses them again", func() {
+		config := recompress.DefaultConfig
+		config.BatchSize = 2
+		var reports []recompress.Progress
+		config.Progress = func(p recompress.Progress) { reports = append(reports, p) }
+
+		progress, err := recompress.Run(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Converted).To(Equal(int64(5)))
+		Expect(progress.Skipped).To(Equal(int64(1)))
+		Expect(progress.SavedBytes).To(BeNumerically(">", 0))
+		Expect(progress.Done).To(BeTrue())
+		Expect(reports).To(HaveLen(3))
+		Expect(countCompressed()).To(Equal(5))
+		expectReadable()
+
+		progress, err = recompress.Run(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Converted).To(BeZero())
+
+		config.Compression = shared.NoCompression
+		progress, err = recompress.Run(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Converted).To(Equal(int64(5)))
+		Expect(countCompressed()).To(BeZero())
+		expectReadable()
+	})
+
+	It("converts rows from one algorithm to another", func() {
+		config := recompress.DefaultConfig
+		config.Compression = shared.Snappy
+		_, err := recompress.Run(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+
+		config.Compression = shared.Zstd
+		progress, err := recompress.Run(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Converted).To(Equal(int64(5)))
+		expectReadable()
+	})
+
+	It("only converts the rows in the block range, and stops when cancelled", func() {
+		config := recompress.DefaultConfig
+		config.FromBlock, config.ToBlock = 2, 4
+		_, err := recompress.Run(ctx, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(countCompressed()).To(Equal(3))
+
+		cancelCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = recompress.Run(cancelCtx, db, recompress.DefaultConfig)
+		Expect(err).To(MatchError(context.Canceled))
+	})
+})
@@ -0,0 +1,169 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm values are compressed with before they are written to the blocks table
+// A compressed value is stored behind a marker byte identifying the algorithm, so compressed and uncompressed rows
+// can coexist, and the payloads carry a checksum so that an uncompressed value starting with a marker byte is
+// not mistaken for a compressed one
+type Compression int
+
+const (
+	NoCompression Compression = iota
+	Zstd
+	Snappy
+)
+
+const (
+	zstdMarker   byte = 0xfd
+	snappyMarker byte = 0xfe
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// CompressionFromString helper function
+func CompressionFromString(compression string) (Compression, error) {
+	switch strings.ToLower(compression) {
+	case "", "none":
+		return NoCompression, nil
+	case "zstd":
+		return Zstd, nil
+	case "snappy":
+		return Snappy, nil
+	default:
+		return NoCompression, fmt.Errorf("unknown compression %s", compression)
+	}
+}
+
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// Marker returns the marker byte of values compressed with the algorithm
+func (c Compression) Marker() (byte, error) {
+	switch c {
+	case Zstd:
+		return zstdMarker, nil
+	case Snappy:
+		return snappyMarker, nil
+	default:
+		return 0, fmt.Errorf("%s does not have a marker", c)
+	}
+}
+
+// Compress returns the value as it is stored with the algorithm
+// The value is stored uncompressed if compressing it doesn't make it smaller
+func (c Compression) Compress(value []byte) ([]byte, error) {
+	var compressed []byte
+	switch c {
+	case NoCompression:
+		return value, nil
+	case Zstd:
+		enc, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		compressed = enc.EncodeAll(value, []byte{zstdMarker})
+	case Snappy:
+		compressed = make([]byte, 1, 1+snappy.MaxEncodedLen(len(value))+crc32.Size)
+		compressed[0] = snappyMarker
+		compressed = append(compressed, snappy.Encode(nil, value)...)
+		compressed = binary.LittleEndian.AppendUint32(compressed, crc32.Checksum(value, castagnoli))
+	default:
+		return nil, fmt.Errorf("unknown compression %d", c)
+	}
+	if len(compressed) >= len(value) {
+		return value, nil
+	}
+	return compressed, nil
+}
+
+// Decompress returns the value as it was before it was stored
+// Values that don't start with a marker byte, or whose payload doesn't decode and match its checksum, are returned as they are
+func Decompress(stored []byte) []byte {
+	if value, ok := decompress(stored); ok {
+		return value
+	}
+	return stored
+}
+
+// IsCompressed returns whether the stored value is compressed, with any algorithm
+func IsCompressed(stored []byte) bool {
+	_, ok := decompress(stored)
+	return ok
+}
+
+func decompress(stored []byte) ([]byte, bool) {
+	if len(stored) < 2 {
+		return nil, false
+	}
+	switch stored[0] {
+	case zstdMarker:
+		_, dec, err := zstdCodec()
+		if err != nil {
+			return nil, false
+		}
+		value, err := dec.DecodeAll(stored[1:], nil)
+		return value, err == nil
+	case snappyMarker:
+		if len(stored) < 1+crc32.Size {
+			return nil, false
+		}
+		payload, sum := stored[1:len(stored)-crc32.Size], stored[len(stored)-crc32.Size:]
+		value, err := snappy.Decode(nil, payload)
+		return value, err == nil && crc32.Checksum(value, castagnoli) == binary.LittleEndian.Uint32(sum)
+	}
+	return nil, false
+}
+
+// zstdCodec returns the shared zstd encoder and decoder, which are safe for concurrent use with EncodeAll and DecodeAll
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil, zstd.WithEncoderCRC(true)); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
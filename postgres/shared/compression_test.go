@@ -0,0 +1,81 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var _ = Describe("Compression", func() {
+	var (
+		value = bytes.Repeat([]byte("mockValue"), 100)
+		small = []byte{0x01, 0x02}
+	)
+
+	for _, compression := range []shared.Compression{shared.Zstd, shared.Snappy} {
+		compression := compression
+		It("round trips values compressed with "+compression.String(), func() {
+			stored, err := compression.Compress(value)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(stored)).To(BeNumerically("<", len(value)))
+			marker, err := compression.Marker()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stored[0]).To(Equal(marker))
+			Expect(shared.IsCompressed(stored)).To(BeTrue())
+			Expect(shared.Decompress(stored)).To(Equal(value))
+		})
+
+		It("stores values that don't shrink uncompressed with "+compression.String(), func() {
+			stored, err := compression.Compress(small)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stored).To(Equal(small))
+		})
+	}
+
+	It("passes uncompressed values through", func() {
+		stored, err := shared.NoCompression.Compress(value)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stored).To(Equal(value))
+		Expect(shared.IsCompressed(value)).To(BeFalse())
+		Expect(shared.Decompress(value)).To(Equal(value))
+	})
+
+	It("doesn't mistake an uncompressed value starting with a marker byte for a compressed one", func() {
+		for _, compression := range []shared.Compression{shared.Zstd, shared.Snappy} {
+			marker, err := compression.Marker()
+			Expect(err).ToNot(HaveOccurred())
+			raw := append([]byte{marker}, value...)
+			Expect(shared.IsCompressed(raw)).To(BeFalse())
+			Expect(shared.Decompress(raw)).To(Equal(raw))
+		}
+	})
+
+	It("parses compression names", func() {
+		for _, compression := range []shared.Compression{shared.NoCompression, shared.Zstd, shared.Snappy} {
+			parsed, err := shared.CompressionFromString(compression.String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsed).To(Equal(compression))
+		}
+		_, err := shared.CompressionFromString("lz4")
+		Expect(err).To(HaveOccurred())
+	})
+})
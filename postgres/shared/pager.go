@@ -0,0 +1,160 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// nextRowsPgStr pages through the rows in (block_number, key) order
+	nextRowsPgStr = `SELECT key, data, block_number FROM %s
+WHERE (block_number, key) > ($1, $2) AND block_number <= $3
+ORDER BY block_number, key LIMIT $4`
+	updateRowsPgStr = `UPDATE %s AS b SET data = u.data
+FROM unnest($1::TEXT[], $2::BIGINT[], $3::BYTEA[]) AS u (key, block_number, data)
+WHERE b.key = u.key AND b.block_number = u.block_number`
+)
+
+// Row is a row of a blocks table, as read and updated by a Pager
+type Row struct {
+	Key         string `db:"key"`
+	Data        []byte `db:"data"`
+	BlockNumber uint64 `db:"block_number"`
+}
+
+// Pager rewrites the rows of a blocks table in place, in batches read in (block_number, key) order
+// Each batch is read and updated in a single transaction, and the cursor only advances once it is committed,
+// so a job that is interrupted can be resumed from the block number of its last committed batch
+type Pager struct {
+	// Table is the blocks table whose rows are rewritten
+	Table Config
+	// BatchSize is the number of rows read, and updated, in each transaction
+	BatchSize int
+	// FromBlock and ToBlock bound the block numbers of the rows that are read, a zero ToBlock means no upper bound
+	FromBlock, ToBlock uint64
+	// Pause is the time waited between batches, to limit the load the job puts on the database
+	Pause time.Duration
+}
+
+// Validate checks the table and batch size of the pager
+func (p Pager) Validate() error {
+	if err := p.Table.Validate(); err != nil {
+		return err
+	}
+	if p.BatchSize <= 0 {
+		return fmt.Errorf("invalid batch size %d", p.BatchSize)
+	}
+	return nil
+}
+
+// Run pages through the rows until none are left or the context is cancelled
+// rewrite is called with the rows of each batch and returns the rows to update, with their new data
+// committed is called once each batch is committed, with the block number of its last row and whether all the rows
+// in the range have been read; a batch that fails is rolled back and committed is not called for it
+func (p Pager) Run(ctx context.Context, db *sqlx.DB, rewrite func([]Row) ([]Row, error), committed func(blockNumber uint64, done bool)) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	r := run{
+		db:          db,
+		pager:       p,
+		nextRows:    fmt.Sprintf(nextRowsPgStr, p.Table.TableName()),
+		updateRows:  fmt.Sprintf(updateRowsPgStr, p.Table.TableName()),
+		toBlock:     p.ToBlock,
+		cursorBlock: p.FromBlock,
+	}
+	if r.toBlock == 0 || r.toBlock > math.MaxInt64 {
+		r.toBlock = math.MaxInt64
+	}
+	for done := false; !done; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var err error
+		if done, err = r.step(ctx, rewrite); err != nil {
+			return err
+		}
+		committed(r.cursorBlock, done)
+		if p.Pause > 0 && !done {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(p.Pause):
+			}
+		}
+	}
+	return nil
+}
+
+type run struct {
+	db                   *sqlx.DB
+	pager                Pager
+	nextRows, updateRows string
+	toBlock              uint64
+
+	// the cursor is the (block_number, key) of the last row read
+	cursorBlock uint64
+	cursorKey   string
+}
+
+// step rewrites the next batch of rows in a single transaction, and reports whether it was the last
+func (r *run) step(ctx context.Context, rewrite func([]Row) ([]Row, error)) (done bool, err error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	var rows []Row
+	if err = tx.SelectContext(ctx, &rows, r.nextRows, r.cursorBlock, r.cursorKey, r.toBlock, r.pager.BatchSize); err != nil {
+		return false, err
+	}
+	updates, err := rewrite(rows)
+	if err != nil {
+		return false, err
+	}
+	if len(updates) > 0 {
+		keys := make([]string, len(updates))
+		blockNumbers := make([]int64, len(updates))
+		data := make([][]byte, len(updates))
+		for i, row := range updates {
+			keys[i], blockNumbers[i], data[i] = row.Key, int64(row.BlockNumber), row.Data
+		}
+		if _, err = tx.ExecContext(ctx, r.updateRows, pq.Array(keys), pq.Array(blockNumbers), pq.ByteaArray(data)); err != nil {
+			return false, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	if len(rows) > 0 {
+		last := rows[len(rows)-1]
+		r.cursorBlock, r.cursorKey = last.BlockNumber, last.Key
+	}
+	return len(rows) < r.pager.BatchSize, nil
+}
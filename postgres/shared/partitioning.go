@@ -0,0 +1,102 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	timescaleInstalledPgStr = "SELECT exists(SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')"
+	hypertableColumnPgStr   = `SELECT column_name FROM timescaledb_information.dimensions
+WHERE hypertable_schema = $1 AND hypertable_name = $2 ORDER BY dimension_number LIMIT 1`
+	partitionKeyPgStr = `SELECT pg_get_partkeydef(c.oid) FROM pg_partitioned_table p
+INNER JOIN pg_class c ON c.oid = p.partrelid
+INNER JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE n.nspname = $1 AND c.relname = $2`
+)
+
+// Partitioning schemes
+const (
+	NotPartitioned = "none"
+	Hypertable     = "hypertable"
+)
+
+// BlockNumberColumn is the column block number hints are applied to
+const BlockNumberColumn = "block_number"
+
+// Partitioning describes how a blocks table is partitioned
+type Partitioning struct {
+	// Scheme is NotPartitioned, Hypertable, or the declarative partitioning strategy, e.g. "range"
+	Scheme string
+	// Column is the partitioning column, empty if the table is not partitioned
+	Column string
+}
+
+// PrunesOn returns whether predicates on the column let Postgres skip partitions or chunks
+func (p Partitioning) PrunesOn(column string) bool {
+	return p.Scheme != NotPartitioned && p.Column == column
+}
+
+// String returns the scheme and column, e.g. "hypertable(block_number)"
+func (p Partitioning) String() string {
+	if p.Scheme == NotPartitioned {
+		return p.Scheme
+	}
+	return p.Scheme + "(" + p.Column + ")"
+}
+
+// DiscoverPartitioning looks up how the blocks table named by the config is partitioned
+// TimescaleDB hypertables are reported by their first dimension, and declaratively partitioned tables by their first
+// partition key column
+func DiscoverPartitioning(db *sqlx.DB, config Config) (Partitioning, error) {
+	var timescale bool
+	if err := db.Get(&timescale, timescaleInstalledPgStr); err != nil {
+		return Partitioning{}, err
+	}
+	if timescale {
+		var columns []string
+		if err := db.Select(&columns, hypertableColumnPgStr, config.Schema, config.Table); err != nil {
+			return Partitioning{}, err
+		}
+		if len(columns) > 0 {
+			return Partitioning{Scheme: Hypertable, Column: columns[0]}, nil
+		}
+	}
+	var keyDefs []string
+	if err := db.Select(&keyDefs, partitionKeyPgStr, config.Schema, config.Table); err != nil {
+		return Partitioning{}, err
+	}
+	if len(keyDefs) == 0 {
+		return Partitioning{Scheme: NotPartitioned}, nil
+	}
+	return parsePartitionKey(keyDefs[0]), nil
+}
+
+// parsePartitionKey parses the output of pg_get_partkeydef, e.g. "RANGE (block_number)"
+// Only the first column of a multi-column key is kept, as that is the one pruning depends on the most
+func parsePartitionKey(keyDef string) Partitioning {
+	strategy, columns, _ := strings.Cut(keyDef, " ")
+	columns = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(columns), "("), ")")
+	column, _, _ := strings.Cut(columns, ",")
+	return Partitioning{
+		Scheme: strings.ToLower(strategy),
+		Column: strings.Trim(strings.TrimSpace(column), `"`),
+	}
+}
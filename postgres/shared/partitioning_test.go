@@ -0,0 +1,43 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var _ = Describe("Partitioning", func() {
+	It("parses declarative partition keys", func() {
+		Expect(shared.ParsePartitionKey("RANGE (block_number)")).To(Equal(shared.Partitioning{Scheme: "range", Column: "block_number"}))
+		Expect(shared.ParsePartitionKey(`LIST ("Block_Number", key)`)).To(Equal(shared.Partitioning{Scheme: "list", Column: "Block_Number"}))
+		Expect(shared.ParsePartitionKey("HASH (key)")).To(Equal(shared.Partitioning{Scheme: "hash", Column: "key"}))
+	})
+
+	It("only prunes on the partitioning column", func() {
+		partitioning := shared.Partitioning{Scheme: shared.Hypertable, Column: shared.BlockNumberColumn}
+		Expect(partitioning.PrunesOn(shared.BlockNumberColumn)).To(BeTrue())
+		Expect(partitioning.PrunesOn("key")).To(BeFalse())
+		Expect(partitioning.String()).To(Equal("hypertable(block_number)"))
+
+		notPartitioned := shared.Partitioning{Scheme: shared.NotPartitioned}
+		Expect(notPartitioned.PrunesOn(shared.BlockNumberColumn)).To(BeFalse())
+		Expect(notPartitioned.String()).To(Equal("none"))
+	})
+})
@@ -0,0 +1,71 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	readOnlyParam  = "default_transaction_read_only"
+	readOnlyOption = "-c " + readOnlyParam + "=on"
+)
+
+// ReadOnlyConnectString returns the connection string with its sessions set to default_transaction_read_only,
+// so that Postgres rejects any write made over them
+// Both URL and key/value connection strings are supported
+func ReadOnlyConnectString(connStr string) (string, error) {
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return "", err
+		}
+		query := u.Query()
+		query.Set("options", strings.TrimSpace(query.Get("options")+" "+readOnlyOption))
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+	if strings.Contains(connStr, "options=") {
+		return "", errors.New("add " + readOnlyOption + " to the options of the connection string")
+	}
+	return strings.TrimSpace(connStr + " options='" + readOnlyOption + "'"), nil
+}
+
+// ConnectReadOnly connects to the database with read-only sessions
+func ConnectReadOnly(connStr string) (*sqlx.DB, error) {
+	readOnlyConnStr, err := ReadOnlyConnectString(connStr)
+	if err != nil {
+		return nil, err
+	}
+	return sqlx.Connect("postgres", readOnlyConnStr)
+}
+
+// NewReadOnlyPgxPool creates a pgx pool with read-only sessions
+func NewReadOnlyPgxPool(ctx context.Context, connStr string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	config.ConnConfig.RuntimeParams[readOnlyParam] = "on"
+	return pgxpool.NewWithConfig(ctx, config)
+}
@@ -0,0 +1,125 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	DefaultRetryConfig = RetryConfig{
+		MaxAttempts:    5,
+		InitialBackoff: 50 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Budget:         10 * time.Second,
+	}
+
+	// transientClasses are the SQLSTATE classes and codes that are worth retrying
+	// see https://www.postgresql.org/docs/current/errcodes-appendix.html
+	transientClasses = []string{
+		"08",    // connection_exception
+		"53",    // insufficient_resources, e.g. too_many_connections
+		"57P01", // admin_shutdown
+		"57P02", // crash_shutdown
+		"57P03", // cannot_connect_now
+		"40001", // serialization_failure
+		"40P01", // deadlock_detected
+	}
+)
+
+// RetryConfig holds the retry policy for operations that are safe to repeat
+// i.e. reads, and writes that are idempotent such as the ON CONFLICT DO NOTHING inserts
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of times an operation is tried, values below 2 disable retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, it doubles on each subsequent retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries
+	MaxBackoff time.Duration
+	// Budget caps the total time spent retrying an operation, zero means no cap
+	Budget time.Duration
+}
+
+// IsTransient returns whether the error is one that may go away if the operation is tried again
+// e.g. a dropped connection during a failover, or "too many connections"
+// lib/pq and pgx server errors are classified by their SQLSTATE code
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return isTransientCode(string(pqErr.Code))
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return isTransientCode(pgErr.Code)
+	}
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func isTransientCode(code string) bool {
+	for _, class := range transientClasses {
+		if strings.HasPrefix(code, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry runs the operation, trying it again with exponential backoff for as long as it fails with a transient error
+// and the attempts and time budget allow
+// The last error is returned if the operation never succeeds
+func (c RetryConfig) Retry(op func() error) error {
+	var deadline time.Time
+	if c.Budget > 0 {
+		deadline = time.Now().Add(c.Budget)
+	}
+	backoff := c.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= c.MaxAttempts || !IsTransient(err) {
+			return err
+		}
+		if !deadline.IsZero() && time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		log.Debugf("retrying database operation after transient error (attempt %d): %v", attempt, err)
+		time.Sleep(backoff)
+		if backoff *= 2; c.MaxBackoff > 0 && backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+var _ = Describe("Retry", func() {
+	var (
+		config = shared.RetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     2 * time.Millisecond,
+		}
+		tooManyConnections = &pq.Error{Code: "53300"}
+	)
+
+	Describe("IsTransient", func() {
+		It("classifies server errors by their SQLSTATE code", func() {
+			Expect(shared.IsTransient(tooManyConnections)).To(BeTrue())
+			Expect(shared.IsTransient(&pq.Error{Code: "57P01"})).To(BeTrue())
+			Expect(shared.IsTransient(&pgconn.PgError{Code: "08006"})).To(BeTrue())
+			Expect(shared.IsTransient(fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "40001"}))).To(BeTrue())
+
+			Expect(shared.IsTransient(&pq.Error{Code: "23505"})).To(BeFalse())
+			Expect(shared.IsTransient(&pgconn.PgError{Code: "42P01"})).To(BeFalse())
+			// writes through a read-only session fail the same way on every attempt
+			Expect(shared.IsTransient(&pq.Error{Code: "25006"})).To(BeFalse())
+		})
+
+		It("treats dropped connections as transient", func() {
+			Expect(shared.IsTransient(driver.ErrBadConn)).To(BeTrue())
+		})
+
+		It("doesn't treat other errors as transient", func() {
+			Expect(shared.IsTransient(nil)).To(BeFalse())
+			Expect(shared.IsTransient(sql.ErrNoRows)).To(BeFalse())
+			Expect(shared.IsTransient(errors.New("bad key"))).To(BeFalse())
+		})
+	})
+
+	Describe("Retry", func() {
+		It("retries transient errors until the operation succeeds", func() {
+			attempts := 0
+			err := config.Retry(func() error {
+				if attempts++; attempts < 3 {
+					return tooManyConnections
+				}
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("gives up after the maximum number of attempts", func() {
+			attempts := 0
+			err := config.Retry(func() error {
+				attempts++
+				return tooManyConnections
+			})
+			Expect(err).To(Equal(tooManyConnections))
+			Expect(attempts).To(Equal(3))
+		})
+
+		It("doesn't retry permanent errors", func() {
+			attempts := 0
+			err := config.Retry(func() error {
+				attempts++
+				return sql.ErrNoRows
+			})
+			Expect(err).To(Equal(sql.ErrNoRows))
+			Expect(attempts).To(Equal(1))
+		})
+
+		It("gives up once the time budget is spent", func() {
+			budgeted := shared.RetryConfig{
+				MaxAttempts:    100,
+				InitialBackoff: 10 * time.Millisecond,
+				Budget:         25 * time.Millisecond,
+			}
+			attempts := 0
+			err := budgeted.Retry(func() error {
+				attempts++
+				return tooManyConnections
+			})
+			Expect(err).To(Equal(tooManyConnections))
+			Expect(attempts).To(Equal(2))
+		})
+	})
+})
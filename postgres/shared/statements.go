@@ -0,0 +1,232 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	errEmptyTableName = errors.New("schema and table names must not be empty")
+
+	DefaultConfig = Config{
+		Schema: "ipld",
+		Table:  "blocks",
+		Retry:  DefaultRetryConfig,
+	}
+)
+
+// Config holds the configuration of the Postgres ethdbs
+// The table is expected to have the (key, data, block_number) layout of ipld.blocks
+type Config struct {
+	Schema string
+	Table  string
+
+	// Retry is the policy for retrying reads and idempotent writes that fail with a transient error
+	Retry RetryConfig
+
+	// DetectLeaks logs and rolls back batches that are garbage collected without being written or reset
+	// It records the stack each batch is created from, so it is intended for debugging
+	DetectLeaks bool
+
+	// DualRead makes the multihash keyed ethdbs, e.g. postgres/v1, also look up keys missing from the table in their postgres/v0 CID string form,
+	// for databases part way through a key migration
+	DualRead bool
+
+	// Compression compresses the values that are written, reads decompress values whatever the setting
+	Compression Compression
+
+	// Verify checks values against their keys when they are put and read, with the hasher of the key codec
+	// A value that doesn't hash to its key is rejected with keycodec.ErrHashMismatch
+	Verify bool
+
+	// ReadOnly makes the ethdbs return ipfsethdb.ErrReadOnly from all their writers
+	// Postgres enforces it only if the sessions are read-only too, e.g. connected with ConnectReadOnly or NewReadOnlyPgxPool
+	ReadOnly bool
+}
+
+// Validate checks that the config names a table
+func (c Config) Validate() error {
+	if c.Schema == "" || c.Table == "" {
+		return errEmptyTableName
+	}
+	return nil
+}
+
+// TableName returns the quoted, schema-qualified name of the blocks table
+func (c Config) TableName() string {
+	return pq.QuoteIdentifier(c.Schema) + "." + pq.QuoteIdentifier(c.Table)
+}
+
+// KeyMigrationsTableName returns the quoted, schema-qualified name of the table that checkpoints key migrations
+// into the blocks table, which is created by migrations.Migrate
+func (c Config) KeyMigrationsTableName() string {
+	return pq.QuoteIdentifier(c.Schema) + "." + pq.QuoteIdentifier(c.Table+"_key_migrations")
+}
+
+// Queries holds the SQL for the operations on the blocks table
+// The AtRange variants take a block number range as their last two parameters, letting Postgres prune partitions
+type Queries struct {
+	Has            string
+	Get            string
+	GetMany        string
+	HasAtRange     string
+	GetAtRange     string
+	GetManyAtRange string
+	Put            string
+	Delete         string
+}
+
+// NewQueries returns the SQL for the operations on the blocks table named by the config
+func NewQueries(config Config) Queries {
+	table := config.TableName()
+	return Queries{
+		Has:            fmt.Sprintf("SELECT exists(select 1 from %s WHERE key = $1 LIMIT 1)", table),
+		Get:            fmt.Sprintf("SELECT data FROM %s WHERE key = $1 LIMIT 1", table),
+		GetMany:        fmt.Sprintf("SELECT key, data FROM %s WHERE key = ANY($1)", table),
+		HasAtRange:     fmt.Sprintf("SELECT exists(select 1 from %s WHERE key = $1 AND block_number BETWEEN $2 AND $3 LIMIT 1)", table),
+		GetAtRange:     fmt.Sprintf("SELECT data FROM %s WHERE key = $1 AND block_number BETWEEN $2 AND $3 LIMIT 1", table),
+		GetManyAtRange: fmt.Sprintf("SELECT key, data FROM %s WHERE key = ANY($1) AND block_number BETWEEN $2 AND $3", table),
+		Put:            fmt.Sprintf("INSERT INTO %s (key, data, block_number) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", table),
+		Delete:         fmt.Sprintf("DELETE FROM %s WHERE key = $1", table),
+	}
+}
+
+// Statements holds the prepared statements for the operations on a blocks table
+// The statements are prepared on first use, and database/sql re-prepares them on each pooled connection they are used on
+// A failed preparation is retried on the next use
+// Statements can be bound to a transaction with sqlx.Tx.Stmtx
+type Statements struct {
+	db      *sqlx.DB
+	queries Queries
+
+	mu                                                                       sync.Mutex
+	has, get, getMany, hasAtRange, getAtRange, getManyAtRange, put, deleteSt *sqlx.Stmt
+}
+
+// NewStatements returns the Statements for the blocks table named by the config
+func NewStatements(db *sqlx.DB, config Config) *Statements {
+	return &Statements{
+		db:      db,
+		queries: NewQueries(config),
+	}
+}
+
+// Prepare prepares all the statements, returning the first error encountered
+func (s *Statements) Prepare() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range []struct {
+		stmt  **sqlx.Stmt
+		query string
+	}{
+		{&s.has, s.queries.Has},
+		{&s.get, s.queries.Get},
+		{&s.getMany, s.queries.GetMany},
+		{&s.hasAtRange, s.queries.HasAtRange},
+		{&s.getAtRange, s.queries.GetAtRange},
+		{&s.getManyAtRange, s.queries.GetManyAtRange},
+		{&s.put, s.queries.Put},
+		{&s.deleteSt, s.queries.Delete},
+	} {
+		if _, err := s.prepare(p.stmt, p.query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Has returns the prepared statement checking for a key
+func (s *Statements) Has() (*sqlx.Stmt, error) {
+	return s.lockedPrepare(&s.has, s.queries.Has)
+}
+
+// Get returns the prepared statement selecting the data for a key
+func (s *Statements) Get() (*sqlx.Stmt, error) {
+	return s.lockedPrepare(&s.get, s.queries.Get)
+}
+
+// GetMany returns the prepared statement selecting the keys and data for an array of keys
+func (s *Statements) GetMany() (*sqlx.Stmt, error) {
+	return s.lockedPrepare(&s.getMany, s.queries.GetMany)
+}
+
+// HasAtRange returns the prepared statement checking for a key within a block number range
+func (s *Statements) HasAtRange() (*sqlx.Stmt, error) {
+	return s.lockedPrepare(&s.hasAtRange, s.queries.HasAtRange)
+}
+
+// GetAtRange returns the prepared statement selecting the data for a key within a block number range
+func (s *Statements) GetAtRange() (*sqlx.Stmt, error) {
+	return s.lockedPrepare(&s.getAtRange, s.queries.GetAtRange)
+}
+
+// GetManyAtRange returns the prepared statement selecting the keys and data for an array of keys within a block number range
+func (s *Statements) GetManyAtRange() (*sqlx.Stmt, error) {
+	return s.lockedPrepare(&s.getManyAtRange, s.queries.GetManyAtRange)
+}
+
+// Put returns the prepared statement inserting a key, data and block number
+func (s *Statements) Put() (*sqlx.Stmt, error) {
+	return s.lockedPrepare(&s.put, s.queries.Put)
+}
+
+// Delete returns the prepared statement deleting a key
+func (s *Statements) Delete() (*sqlx.Stmt, error) {
+	return s.lockedPrepare(&s.deleteSt, s.queries.Delete)
+}
+
+// Close closes any prepared statements
+// The statements are prepared again if they are used after Close
+func (s *Statements) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for _, stmt := range []**sqlx.Stmt{&s.has, &s.get, &s.getMany, &s.hasAtRange, &s.getAtRange, &s.getManyAtRange, &s.put, &s.deleteSt} {
+		if *stmt == nil {
+			continue
+		}
+		if err := (*stmt).Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		*stmt = nil
+	}
+	return firstErr
+}
+
+func (s *Statements) lockedPrepare(stmt **sqlx.Stmt, query string) (*sqlx.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prepare(stmt, query)
+}
+
+func (s *Statements) prepare(stmt **sqlx.Stmt, query string) (*sqlx.Stmt, error) {
+	if *stmt != nil {
+		return *stmt, nil
+	}
+	prepared, err := s.db.Preparex(query)
+	if err != nil {
+		return nil, err
+	}
+	*stmt = prepared
+	return prepared, nil
+}
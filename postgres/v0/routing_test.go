@@ -0,0 +1,142 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgipfsethdb_test
+
+import (
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ipfs/go-cid"
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v0"
+)
+
+var _ = Describe("Router", func() {
+	var (
+		pgipfsDB     *pgipfsethdb.Database
+		errIndex     = errors.New("index failure")
+		testTxCID, _ = pgipfsethdb.CIDFromKeccak256(testEthKey, cid.EthTx)
+		indexRows    = func() (rows []struct {
+			CID      string `db:"cid"`
+			HeaderID string `db:"header_id"`
+		}) {
+			Expect(db.Select(&rows, "SELECT cid, header_id FROM ipld.test_index ORDER BY header_id")).To(Succeed())
+			return rows
+		}
+		testIndexer = pgipfsethdb.IndexerFunc(func(tx *sqlx.Tx, block pgipfsethdb.IndexedBlock) error {
+			if block.HeaderID == "fail" {
+				return errIndex
+			}
+			_, err := tx.Exec("INSERT INTO ipld.test_index (cid, header_id, block_number) VALUES ($1, $2, $3)",
+				block.CID.String(), block.HeaderID, block.BlockNumber)
+			return err
+		})
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = db.Exec("CREATE TABLE ipld.test_index (cid TEXT, header_id TEXT, block_number BIGINT)")
+		Expect(err).ToNot(HaveOccurred())
+
+		database = pgipfsethdb.NewDatabase(db, pgipfsethdb.CacheConfig{
+			Name:           "db",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		})
+		pgipfsDB = database.(*pgipfsethdb.Database)
+		pgipfsDB.BlockNumber = testBlockNumber
+		pgipfsDB.Router = pgipfsethdb.Router{cid.EthBlock: testIndexer}
+	})
+	AfterEach(func() {
+		groupcache.DeregisterGroup("db")
+		_, err = db.Exec("DROP TABLE ipld.test_index")
+		Expect(err).ToNot(HaveOccurred())
+		err = shared.ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		err = db.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("indexes the blocks with a routed codec", func() {
+		pgipfsDB.Index.HeaderID = "header"
+		Expect(database.Put(testCID.Bytes(), testValue)).To(Succeed())
+		Expect(database.Put(testTxCID.Bytes(), testValue)).To(Succeed())
+
+		rows := indexRows()
+		Expect(rows).To(HaveLen(1))
+		Expect(rows[0].CID).To(Equal(testCID.String()))
+		Expect(rows[0].HeaderID).To(Equal("header"))
+		has, err := database.Has(testTxCID.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+	})
+
+	It("doesn't write the block if it can't be indexed", func() {
+		pgipfsDB.Index.HeaderID = "fail"
+		Expect(database.Put(testCID.Bytes(), testValue)).To(MatchError(errIndex))
+
+		has, err := database.Has(testCID.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+	})
+
+	It("indexes batched blocks with the index context at the time they are put", func() {
+		otherHeader := types.Header{Number: big.NewInt(1)}
+		otherValue, err := rlp.EncodeToBytes(&otherHeader)
+		Expect(err).ToNot(HaveOccurred())
+		otherCID, err := pgipfsethdb.CIDFromKeccak256(otherHeader.Hash().Bytes(), cid.EthBlock)
+		Expect(err).ToNot(HaveOccurred())
+
+		batch := database.NewBatch()
+		pgipfsDB.Index.HeaderID = "a"
+		Expect(batch.Put(testCID.Bytes(), testValue)).To(Succeed())
+		pgipfsDB.Index.HeaderID = "b"
+		Expect(batch.Put(otherCID.Bytes(), otherValue)).To(Succeed())
+		Expect(indexRows()).To(BeEmpty())
+		Expect(batch.Write()).To(Succeed())
+
+		rows := indexRows()
+		Expect(rows).To(HaveLen(2))
+		Expect(rows[0].CID).To(Equal(testCID.String()))
+		Expect(rows[1].CID).To(Equal(otherCID.String()))
+	})
+
+	It("indexes headers in eth.header_cids with the default router", func() {
+		pgipfsDB.Router = pgipfsethdb.DefaultRouter()
+		pgipfsDB.Index = pgipfsethdb.IndexContext{
+			TotalDifficulty: big.NewInt(1),
+			Reward:          big.NewInt(2),
+			NodeIDs:         []string{"node"},
+		}
+		Expect(database.Put(testCID.Bytes(), testValue)).To(Succeed())
+		defer db.Exec("DELETE FROM eth.header_cids WHERE block_number = $1", testBlockNumber.Uint64())
+
+		var indexedCID string
+		err = db.Get(&indexedCID, "SELECT cid FROM eth.header_cids WHERE block_hash = $1", testHeader.Hash().Hex())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(indexedCID).To(Equal(testCID.String()))
+	})
+})
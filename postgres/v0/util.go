@@ -19,14 +19,11 @@ package pgipfsethdb
 import (
 	"github.com/ipfs/go-cid"
 	_ "github.com/lib/pq" //postgres driver
-	"github.com/multiformats/go-multihash"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
 )
 
 // CIDFromKeccak256 converts keccak256 hash bytes into a v1 cid
 func CIDFromKeccak256(hash []byte, codecType uint64) (cid.Cid, error) {
-	mh, err := multihash.Encode(hash, multihash.KECCAK_256)
-	if err != nil {
-		return cid.Cid{}, err
-	}
-	return cid.NewCidV1(codecType, mh), nil
+	return keycodec.Multihash{Codec: codecType}.CID(hash)
 }
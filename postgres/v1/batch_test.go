@@ -17,7 +17,9 @@
 package pgipfsethdb_test
 
 import (
+	"database/sql"
 	"math/big"
+	"runtime"
 	"time"
 
 	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
@@ -29,6 +31,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/cerc-io/ipfs-ethdb/v5/internal/ethdbtest"
 	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
 )
 
@@ -64,72 +67,75 @@ var _ = Describe("Batch", func() {
 		Expect(err).ToNot(HaveOccurred())
 	})
 
-	Describe("Put/Write", func() {
-		It("adds the key-value pair to the batch", func() {
-			_, err = database.Get(testEthKey)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
-			_, err = database.Get(testEthKey2)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
-
-			err = batch.Put(testEthKey, testValue)
-			Expect(err).ToNot(HaveOccurred())
-			err = batch.Put(testEthKey2, testValue2)
-			Expect(err).ToNot(HaveOccurred())
-			err = batch.Write()
-			Expect(err).ToNot(HaveOccurred())
+	ethdbtest.DescribeBatch(func() ethdbtest.Store {
+		return ethdbtest.Store{Database: database, Insert: insert, InUse: func() int { return db.Stats().InUse }}
+	})
 
-			val, err := database.Get(testEthKey)
-			Expect(err).ToNot(HaveOccurred())
-			Expect(val).To(Equal(testValue))
-			val2, err := database.Get(testEthKey2)
-			Expect(err).ToNot(HaveOccurred())
-			Expect(val2).To(Equal(testValue2))
-		})
+	It("fails writes without a block number", func() {
+		database.(*pgipfsethdb.Database).BlockNumber = nil
+		batch = database.NewBatch()
+		Expect(batch.Put(testEthKey, testValue)).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+		Expect(batch.Write()).To(Succeed())
+		Expect(db.Stats().InUse).To(Equal(0))
 	})
 
-	Describe("Delete/Reset/Write", func() {
-		It("deletes the key-value pair in the batch", func() {
+	Describe("transaction lifecycle", func() {
+		It("only holds a connection from the first operation until the batch is written", func() {
+			Expect(db.Stats().InUse).To(Equal(0))
 			err = batch.Put(testEthKey, testValue)
 			Expect(err).ToNot(HaveOccurred())
-			err = batch.Put(testEthKey2, testValue2)
-			Expect(err).ToNot(HaveOccurred())
+			Expect(db.Stats().InUse).To(Equal(1))
 			err = batch.Write()
 			Expect(err).ToNot(HaveOccurred())
+			Expect(db.Stats().InUse).To(Equal(0))
+		})
 
-			batch.Reset()
-			err = batch.Delete(testEthKey)
-			Expect(err).ToNot(HaveOccurred())
-			err = batch.Delete(testEthKey2)
+		It("commits a transaction provided to NewBatch when the batch is written", func() {
+			tx, err := db.Beginx()
 			Expect(err).ToNot(HaveOccurred())
-			err = batch.Write()
+			provided := pgipfsethdb.NewBatch(db, tx, testBlockNumber)
+			Expect(provided.Put(testEthKey, testValue)).To(Succeed())
+			Expect(provided.Write()).To(Succeed())
+			Expect(tx.Commit()).To(MatchError(sql.ErrTxDone))
+			has, err := database.Has(testEthKey)
 			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeTrue())
 
-			_, err = database.Get(testEthKey)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
-			_, err = database.Get(testEthKey2)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+			// the batch begins its own transaction once the provided one is committed
+			provided.Reset()
+			Expect(provided.Put(testEthKey2, testValue2)).To(Succeed())
+			Expect(provided.Write()).To(Succeed())
+			has, err = database.Has(testEthKey2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeTrue())
 		})
-	})
 
-	Describe("ValueSize/Reset", func() {
-		It("returns the size of data in the batch queued for write", func() {
-			err = batch.Put(testEthKey, testValue)
+		It("rolls back batches that are garbage collected without being written, when leak detection is enabled", func() {
+			config := pgipfsethdb.DefaultConfig
+			config.DetectLeaks = true
+			leaky, err := pgipfsethdb.NewBatchWithConfig(db, nil, testBlockNumber, config)
 			Expect(err).ToNot(HaveOccurred())
-			err = batch.Put(testEthKey2, testValue2)
+			err = leaky.Put(testEthKey, testValue)
 			Expect(err).ToNot(HaveOccurred())
-			err = batch.Write()
+			Expect(db.Stats().InUse).To(Equal(1))
+			leaky = nil
+
+			Eventually(func() int {
+				runtime.GC()
+				return db.Stats().InUse
+			}).Should(Equal(0))
+			has, err := database.Has(testEthKey)
 			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeFalse())
+		})
 
-			size := batch.ValueSize()
-			Expect(size).To(Equal(len(testValue) + len(testValue2)))
-
-			batch.Reset()
-			size = batch.ValueSize()
-			Expect(size).To(Equal(0))
+		It("returns an error from NewBatchWithConfig if the table doesn't exist", func() {
+			_, err = pgipfsethdb.NewBatchWithConfig(db, nil, testBlockNumber, pgipfsethdb.Config{
+				Schema: "ipld",
+				Table:  "missing_blocks",
+			})
+			Expect(err).To(HaveOccurred())
 		})
 	})
+
 })
@@ -0,0 +1,162 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgipfsethdb_test
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var _ = Describe("Blockstore", func() {
+	var (
+		ctx       = context.Background()
+		pgDB      *pgipfsethdb.Database
+		bs        *pgipfsethdb.Blockstore
+		header    = types.Header{Number: testBlockNumber}
+		value, _  = rlp.EncodeToBytes(&header)
+		ethKey    = header.Hash().Bytes()
+		header2   = types.Header{Number: testBlockNumber, Extra: []byte("blockstore")}
+		value2, _ = rlp.EncodeToBytes(&header2)
+		ethKey2   = header2.Hash().Bytes()
+		c, _      = ipfsethdb.DefaultKeyCodec.CID(ethKey)
+		c2, _     = ipfsethdb.DefaultKeyCodec.CID(ethKey2)
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		pgDB = pgipfsethdb.NewDatabase(db, pgipfsethdb.CacheConfig{
+			Name:           "blockstore",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		}).(*pgipfsethdb.Database)
+		pgDB.BlockNumber = testBlockNumber
+		bs, err = pgDB.Blockstore()
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		groupcache.DeregisterGroup("blockstore")
+		err = shared.ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		err = db.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("serves the blocks written through the ethdb, whatever the CID codec", func() {
+		Expect(pgDB.Put(ethKey, value)).To(Succeed())
+		Expect(bs.Has(ctx, c)).To(BeTrue())
+		block, err := bs.Get(ctx, c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(block.RawData()).To(Equal(value))
+		Expect(block.Cid()).To(Equal(c))
+		Expect(bs.GetSize(ctx, cid.NewCidV1(cid.Raw, c.Hash()))).To(Equal(len(value)))
+	})
+
+	It("writes blocks the ethdb can read", func() {
+		block, err := blocks.NewBlockWithCid(value, c)
+		Expect(err).ToNot(HaveOccurred())
+		block2, err := blocks.NewBlockWithCid(value2, c2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bs.PutMany(ctx, []blocks.Block{block, block2})).To(Succeed())
+		Expect(pgDB.Get(ethKey)).To(Equal(value))
+		Expect(pgDB.Get(ethKey2)).To(Equal(value2))
+
+		Expect(bs.DeleteBlock(ctx, c)).To(Succeed())
+		Expect(pgDB.Has(ethKey)).To(BeFalse())
+	})
+
+	It("backs the blockservice of an ipfsethdb.Database", func() {
+		Expect(pgDB.Put(ethKey, value)).To(Succeed())
+		kvs := ipfsethdb.NewDatabase(blockservice.New(bs, nil))
+		Expect(kvs.Get(ethKey)).To(Equal(value))
+		Expect(kvs.Put(ethKey2, value2)).To(Succeed())
+		Expect(pgDB.Get(ethKey2)).To(Equal(value2))
+	})
+
+	It("reports missing blocks with ipld.ErrNotFound", func() {
+		_, err := bs.Get(ctx, c)
+		Expect(ipld.IsNotFound(err)).To(BeTrue())
+		_, err = bs.GetSize(ctx, c)
+		Expect(ipld.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("lists the keys as raw CIDs", func() {
+		Expect(pgDB.Put(ethKey, value)).To(Succeed())
+		Expect(pgDB.Put(ethKey2, value2)).To(Succeed())
+		// a key stored at another block number is listed once
+		pgDB.BlockNumber = big.NewInt(testBlockNumber.Int64() + 1)
+		Expect(pgDB.Put(ethKey, value)).To(Succeed())
+		keys, err := bs.AllKeysChan(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		var listed []cid.Cid
+		for k := range keys {
+			listed = append(listed, k)
+		}
+		Expect(listed).To(ConsistOf(cid.NewCidV1(cid.Raw, c.Hash()), cid.NewCidV1(cid.Raw, c2.Hash())))
+	})
+
+	It("writes at the block number the database had when the blockstore was created", func() {
+		pgDB.BlockNumber = big.NewInt(testBlockNumber.Int64() + 1)
+		block, err := blocks.NewBlockWithCid(value, c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bs.Put(ctx, block)).To(Succeed())
+		var blockNumber uint64
+		Expect(db.Get(&blockNumber, "SELECT block_number FROM ipld.blocks")).To(Succeed())
+		Expect(blockNumber).To(Equal(testBlockNumber.Uint64()))
+	})
+
+	It("fails writes without a block number", func() {
+		pgDB.BlockNumber = nil
+		bs, err := pgDB.Blockstore()
+		Expect(err).ToNot(HaveOccurred())
+		block, err := blocks.NewBlockWithCid(value, c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bs.Put(ctx, block)).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+		Expect(bs.PutMany(ctx, []blocks.Block{block})).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+		Expect(pgDB.Put(ethKey, value)).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+	})
+
+	It("checks blocks against their CIDs on read when HashOnRead is enabled", func() {
+		mhKey, err := pgipfsethdb.MultihashKeyFromKeccak256(ethKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = db.Exec("INSERT into ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", mhKey, value2, testBlockNumber.Uint64())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bs.Get(ctx, c)).ToNot(BeNil())
+
+		groupcache.DeregisterGroup("blockstore")
+		pgDB.InitCache(pgipfsethdb.CacheConfig{Name: "blockstore", Size: 3000000, ExpiryDuration: time.Hour})
+		bs.HashOnRead(true)
+		_, err = bs.Get(ctx, c)
+		Expect(err).To(MatchError(blockstore.ErrHashMismatch))
+	})
+})
@@ -30,6 +30,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/cerc-io/ipfs-ethdb/v5/internal/ethdbtest"
 	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
 )
 
@@ -44,6 +45,16 @@ var (
 	testMhKey, _    = pgipfsethdb.MultihashKeyFromKeccak256(testEthKey)
 )
 
+// insert writes a row to ipld.blocks, bypassing the ethdb
+func insert(key, value []byte) error {
+	mhKey, err := pgipfsethdb.MultihashKeyFromKeccak256(key)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT into ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", mhKey, value, testBlockNumber.Uint64())
+	return err
+}
+
 var _ = Describe("Database", func() {
 	BeforeEach(func() {
 		db, err = shared.TestDB()
@@ -69,29 +80,18 @@ var _ = Describe("Database", func() {
 		Expect(err).ToNot(HaveOccurred())
 	})
 
-	Describe("Has", func() {
-		It("returns false if a key-pair doesn't exist in the db", func() {
-			has, err := database.Has(testEthKey)
-			Expect(err).ToNot(HaveOccurred())
-			Expect(has).ToNot(BeTrue())
-		})
-		It("returns true if a key-pair exists in the db", func() {
+	ethdbtest.DescribeDatabase(func() ethdbtest.Store {
+		return ethdbtest.Store{Database: database, Insert: insert}
+	})
+
+	Describe("GetMany", func() {
+		It("adds the values it finds to the cache", func() {
 			_, err = db.Exec("INSERT into ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", testMhKey, testValue, testBlockNumber.Uint64())
 			Expect(err).ToNot(HaveOccurred())
-			has, err := database.Has(testEthKey)
+			_, err = database.(*pgipfsethdb.Database).GetMany([][]byte{testEthKey})
 			Expect(err).ToNot(HaveOccurred())
-			Expect(has).To(BeTrue())
-		})
-	})
 
-	Describe("Get", func() {
-		It("throws an err if the key-pair doesn't exist in the db", func() {
-			_, err = database.Get(testEthKey)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
-		})
-		It("returns the value associated with the key, if the pair exists", func() {
-			_, err = db.Exec("INSERT into ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", testMhKey, testValue, testBlockNumber.Uint64())
+			_, err = db.Exec("DELETE FROM ipld.blocks WHERE key = $1", testMhKey)
 			Expect(err).ToNot(HaveOccurred())
 			val, err := database.Get(testEthKey)
 			Expect(err).ToNot(HaveOccurred())
@@ -99,33 +99,44 @@ var _ = Describe("Database", func() {
 		})
 	})
 
-	Describe("Put", func() {
-		It("persists the key-value pair in the database", func() {
-			_, err = database.Get(testEthKey)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
-
-			err = database.Put(testEthKey, testValue)
+	Describe("NewDatabaseWithConfig", func() {
+		BeforeEach(func() {
+			groupcache.DeregisterGroup("db")
+			_, err = db.Exec("CREATE TABLE IF NOT EXISTS ipld.alt_blocks (LIKE ipld.blocks INCLUDING ALL)")
 			Expect(err).ToNot(HaveOccurred())
-			val, err := database.Get(testEthKey)
+		})
+		AfterEach(func() {
+			_, err = db.Exec("DROP TABLE ipld.alt_blocks")
 			Expect(err).ToNot(HaveOccurred())
-			Expect(val).To(Equal(testValue))
 		})
-	})
 
-	Describe("Delete", func() {
-		It("removes the key-value pair from the database", func() {
-			err = database.Put(testEthKey, testValue)
+		It("reads and writes the configured table", func() {
+			altDatabase, err := pgipfsethdb.NewDatabaseWithConfig(db, pgipfsethdb.Config{Schema: "ipld", Table: "alt_blocks"}, pgipfsethdb.DefaultCacheConfig)
 			Expect(err).ToNot(HaveOccurred())
-			val, err := database.Get(testEthKey)
+			altDatabase.(*pgipfsethdb.Database).BlockNumber = testBlockNumber
+
+			err = altDatabase.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			val, err := altDatabase.Get(testEthKey)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(val).To(Equal(testValue))
 
-			err = database.Delete(testEthKey)
+			var count int
+			err = db.Get(&count, "SELECT count(*) FROM ipld.alt_blocks WHERE key = $1", testMhKey)
 			Expect(err).ToNot(HaveOccurred())
-			_, err = database.Get(testEthKey)
+			Expect(count).To(Equal(1))
+			has, err := database.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeFalse())
+		})
+		It("fails if the configured table doesn't exist", func() {
+			_, err = pgipfsethdb.NewDatabaseWithConfig(db, pgipfsethdb.Config{Schema: "ipld", Table: "missing_blocks"}, pgipfsethdb.DefaultCacheConfig)
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails if the config doesn't name a table", func() {
+			_, err = pgipfsethdb.NewDatabaseWithConfig(db, pgipfsethdb.Config{}, pgipfsethdb.DefaultCacheConfig)
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
 		})
 	})
+
 })
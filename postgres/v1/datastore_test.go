@@ -0,0 +1,217 @@
+// VulcanizeDB
+// Copyright © 2019 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgipfsethdb_test
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var _ = Describe("Datastore", func() {
+	var (
+		ctx       = context.Background()
+		pgDB      *pgipfsethdb.Database
+		ds        *pgipfsethdb.Datastore
+		header    = types.Header{Number: testBlockNumber}
+		value, _  = rlp.EncodeToBytes(&header)
+		ethKey    = header.Hash().Bytes()
+		mhKey, _  = pgipfsethdb.MultihashKeyFromKeccak256(ethKey)
+		header2   = types.Header{Number: testBlockNumber, Extra: []byte("datastore")}
+		value2, _ = rlp.EncodeToBytes(&header2)
+		ethKey2   = header2.Hash().Bytes()
+		mhKey2, _ = pgipfsethdb.MultihashKeyFromKeccak256(ethKey2)
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		pgDB = pgipfsethdb.NewDatabase(db, pgipfsethdb.CacheConfig{
+			Name:           "datastore",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		}).(*pgipfsethdb.Database)
+		pgDB.BlockNumber = testBlockNumber
+		ds = pgDB.Datastore()
+	})
+	AfterEach(func() {
+		groupcache.DeregisterGroup("datastore")
+		err = shared.ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		err = db.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("reads and writes the values of the ethdb under their multihash keys", func() {
+		Expect(pgDB.Put(ethKey, value)).To(Succeed())
+		Expect(ds.Has(ctx, datastore.NewKey(mhKey))).To(BeTrue())
+		Expect(ds.Get(ctx, datastore.NewKey(mhKey))).To(Equal(value))
+		Expect(ds.GetSize(ctx, datastore.NewKey(mhKey))).To(Equal(len(value)))
+
+		Expect(ds.Put(ctx, datastore.NewKey(mhKey2), value2)).To(Succeed())
+		Expect(pgDB.Get(ethKey2)).To(Equal(value2))
+
+		Expect(ds.Delete(ctx, datastore.NewKey(mhKey))).To(Succeed())
+		Expect(pgDB.Has(ethKey)).To(BeFalse())
+		_, err := ds.Get(ctx, datastore.NewKey(mhKey))
+		Expect(err).To(MatchError(datastore.ErrNotFound))
+		_, err = ds.GetSize(ctx, datastore.NewKey(mhKey))
+		Expect(err).To(MatchError(datastore.ErrNotFound))
+	})
+
+	It("overwrites the values of keys, whatever block number they were written at", func() {
+		key := datastore.NewKey("/blocks_/foo")
+		Expect(ds.Put(ctx, key, []byte("foo"))).To(Succeed())
+		Expect(ds.Get(ctx, key)).To(Equal([]byte("foo")))
+		pgDB.BlockNumber = big.NewInt(testBlockNumber.Int64() + 1)
+		ds2 := pgDB.Datastore()
+		Expect(ds2.Put(ctx, key, []byte("bar"))).To(Succeed())
+		Expect(ds.Get(ctx, key)).To(Equal([]byte("bar")))
+
+		batch, err := ds.Batch(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batch.Put(ctx, key, []byte("baz"))).To(Succeed())
+		Expect(batch.Commit(ctx)).To(Succeed())
+		Expect(ds2.Get(ctx, key)).To(Equal([]byte("baz")))
+
+		var rows []uint64
+		Expect(db.Select(&rows, "SELECT block_number FROM ipld.blocks WHERE key = $1", key.String())).To(Succeed())
+		Expect(rows).To(Equal([]uint64{testBlockNumber.Uint64()}))
+	})
+
+	It("fails writes without a block number", func() {
+		pgDB.BlockNumber = nil
+		ds := pgDB.Datastore()
+		Expect(ds.Put(ctx, datastore.NewKey(mhKey), value)).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+		batch, err := ds.Batch(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batch.Put(ctx, datastore.NewKey(mhKey), value)).To(Succeed())
+		Expect(batch.Commit(ctx)).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+		Expect(ds.Delete(ctx, datastore.NewKey(mhKey))).To(Succeed())
+	})
+
+	It("commits batches in a transaction", func() {
+		Expect(pgDB.Put(ethKey, value)).To(Succeed())
+		Expect(ds.Get(ctx, datastore.NewKey(mhKey))).To(Equal(value))
+		batch, err := ds.Batch(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(batch.Put(ctx, datastore.NewKey(mhKey2), value2)).To(Succeed())
+		Expect(batch.Delete(ctx, datastore.NewKey(mhKey))).To(Succeed())
+		Expect(ds.Has(ctx, datastore.NewKey(mhKey2))).To(BeFalse())
+
+		Expect(batch.Commit(ctx)).To(Succeed())
+		Expect(ds.Get(ctx, datastore.NewKey(mhKey2))).To(Equal(value2))
+		_, err = ds.Get(ctx, datastore.NewKey(mhKey))
+		Expect(err).To(MatchError(datastore.ErrNotFound))
+	})
+
+	Describe("Query", func() {
+		BeforeEach(func() {
+			Expect(ds.Put(ctx, datastore.NewKey(mhKey), value)).To(Succeed())
+			Expect(ds.Put(ctx, datastore.NewKey(mhKey2), value2)).To(Succeed())
+			Expect(ds.Put(ctx, datastore.NewKey("/blocksfoo/bar"), []byte("bar"))).To(Succeed())
+			Expect(ds.Put(ctx, datastore.NewKey("/blocks_/baz"), []byte("baz"))).To(Succeed())
+		})
+
+		keys := func(q query.Query) []string {
+			results, err := ds.Query(ctx, q)
+			Expect(err).ToNot(HaveOccurred())
+			entries, err := results.Rest()
+			Expect(err).ToNot(HaveOccurred())
+			var keys []string
+			for _, entry := range entries {
+				keys = append(keys, entry.Key)
+			}
+			return keys
+		}
+
+		It("matches the prefix as a namespace", func() {
+			Expect(keys(query.Query{Prefix: "/blocks"})).To(ConsistOf(mhKey, mhKey2))
+			Expect(keys(query.Query{Prefix: "blocks_/"})).To(Equal([]string{"/blocks_/baz"}))
+			Expect(keys(query.Query{})).To(HaveLen(4))
+		})
+
+		It("orders, limits and offsets the keys", func() {
+			sorted := []string{mhKey, mhKey2}
+			if sorted[1] < sorted[0] {
+				sorted[0], sorted[1] = sorted[1], sorted[0]
+			}
+			Expect(keys(query.Query{Prefix: "/blocks", Orders: []query.Order{query.OrderByKey{}}})).To(Equal(sorted))
+			Expect(keys(query.Query{Prefix: "/blocks", Orders: []query.Order{query.OrderByKey{}}, Limit: 1})).To(Equal(sorted[:1]))
+			Expect(keys(query.Query{Prefix: "/blocks", Orders: []query.Order{query.OrderByKey{}}, Offset: 1})).To(Equal(sorted[1:]))
+			Expect(keys(query.Query{Prefix: "/blocks", Orders: []query.Order{query.OrderByKeyDescending{}}, Limit: 1})).To(Equal(sorted[1:]))
+		})
+
+		It("returns a key stored at several block numbers once", func() {
+			// the ethdb writes a row per block number, rather than overwriting
+			pgDB.BlockNumber = big.NewInt(testBlockNumber.Int64() + 1)
+			Expect(pgDB.Put(ethKey, value)).To(Succeed())
+
+			sorted := []string{mhKey, mhKey2}
+			if sorted[1] < sorted[0] {
+				sorted[0], sorted[1] = sorted[1], sorted[0]
+			}
+			Expect(keys(query.Query{Prefix: "/blocks"})).To(ConsistOf(mhKey, mhKey2))
+			Expect(keys(query.Query{Prefix: "/blocks", Orders: []query.Order{query.OrderByKey{}}, Limit: 1})).To(Equal(sorted[:1]))
+			Expect(keys(query.Query{Prefix: "/blocks", Orders: []query.Order{query.OrderByKey{}}, Offset: 1})).To(Equal(sorted[1:]))
+		})
+
+		It("applies filters to the rows it reads", func() {
+			filter := query.FilterValueCompare{Op: query.Equal, Value: value2}
+			Expect(keys(query.Query{Prefix: "/blocks", Filters: []query.Filter{filter}, Limit: 1})).To(Equal([]string{mhKey2}))
+		})
+
+		It("returns values and sizes", func() {
+			results, err := ds.Query(ctx, query.Query{Prefix: "/blocks_"})
+			Expect(err).ToNot(HaveOccurred())
+			entries, err := results.Rest()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Value).To(Equal([]byte("baz")))
+			Expect(entries[0].Size).To(Equal(3))
+
+			results, err = ds.Query(ctx, query.Query{Prefix: "/blocks_", KeysOnly: true})
+			Expect(err).ToNot(HaveOccurred())
+			entries, err = results.Rest()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries[0].Value).To(BeNil())
+		})
+	})
+
+	It("backs a go-ipfs blockstore", func() {
+		Expect(pgDB.Put(ethKey, value)).To(Succeed())
+		bs := blockstore.NewBlockstore(ds)
+		c, err := ipfsethdb.DefaultKeyCodec.CID(ethKey)
+		Expect(err).ToNot(HaveOccurred())
+		block, err := bs.Get(ctx, c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(block.RawData()).To(Equal(value))
+	})
+})
@@ -0,0 +1,144 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgipfsethdb_test
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var _ = Describe("Partitioning", func() {
+	var (
+		partitionedConfig = pgipfsethdb.Config{Schema: "ipld", Table: "partitioned_blocks"}
+		plainConfig       = pgipfsethdb.Config{Schema: "ipld", Table: "plain_blocks"}
+		cacheConfig       = pgipfsethdb.CacheConfig{
+			Name:           "partitioning",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		}
+		newDatabase = func(config pgipfsethdb.Config) *pgipfsethdb.Database {
+			database, err := pgipfsethdb.NewDatabaseWithConfig(db, config, cacheConfig)
+			Expect(err).ToNot(HaveOccurred())
+			pgipfsDB := database.(*pgipfsethdb.Database)
+			pgipfsDB.BlockNumber = testBlockNumber
+			return pgipfsDB
+		}
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		for _, stmt := range []string{
+			"CREATE TABLE ipld.partitioned_blocks (LIKE ipld.blocks INCLUDING DEFAULTS) PARTITION BY RANGE (block_number)",
+			"CREATE TABLE ipld.partitioned_blocks_default PARTITION OF ipld.partitioned_blocks DEFAULT",
+			"CREATE TABLE ipld.plain_blocks (LIKE ipld.blocks INCLUDING ALL)",
+		} {
+			_, err = db.Exec(stmt)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+	AfterEach(func() {
+		groupcache.DeregisterGroup("partitioning")
+		_, err = db.Exec("DROP TABLE ipld.partitioned_blocks, ipld.plain_blocks")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("discovers the partitioning of the blocks table", func() {
+		partitioned := newDatabase(partitionedConfig)
+		partitioning, ok := partitioned.Partitioning()
+		Expect(ok).To(BeTrue())
+		Expect(partitioning).To(Equal(shared.Partitioning{Scheme: "range", Column: shared.BlockNumberColumn}))
+		stat, err := partitioned.Stat("partitioning")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stat).To(Equal("range(block_number)"))
+		groupcache.DeregisterGroup("partitioning")
+
+		plain := newDatabase(plainConfig)
+		partitioning, ok = plain.Partitioning()
+		Expect(ok).To(BeTrue())
+		Expect(partitioning.Scheme).To(Equal(shared.NotPartitioned))
+	})
+
+	It("leaves the partitioning unknown for the constructors without a Config", func() {
+		unknown := pgipfsethdb.NewDatabase(db, cacheConfig).(*pgipfsethdb.Database)
+		_, ok := unknown.Partitioning()
+		Expect(ok).To(BeFalse())
+		stat, err := unknown.Stat("partitioning")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stat).To(Equal("unknown"))
+	})
+
+	It("only finds keys within the block number hint", func() {
+		partitioned := newDatabase(partitionedConfig)
+		Expect(partitioned.Put(testEthKey, testValue)).To(Succeed())
+
+		val, err := partitioned.GetAtRange(testEthKey, testBlockNumber.Uint64(), testBlockNumber.Uint64()+10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		_, err = partitioned.GetAtRange(testEthKey, 0, testBlockNumber.Uint64()-1)
+		Expect(err).To(Equal(sql.ErrNoRows))
+
+		has, err := partitioned.HasAtRange(testEthKey, 0, testBlockNumber.Uint64())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+
+		values, err := partitioned.GetManyAtRange([][]byte{testEthKey, testEthKey2}, testBlockNumber.Uint64(), testBlockNumber.Uint64())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{testValue, nil}))
+	})
+
+	It("hints the reads of a block range view", func() {
+		partitioned := newDatabase(partitionedConfig)
+		Expect(partitioned.Put(testEthKey, testValue)).To(Succeed())
+
+		view := partitioned.WithBlockRange(testBlockNumber.Uint64()+1, testBlockNumber.Uint64()+10)
+		has, err := view.Has(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+
+		view = partitioned.WithBlockRange(0, testBlockNumber.Uint64())
+		val, err := view.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+	})
+
+	It("excludes keys outside of the range for tables that aren't partitioned by block number", func() {
+		plain := newDatabase(plainConfig)
+		Expect(plain.Put(testEthKey, testValue)).To(Succeed())
+
+		_, err := plain.GetAtRange(testEthKey, 0, testBlockNumber.Uint64()-1)
+		Expect(err).To(Equal(sql.ErrNoRows))
+		has, err := plain.HasAtRange(testEthKey, testBlockNumber.Uint64()+1, testBlockNumber.Uint64()+10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+		values, err := plain.GetManyAtRange([][]byte{testEthKey}, 0, testBlockNumber.Uint64()-1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{nil}))
+
+		val, err := plain.GetAtRange(testEthKey, 0, testBlockNumber.Uint64())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+	})
+})
@@ -0,0 +1,216 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgipfsethdb_test
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/migrations"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/pgdb"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var _ = Describe("PgxDatabase", func() {
+	var (
+		pool        *pgxpool.Pool
+		pgxDatabase ethdb.Database
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		pool, err = shared.TestPgxPool()
+		Expect(err).ToNot(HaveOccurred())
+
+		pgxDatabase = pgipfsethdb.NewPgxDatabase(pool, pgipfsethdb.DefaultCacheConfig)
+		pgxDatabase.(*pgipfsethdb.PgxDatabase).BlockNumber = testBlockNumber
+	})
+	AfterEach(func() {
+		err = shared.ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pgxDatabase.Close()).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("checks the config and discovers the partitioning, as the sqlx constructors do", func() {
+		cacheConfig := pgipfsethdb.CacheConfig{Name: "pgx_config", Size: 3000000, ExpiryDuration: time.Hour}
+		_, err := pgipfsethdb.NewPgxDatabaseWithConfig(pool, shared.Config{Schema: "ipld", Table: "missing_blocks"}, cacheConfig)
+		Expect(err).To(MatchError(migrations.ErrSchemaMissing))
+
+		database, err := pgipfsethdb.NewPgxDatabaseWithConfig(pool, pgipfsethdb.DefaultConfig, cacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+		// the pool is closed by pgxDatabase
+		defer groupcache.DeregisterGroup(cacheConfig.Name)
+		partitioning, ok := database.(*pgipfsethdb.PgxDatabase).Partitioning()
+		Expect(ok).To(BeTrue())
+		Expect(database.Stat("partitioning")).To(Equal(partitioning.String()))
+	})
+
+	Describe("Has", func() {
+		It("returns false if a key-pair doesn't exist in the db", func() {
+			has, err := pgxDatabase.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).ToNot(BeTrue())
+		})
+		It("returns true if a key-pair exists in the db", func() {
+			_, err = db.Exec("INSERT into ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", testMhKey, testValue, testBlockNumber.Uint64())
+			Expect(err).ToNot(HaveOccurred())
+			has, err := pgxDatabase.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeTrue())
+		})
+	})
+
+	Describe("Get", func() {
+		It("throws an err if the key-pair doesn't exist in the db", func() {
+			_, err = pgxDatabase.Get(testEthKey)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+		})
+		It("returns the value associated with the key, if the pair exists", func() {
+			_, err = db.Exec("INSERT into ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", testMhKey, testValue, testBlockNumber.Uint64())
+			Expect(err).ToNot(HaveOccurred())
+			val, err := pgxDatabase.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue))
+		})
+	})
+
+	Describe("GetMany", func() {
+		It("returns the values in request order, with nil for keys that don't exist in the db", func() {
+			_, err = db.Exec("INSERT into ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", testMhKey, testValue, testBlockNumber.Uint64())
+			Expect(err).ToNot(HaveOccurred())
+			missingKey := (&types.Header{Number: big.NewInt(1)}).Hash().Bytes()
+			vals, err := pgxDatabase.(*pgipfsethdb.PgxDatabase).GetMany([][]byte{missingKey, testEthKey})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vals).To(HaveLen(2))
+			Expect(vals[0]).To(BeNil())
+			Expect(vals[1]).To(Equal(testValue))
+		})
+	})
+
+	Describe("Put", func() {
+		It("persists the key-value pair in the database", func() {
+			_, err = pgxDatabase.Get(testEthKey)
+			Expect(err).To(HaveOccurred())
+
+			err = pgxDatabase.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			val, err := pgxDatabase.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue))
+		})
+	})
+
+	It("fails writes without a block number", func() {
+		pgxDatabase.(*pgipfsethdb.PgxDatabase).BlockNumber = nil
+		Expect(pgxDatabase.Put(testEthKey, testValue)).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+		pgxBatch := pgxDatabase.NewBatch()
+		Expect(pgxBatch.Put(testEthKey, testValue)).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+		Expect(pgxBatch.Delete(testEthKey)).To(Succeed())
+		Expect(pgxBatch.Write()).To(Succeed())
+	})
+
+	Describe("Delete", func() {
+		It("removes the key-value pair from the database", func() {
+			err = pgxDatabase.Put(testEthKey, testValue)
+			Expect(err).ToNot(HaveOccurred())
+			val, err := pgxDatabase.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue))
+
+			err = pgxDatabase.Delete(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = pgxDatabase.Get(testEthKey)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+		})
+	})
+
+	Describe("Batch", func() {
+		It("writes and deletes the key-value pairs in the batch", func() {
+			pgxBatch := pgxDatabase.NewBatch()
+			Expect(pgxBatch.Put(testEthKey, testValue)).To(Succeed())
+			Expect(pgxBatch.Put(testEthKey2, testValue2)).To(Succeed())
+			Expect(pgxBatch.ValueSize()).To(Equal(len(testValue) + len(testValue2)))
+			Expect(pgxBatch.Write()).To(Succeed())
+
+			val, err := pgxDatabase.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue))
+			val2, err := pgxDatabase.Get(testEthKey2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val2).To(Equal(testValue2))
+
+			pgxBatch.Reset()
+			Expect(pgxBatch.ValueSize()).To(Equal(0))
+			Expect(pgxBatch.Delete(testEthKey)).To(Succeed())
+			Expect(pgxBatch.Write()).To(Succeed())
+			has, err := pgxDatabase.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeFalse())
+		})
+		It("bulk loads large batches of puts", func() {
+			pgxBatch := pgxDatabase.NewBatch()
+			headers := make([]*types.Header, pgdb.CopyFromThreshold+1)
+			for i := range headers {
+				headers[i] = &types.Header{Number: big.NewInt(int64(i))}
+				val, _ := rlp.EncodeToBytes(headers[i])
+				Expect(pgxBatch.Put(headers[i].Hash().Bytes(), val)).To(Succeed())
+			}
+			// an existing row is left in place rather than failing the COPY
+			Expect(pgxDatabase.Put(headers[0].Hash().Bytes(), []byte{})).To(Succeed())
+			Expect(pgxBatch.Write()).To(Succeed())
+
+			var count int
+			Expect(db.Get(&count, "SELECT count(*) FROM ipld.blocks")).To(Succeed())
+			Expect(count).To(Equal(len(headers)))
+			for _, header := range headers[1:] {
+				has, err := pgxDatabase.Has(header.Hash().Bytes())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(has).To(BeTrue())
+			}
+		})
+		It("replays the batch contents", func() {
+			pgxBatch := pgxDatabase.NewBatch()
+			Expect(pgxBatch.Put(testEthKey, testValue)).To(Succeed())
+			Expect(pgxBatch.Put(testEthKey2, testValue2)).To(Succeed())
+			Expect(pgxBatch.Delete(testEthKey)).To(Succeed())
+
+			memdb := memorydb.New()
+			Expect(pgxBatch.Replay(memdb)).To(Succeed())
+			has, err := memdb.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeFalse())
+			val, err := memdb.Get(testEthKey2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(testValue2))
+		})
+	})
+})
@@ -0,0 +1,108 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgipfsethdb_test
+
+import (
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var _ = Describe("Database with replicas", func() {
+	var (
+		replica0, replica1 *sqlx.DB
+		splitDatabase      ethdb.Database
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		replica0, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		replica0.SetMaxOpenConns(2)
+		replica1, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		replica1.SetMaxOpenConns(3)
+
+		splitDatabase, err = pgipfsethdb.NewDatabaseWithReplicas(db, []*sqlx.DB{replica0, replica1}, pgipfsethdb.RoundRobin,
+			pgipfsethdb.DefaultConfig, pgipfsethdb.DefaultCacheConfig)
+		Expect(err).ToNot(HaveOccurred())
+		splitDatabase.(*pgipfsethdb.Database).BlockNumber = testBlockNumber
+	})
+	AfterEach(func() {
+		groupcache.DeregisterGroup("db")
+		err = shared.ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(splitDatabase.Close()).To(Succeed())
+	})
+
+	It("reads back what was written to the primary", func() {
+		err = splitDatabase.Put(testEthKey, testValue)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 2; i++ {
+			has, err := splitDatabase.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeTrue())
+		}
+		val, err := splitDatabase.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		vals, err := splitDatabase.(*pgipfsethdb.Database).GetMany([][]byte{testEthKey, testEthKey2})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vals).To(Equal([][]byte{testValue, nil}))
+	})
+
+	It("reports a miss only once the primary has also been checked", func() {
+		has, err := splitDatabase.Has(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+		_, err = splitDatabase.Get(testEthKey)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("sql: no rows in result set"))
+	})
+
+	It("reports per-pool stats", func() {
+		// round robin sends one read to each replica
+		for i := 0; i < 2; i++ {
+			_, err := splitDatabase.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		stat, err := splitDatabase.Stat("replica0.maxopenconnections")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stat).To(Equal("2"))
+		stat, err = splitDatabase.Stat("replica1.maxopenconnections")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stat).To(Equal("3"))
+		stat, err = splitDatabase.Stat("primary.maxopenconnections")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stat).To(Equal("0"))
+		for _, pool := range []string{"replica0", "replica1"} {
+			stat, err = splitDatabase.Stat(pool + ".openconnections")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stat).ToNot(Equal("0"))
+		}
+
+		_, err = splitDatabase.Stat("replica2.idle")
+		Expect(err).To(HaveOccurred())
+	})
+})
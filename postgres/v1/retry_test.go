@@ -0,0 +1,162 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgipfsethdb_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+// injectedFailures is the number of statement executions the faulty driver fails before passing them through to pq
+var injectedFailures int32
+
+func init() {
+	sql.Register("postgres-faulty", faultyDriver{})
+}
+
+// faultyDriver wraps the pq driver, failing statements with "too many connections" while injectedFailures is positive
+type faultyDriver struct{}
+
+func (faultyDriver) Open(name string) (driver.Conn, error) {
+	conn, err := pq.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return faultyConn{conn}, nil
+}
+
+type faultyConn struct {
+	driver.Conn
+}
+
+func (c faultyConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return faultyStmt{stmt}, nil
+}
+
+type faultyStmt struct {
+	driver.Stmt
+}
+
+func fault() error {
+	if atomic.AddInt32(&injectedFailures, -1) >= 0 {
+		return &pq.Error{Code: "53300"}
+	}
+	return nil
+}
+
+func (s faultyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := fault(); err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(args) //nolint:staticcheck
+}
+
+func (s faultyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := fault(); err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(args) //nolint:staticcheck
+}
+
+var _ = Describe("Retry", func() {
+	var (
+		faultyDB       *sqlx.DB
+		faultyDatabase ethdb.Database
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		faultyDB, err = sqlx.Connect("postgres-faulty", shared.TestConnectStr)
+		Expect(err).ToNot(HaveOccurred())
+
+		config := pgipfsethdb.DefaultConfig
+		config.Retry = shared.RetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     time.Millisecond,
+		}
+		faultyDatabase, err = pgipfsethdb.NewDatabaseWithConfig(faultyDB, config, pgipfsethdb.CacheConfig{
+			Name:           "faulty",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		faultyDatabase.(*pgipfsethdb.Database).BlockNumber = testBlockNumber
+	})
+	AfterEach(func() {
+		atomic.StoreInt32(&injectedFailures, 0)
+		groupcache.DeregisterGroup("faulty")
+		Expect(faultyDatabase.Close()).To(Succeed())
+		err = shared.ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db.Close()).To(Succeed())
+	})
+
+	It("retries puts and gets that fail with a transient error", func() {
+		atomic.StoreInt32(&injectedFailures, 2)
+		err = faultyDatabase.Put(testEthKey, testValue)
+		Expect(err).ToNot(HaveOccurred())
+
+		atomic.StoreInt32(&injectedFailures, 2)
+		val, err := faultyDatabase.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+	})
+
+	It("returns the error once the attempts are exhausted", func() {
+		atomic.StoreInt32(&injectedFailures, 3)
+		err = faultyDatabase.Put(testEthKey, testValue)
+		Expect(err).To(HaveOccurred())
+		Expect(shared.IsTransient(err)).To(BeTrue())
+
+		has, err := faultyDatabase.Has(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+	})
+
+	It("replays a batch that fails with a transient error", func() {
+		batch := faultyDatabase.NewBatch()
+		Expect(batch.Put(testEthKey, testValue)).To(Succeed())
+
+		atomic.StoreInt32(&injectedFailures, 1)
+		Expect(batch.Put(testEthKey2, testValue2)).To(Succeed())
+		Expect(batch.Write()).To(Succeed())
+
+		for key, value := range map[string][]byte{string(testEthKey): testValue, string(testEthKey2): testValue2} {
+			val, err := faultyDatabase.Get([]byte(key))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(value))
+		}
+	})
+})
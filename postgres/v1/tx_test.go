@@ -0,0 +1,187 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package pgipfsethdb_test
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/jmoiron/sqlx"
+	"github.com/mailgun/groupcache/v2"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+var _ = Describe("TxDatabase", func() {
+	var (
+		tx          *sqlx.Tx
+		txDatabase  *pgipfsethdb.TxDatabase
+		testHeader3 = types.Header{Number: big.NewInt(3)}
+		testValue3  []byte
+		testEthKey3 = testHeader3.Hash().Bytes()
+		pgipfsDB    *pgipfsethdb.Database
+		countBlocks = func() (count int) {
+			Expect(db.Get(&count, "SELECT count(*) FROM ipld.blocks")).To(Succeed())
+			return count
+		}
+	)
+
+	BeforeEach(func() {
+		db, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		testValue3, err = rlp.EncodeToBytes(testHeader3)
+		Expect(err).ToNot(HaveOccurred())
+
+		database = pgipfsethdb.NewDatabase(db, pgipfsethdb.CacheConfig{
+			Name:           "db",
+			Size:           3000000, // 3MB
+			ExpiryDuration: time.Hour,
+		})
+		pgipfsDB = database.(*pgipfsethdb.Database)
+		pgipfsDB.BlockNumber = testBlockNumber
+
+		tx, err = db.Beginx()
+		Expect(err).ToNot(HaveOccurred())
+		txDatabase = pgipfsDB.WithTx(tx)
+	})
+	AfterEach(func() {
+		tx.Rollback()
+		groupcache.DeregisterGroup("db")
+		err = shared.ResetTestDB(db)
+		Expect(err).ToNot(HaveOccurred())
+		err = db.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("reads its own writes, which are only visible to others once the caller commits", func() {
+		Expect(txDatabase.Put(testEthKey, testValue)).To(Succeed())
+		val, err := txDatabase.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		Expect(countBlocks()).To(Equal(0))
+
+		Expect(tx.Commit()).To(Succeed())
+		val, err = database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+	})
+
+	It("reads its own writes by block range and through iterators", func() {
+		Expect(txDatabase.Put(testEthKey, testValue)).To(Succeed())
+		n := testBlockNumber.Uint64()
+		has, err := txDatabase.HasAtRange(testEthKey, n, n)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+		has, err = txDatabase.HasAtRange(testEthKey, n+1, n+2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeFalse())
+		Expect(txDatabase.GetAtRange(testEthKey, n, n)).To(Equal(testValue))
+		values, err := txDatabase.GetManyAtRange([][]byte{testEthKey, testEthKey2}, n, n)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{testValue, nil}))
+
+		it := txDatabase.NewIterator(nil, testEthKey)
+		Expect(it.Value()).To(Equal(testValue))
+		Expect(it.Error()).ToNot(HaveOccurred())
+		it.Release()
+	})
+
+	It("fails writes without a block number", func() {
+		txDatabase.BlockNumber = nil
+		Expect(txDatabase.Put(testEthKey, testValue)).To(MatchError(pgipfsethdb.ErrNoBlockNumber))
+	})
+
+	It("makes the writes atomic with the caller's other statements", func() {
+		Expect(txDatabase.Put(testEthKey, testValue)).To(Succeed())
+		_, err = tx.Exec("INSERT INTO ipld.blocks (key, data, block_number) VALUES ($1, $2, $3)", "other", []byte{1}, 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(tx.Rollback()).To(Succeed())
+		Expect(countBlocks()).To(Equal(0))
+	})
+
+	It("leaves committing the transaction to the caller when a batch is written", func() {
+		batch := txDatabase.NewBatch()
+		Expect(batch.Put(testEthKey, testValue)).To(Succeed())
+		Expect(batch.Write()).To(Succeed())
+		Expect(countBlocks()).To(Equal(0))
+
+		Expect(tx.Commit()).To(Succeed())
+		Expect(countBlocks()).To(Equal(1))
+	})
+
+	Describe("NewSavepointBatch", func() {
+		It("rolls back a batch without discarding the rest of the transaction", func() {
+			Expect(txDatabase.Put(testEthKey, testValue)).To(Succeed())
+
+			batch, err := txDatabase.NewSavepointBatch()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(batch.Put(testEthKey2, testValue2)).To(Succeed())
+			Expect(batch.Rollback()).To(Succeed())
+			Expect(batch.ValueSize()).To(Equal(0))
+
+			Expect(tx.Commit()).To(Succeed())
+			has, err := database.Has(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeTrue())
+			has, err = database.Has(testEthKey2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeFalse())
+		})
+
+		It("nests batches", func() {
+			outer, err := txDatabase.NewSavepointBatch()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(outer.Put(testEthKey, testValue)).To(Succeed())
+
+			inner, err := txDatabase.NewSavepointBatch()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inner.Put(testEthKey2, testValue2)).To(Succeed())
+			Expect(inner.Rollback()).To(Succeed())
+			Expect(inner.Put(testEthKey3, testValue3)).To(Succeed())
+			Expect(inner.Write()).To(Succeed())
+			Expect(inner.Rollback()).To(HaveOccurred())
+
+			Expect(outer.Write()).To(Succeed())
+			Expect(tx.Commit()).To(Succeed())
+
+			values, err := pgipfsDB.GetMany([][]byte{testEthKey, testEthKey2, testEthKey3})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal([][]byte{testValue, nil, testValue3}))
+		})
+
+		It("discards the inner batches when an outer batch is rolled back", func() {
+			outer, err := txDatabase.NewSavepointBatch()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(outer.Put(testEthKey, testValue)).To(Succeed())
+
+			inner, err := txDatabase.NewSavepointBatch()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(inner.Put(testEthKey2, testValue2)).To(Succeed())
+			Expect(inner.Write()).To(Succeed())
+
+			Expect(outer.Rollback()).To(Succeed())
+			Expect(tx.Commit()).To(Succeed())
+			Expect(countBlocks()).To(Equal(0))
+		})
+	})
+})
@@ -17,18 +17,18 @@
 package pgipfsethdb
 
 import (
-	blockstore "github.com/ipfs/go-ipfs-blockstore"
-	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/ipfs/go-cid"
 	_ "github.com/lib/pq" //postgres driver
-	"github.com/multiformats/go-multihash"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
 )
 
 // MultihashKeyFromKeccak256 converts keccak256 hash bytes into a blockstore-prefixed multihash db key string
 func MultihashKeyFromKeccak256(h []byte) (string, error) {
-	mh, err := multihash.Encode(h, multihash.KECCAK_256)
-	if err != nil {
-		return "", err
-	}
-	dbKey := dshelp.MultihashToDsKey(mh)
-	return blockstore.BlockPrefix.String() + dbKey.String(), nil
+	return KeyCodec.Key(h)
+}
+
+// MultihashKeyFromCID converts a CID, e.g. a postgres/v0 key, into a blockstore-prefixed multihash db key string
+func MultihashKeyFromCID(c cid.Cid) string {
+	return keycodec.MultihashKey(c.Hash())
 }
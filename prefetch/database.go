@@ -0,0 +1,264 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package prefetch
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	lru "github.com/hashicorp/golang-lru"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+)
+
+var (
+	errInvalidConfig = errors.New("prefetch depth, concurrency, queue size and cache size must be positive")
+
+	DefaultConfig = Config{
+		Depth:       2,
+		Concurrency: 16,
+		QueueSize:   1024,
+		CacheSize:   16384,
+	}
+)
+
+// Config holds the prefetching parameters
+type Config struct {
+	// Depth is the number of trie levels below a returned node that are fetched ahead
+	Depth int
+	// Concurrency is the number of background workers fetching nodes
+	Concurrency int
+	// QueueSize is the number of pending fetches that can be queued, further fetches are dropped until it drains
+	QueueSize int
+	// CacheSize is the number of prefetched values held in memory
+	CacheSize int
+}
+
+// Stats holds the prefetching counters
+type Stats struct {
+	Hits       uint64
+	Misses     uint64
+	Prefetched uint64
+	Dropped    uint64
+}
+
+type task struct {
+	keys  [][]byte
+	depth int
+}
+
+var _ ethdb.KeyValueStore = &KeyValueStore{}
+
+// KeyValueStore is an ethdb.KeyValueStore that wraps another ethdb.KeyValueStore and reads ahead through the state trie
+// Whenever it returns a branch or extension node it fetches the referenced children in the background,
+// so that a subsequent walk down the trie is served from memory rather than through a round trip to the underlying store
+// Use rawdb.NewDatabase to build an ethdb.Database (e.g. for trie.NewDatabase) around it
+type KeyValueStore struct {
+	ethdb.KeyValueStore
+	config Config
+	cache  *lru.Cache
+
+	tasks chan task
+	quit  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+
+	hits, misses, prefetched, dropped uint64
+}
+
+// NewKeyValueStore returns a prefetching ethdb.KeyValueStore wrapping the provided one
+func NewKeyValueStore(kvs ethdb.KeyValueStore, config Config) (*KeyValueStore, error) {
+	if config.Depth <= 0 || config.Concurrency <= 0 || config.QueueSize <= 0 || config.CacheSize <= 0 {
+		return nil, errInvalidConfig
+	}
+	cache, err := lru.New(config.CacheSize)
+	if err != nil {
+		return nil, err
+	}
+	p := &KeyValueStore{
+		KeyValueStore: kvs,
+		config:        config,
+		cache:         cache,
+		tasks:         make(chan task, config.QueueSize),
+		quit:          make(chan struct{}),
+	}
+	p.wg.Add(config.Concurrency)
+	for i := 0; i < config.Concurrency; i++ {
+		go p.work()
+	}
+	return p, nil
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the prefetch cache or the underlying store
+func (p *KeyValueStore) Has(key []byte) (bool, error) {
+	if p.cache.Contains(common.Bytes2Hex(key)) {
+		return true, nil
+	}
+	return p.KeyValueStore.Has(key)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key from the prefetch cache or the underlying store,
+// and schedules the background fetch of the children of the returned node
+func (p *KeyValueStore) Get(key []byte) ([]byte, error) {
+	if val, ok := p.cache.Get(common.Bytes2Hex(key)); ok {
+		atomic.AddUint64(&p.hits, 1)
+		p.schedule(val.([]byte), p.config.Depth)
+		return val.([]byte), nil
+	}
+	atomic.AddUint64(&p.misses, 1)
+	val, err := p.KeyValueStore.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	p.schedule(val, p.config.Depth)
+	return val, nil
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the prefetch cache and the underlying store
+func (p *KeyValueStore) Delete(key []byte) error {
+	p.cache.Remove(common.Bytes2Hex(key))
+	return p.KeyValueStore.Delete(key)
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a batch over the underlying store that evicts deleted keys from the prefetch cache when written
+func (p *KeyValueStore) NewBatch() ethdb.Batch {
+	return &batch{Batch: p.KeyValueStore.NewBatch(), cache: p.cache}
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface
+// NewBatchWithSize creates a batch over the underlying store with a pre-allocated buffer
+func (p *KeyValueStore) NewBatchWithSize(size int) ethdb.Batch {
+	return &batch{Batch: p.KeyValueStore.NewBatchWithSize(size), cache: p.cache}
+}
+
+// Stats returns the prefetching counters
+func (p *KeyValueStore) Stats() Stats {
+	return Stats{
+		Hits:       atomic.LoadUint64(&p.hits),
+		Misses:     atomic.LoadUint64(&p.misses),
+		Prefetched: atomic.LoadUint64(&p.prefetched),
+		Dropped:    atomic.LoadUint64(&p.dropped),
+	}
+}
+
+// Close satisfies the io.Closer interface
+// Close stops the background workers and closes the underlying store
+func (p *KeyValueStore) Close() error {
+	p.stop()
+	return p.KeyValueStore.Close()
+}
+
+func (p *KeyValueStore) stop() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.wg.Wait()
+	})
+}
+
+// schedule queues the fetch of the children of the given node, if there is room in the queue
+func (p *KeyValueStore) schedule(node []byte, depth int) {
+	if depth <= 0 {
+		return
+	}
+	var keys [][]byte
+	for _, hash := range ChildHashes(node) {
+		if !p.cache.Contains(common.Bytes2Hex(hash)) {
+			keys = append(keys, hash)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	select {
+	case <-p.quit:
+	case p.tasks <- task{keys: keys, depth: depth}:
+	default:
+		atomic.AddUint64(&p.dropped, uint64(len(keys)))
+	}
+}
+
+func (p *KeyValueStore) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case t := <-p.tasks:
+			p.fetch(t)
+		}
+	}
+}
+
+// fetch retrieves the task's keys from the underlying store, caches them, and schedules the next level down
+// Errors are ignored as prefetching is best effort, a failed key is simply fetched again when it is requested
+func (p *KeyValueStore) fetch(t task) {
+	values, err := ipfsethdb.GetMany(p.KeyValueStore, t.keys)
+	if err != nil {
+		return
+	}
+	for i, val := range values {
+		if val == nil {
+			continue
+		}
+		p.cache.Add(common.Bytes2Hex(t.keys[i]), val)
+		atomic.AddUint64(&p.prefetched, 1)
+		p.schedule(val, t.depth-1)
+	}
+}
+
+// batch wraps the underlying store's batch so that deletes are reflected in the prefetch cache
+type batch struct {
+	ethdb.Batch
+	cache   *lru.Cache
+	deletes [][]byte
+}
+
+// Delete satisfies the ethdb.Batch interface
+// Delete removes the key from the key-value data store
+func (b *batch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.deletes = append(b.deletes, common.CopyBytes(key))
+	return nil
+}
+
+// Write satisfies the ethdb.Batch interface
+// Write flushes any accumulated data to disk and evicts the deleted keys from the prefetch cache
+func (b *batch) Write() error {
+	if err := b.Batch.Write(); err != nil {
+		return err
+	}
+	for _, key := range b.deletes {
+		b.cache.Remove(common.Bytes2Hex(key))
+	}
+	return nil
+}
+
+// Reset satisfies the ethdb.Batch interface
+// Reset resets the batch for reuse
+func (b *batch) Reset() {
+	b.Batch.Reset()
+	b.deletes = nil
+}
@@ -0,0 +1,131 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package prefetch_test
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/trie"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/prefetch"
+)
+
+var _ = Describe("KeyValueStore", func() {
+	var (
+		memdb    *memorydb.Database
+		kvs      *prefetch.KeyValueStore
+		root     common.Hash
+		rootNode []byte
+		err      error
+	)
+
+	BeforeEach(func() {
+		memdb = memorydb.New()
+		triedb := trie.NewDatabase(rawdb.NewDatabase(memdb))
+		tr := trie.NewEmpty(triedb)
+		for i := byte(0); i < 200; i++ {
+			tr.Update(crypto.Keccak256([]byte{i}), common.LeftPadBytes([]byte{i}, 40))
+		}
+		var nodes *trie.NodeSet
+		root, nodes = tr.Commit(false)
+		Expect(triedb.Update(trie.NewWithNodeSet(nodes))).To(Succeed())
+		Expect(triedb.Commit(root, false)).To(Succeed())
+		rootNode, err = memdb.Get(root.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+
+		kvs, err = prefetch.NewKeyValueStore(memdb, prefetch.DefaultConfig)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		Expect(kvs.Close()).To(Succeed())
+	})
+
+	Describe("ChildHashes", func() {
+		It("returns the hashes referenced by a branch node", func() {
+			children := prefetch.ChildHashes(rootNode)
+			Expect(children).To(HaveLen(16))
+			for _, child := range children {
+				has, err := memdb.Has(child)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(has).To(BeTrue())
+			}
+		})
+		It("returns nothing for values that are not trie nodes", func() {
+			Expect(prefetch.ChildHashes([]byte{0x01, 0x02})).To(BeEmpty())
+		})
+	})
+
+	Describe("Get", func() {
+		It("fetches the children of a returned node in the background", func() {
+			val, err := kvs.Get(root.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(rootNode))
+
+			children := prefetch.ChildHashes(rootNode)
+			Eventually(func() uint64 { return kvs.Stats().Prefetched }).Should(BeNumerically(">=", len(children)))
+
+			// the children are now served from the prefetch cache, even once removed from the underlying store
+			for _, child := range children {
+				expected, err := memdb.Get(child)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(memdb.Delete(child)).To(Succeed())
+				val, err := kvs.Get(child)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(val).To(Equal(expected))
+			}
+			Expect(kvs.Stats().Hits).To(BeNumerically(">=", len(children)))
+		})
+	})
+
+	Describe("Delete", func() {
+		It("evicts the key from the prefetch cache", func() {
+			_, err := kvs.Get(root.Bytes())
+			Expect(err).ToNot(HaveOccurred())
+			children := prefetch.ChildHashes(rootNode)
+			Eventually(func() uint64 { return kvs.Stats().Prefetched }).Should(BeNumerically(">=", len(children)))
+
+			child := children[0]
+			Expect(memdb.Delete(child)).To(Succeed())
+			has, err := kvs.Has(child)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeTrue())
+
+			Expect(kvs.Delete(child)).To(Succeed())
+			has, err = kvs.Has(child)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(has).To(BeFalse())
+		})
+	})
+
+	Describe("trie iteration", func() {
+		It("walks the same trie as the underlying store", func() {
+			tr, err := trie.New(trie.StateTrieID(root), trie.NewDatabase(rawdb.NewDatabase(kvs)))
+			Expect(err).ToNot(HaveOccurred())
+			it := trie.NewIterator(tr.NodeIterator(nil))
+			count := 0
+			for it.Next() {
+				count++
+			}
+			Expect(it.Err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(200))
+		})
+	})
+})
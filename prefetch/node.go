@@ -0,0 +1,90 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package prefetch
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ChildHashes decodes an rlp-encoded trie node and returns the hashes of the nodes it references
+// Branch nodes reference up to 16 children and extension nodes reference one; leaf nodes reference none
+// Children small enough to be embedded in their parent are decoded in place rather than returned
+// Values that cannot be decoded as a trie node are treated as having no children
+func ChildHashes(node []byte) [][]byte {
+	var hashes [][]byte
+	collectChildHashes(node, &hashes)
+	return hashes
+}
+
+func collectChildHashes(node []byte, hashes *[][]byte) {
+	elems, _, err := rlp.SplitList(node)
+	if err != nil {
+		return
+	}
+	count, err := rlp.CountValues(elems)
+	if err != nil {
+		return
+	}
+	switch count {
+	case 17:
+		// branch node: 16 child references followed by a value
+		for i := 0; i < 16; i++ {
+			var ref []byte
+			ref, elems, err = splitRef(elems)
+			if err != nil {
+				return
+			}
+			collectRef(ref, hashes)
+		}
+	case 2:
+		// short node: a compact-encoded key followed by either a value (leaf) or a child reference (extension)
+		key, rest, err := rlp.SplitString(elems)
+		if err != nil || len(key) == 0 || key[0]>>4 >= 2 {
+			// the terminator flag in the compact key marks a leaf
+			return
+		}
+		ref, _, err := splitRef(rest)
+		if err != nil {
+			return
+		}
+		collectRef(ref, hashes)
+	}
+}
+
+// splitRef splits the next node reference off of the list content, returning its raw (still rlp-encoded) bytes
+func splitRef(elems []byte) ([]byte, []byte, error) {
+	_, _, rest, err := rlp.Split(elems)
+	if err != nil {
+		return nil, elems, err
+	}
+	return elems[:len(elems)-len(rest)], rest, nil
+}
+
+func collectRef(ref []byte, hashes *[][]byte) {
+	kind, content, _, err := rlp.Split(ref)
+	if err != nil {
+		return
+	}
+	switch {
+	case kind == rlp.String && len(content) == common.HashLength:
+		*hashes = append(*hashes, content)
+	case kind == rlp.List:
+		// embedded node
+		collectChildHashes(ref, hashes)
+	}
+}
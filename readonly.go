@@ -0,0 +1,134 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipfsethdb
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// ReadOnlyProperty is the Stat property reporting whether a database is read-only
+const ReadOnlyProperty = "readonly"
+
+// ErrReadOnly is returned by the writers of read-only databases
+var ErrReadOnly = errors.New("database is read-only")
+
+var _ ethdb.Database = &readOnlyDatabase{}
+
+// ReadOnly returns an ethdb.Database wrapping the provided one that returns ErrReadOnly from all its writers,
+// including its batches and the ancient store writers, and reports "true" for the "readonly" Stat property
+// Reads, iterators and compaction are passed through to the wrapped database
+func ReadOnly(db ethdb.Database) ethdb.Database {
+	if ro, ok := db.(*readOnlyDatabase); ok {
+		return ro
+	}
+	return &readOnlyDatabase{Database: db}
+}
+
+// readOnlyDatabase is the ethdb.Database returned by ReadOnly
+type readOnlyDatabase struct {
+	ethdb.Database
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+func (d *readOnlyDatabase) Put(key []byte, value []byte) error {
+	return ErrReadOnly
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+func (d *readOnlyDatabase) Delete(key []byte) error {
+	return ErrReadOnly
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch returns a batch that rejects all writes
+func (d *readOnlyDatabase) NewBatch() ethdb.Batch {
+	return ReadOnlyBatch{}
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface
+// NewBatchWithSize returns a batch that rejects all writes
+func (d *readOnlyDatabase) NewBatchWithSize(size int) ethdb.Batch {
+	return ReadOnlyBatch{}
+}
+
+// ModifyAncients satisfies the ethdb.AncientWriter interface
+func (d *readOnlyDatabase) ModifyAncients(f func(ethdb.AncientWriteOp) error) (int64, error) {
+	return 0, ErrReadOnly
+}
+
+// TruncateHead satisfies the ethdb.AncientWriter interface
+func (d *readOnlyDatabase) TruncateHead(n uint64) error {
+	return ErrReadOnly
+}
+
+// TruncateTail satisfies the ethdb.AncientWriter interface
+func (d *readOnlyDatabase) TruncateTail(n uint64) error {
+	return ErrReadOnly
+}
+
+// MigrateTable satisfies the ethdb.AncientWriter interface
+func (d *readOnlyDatabase) MigrateTable(s string, f func([]byte) ([]byte, error)) error {
+	return ErrReadOnly
+}
+
+// Stat satisfies the ethdb.Stater interface
+// Stat reports the read-only mode, and passes the other properties through to the wrapped database
+func (d *readOnlyDatabase) Stat(property string) (string, error) {
+	if strings.ToLower(property) == ReadOnlyProperty {
+		return strconv.FormatBool(true), nil
+	}
+	return d.Database.Stat(property)
+}
+
+var _ ethdb.Batch = ReadOnlyBatch{}
+
+// ReadOnlyBatch is the ethdb.Batch of read-only databases, it returns ErrReadOnly from its writers
+type ReadOnlyBatch struct{}
+
+// Put satisfies the ethdb.Batch interface
+func (ReadOnlyBatch) Put(key []byte, value []byte) error {
+	return ErrReadOnly
+}
+
+// Delete satisfies the ethdb.Batch interface
+func (ReadOnlyBatch) Delete(key []byte) error {
+	return ErrReadOnly
+}
+
+// ValueSize satisfies the ethdb.Batch interface
+// ValueSize is always zero, as nothing can be queued up for writing
+func (ReadOnlyBatch) ValueSize() int {
+	return 0
+}
+
+// Write satisfies the ethdb.Batch interface
+func (ReadOnlyBatch) Write() error {
+	return ErrReadOnly
+}
+
+// Reset satisfies the ethdb.Batch interface
+func (ReadOnlyBatch) Reset() {}
+
+// Replay satisfies the ethdb.Batch interface
+// Replay has nothing to replay
+func (ReadOnlyBatch) Replay(w ethdb.KeyValueWriter) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package ipfsethdb_test
+
+import (
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+)
+
+var _ = Describe("ReadOnly", func() {
+	expectReadOnly := func(db ethdb.Database) {
+		Expect(db.Put(testEthKey, testValue)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(db.Delete(testEthKey)).To(MatchError(ipfsethdb.ErrReadOnly))
+		batch := db.NewBatch()
+		Expect(batch.Put(testEthKey, testValue)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(batch.Write()).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(db.Stat(ipfsethdb.ReadOnlyProperty)).To(Equal("true"))
+
+		val, err := db.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+	}
+
+	It("rejects the writes to a read-only blockservice Database", func() {
+		blockService = ipfsethdb.NewMockBlockservice()
+		Expect(ipfsethdb.NewDatabase(blockService).Put(testEthKey, testValue)).To(Succeed())
+		Expect(ipfsethdb.NewDatabase(blockService).Stat(ipfsethdb.ReadOnlyProperty)).To(Equal("false"))
+		expectReadOnly(ipfsethdb.NewReadOnlyDatabase(blockService, ipfsethdb.DefaultKeyCodec))
+	})
+
+	It("wraps any ethdb.Database", func() {
+		memdb := rawdb.NewMemoryDatabase()
+		Expect(memdb.Put(testEthKey, testValue)).To(Succeed())
+		db := ipfsethdb.ReadOnly(memdb)
+		expectReadOnly(db)
+		_, err := db.ModifyAncients(func(ethdb.AncientWriteOp) error { return nil })
+		Expect(err).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(db.TruncateHead(0)).To(MatchError(ipfsethdb.ErrReadOnly))
+		Expect(ipfsethdb.ReadOnly(db)).To(BeIdenticalTo(db))
+	})
+})
@@ -0,0 +1,158 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sqliteethdb
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jmoiron/sqlx"
+)
+
+var _ ethdb.Batch = &Batch{}
+
+// Batch is the type that satisfies the ethdb.Batch interface for IPFS Ethereum data stored in SQLite
+// The operations are buffered until Write, which applies them in a single transaction, so that an unwritten batch
+// doesn't hold SQLite's write lock and block the writes made outside of it
+type Batch struct {
+	db        *sqlx.DB
+	config    Config
+	ops       []batchOp
+	flushed   int
+	valueSize int
+
+	blockNumber *big.Int
+}
+
+type batchOp struct {
+	key, value []byte
+	dbKey      string
+	stored     []byte // the value as it is written, i.e. compressed
+	number     uint64
+	delete     bool
+}
+
+// NewBatch returns a ethdb.Batch interface for SQLite
+func NewBatch(db *sqlx.DB, blockNumber *big.Int) ethdb.Batch {
+	return newBatch(db, blockNumber, Config{})
+}
+
+func newBatch(db *sqlx.DB, blockNumber *big.Int, config Config) *Batch {
+	return &Batch{
+		db:          db,
+		config:      config,
+		blockNumber: blockNumber,
+	}
+}
+
+// Put satisfies the ethdb.Batch interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value
+func (b *Batch) Put(key []byte, value []byte) error {
+	if b.blockNumber == nil {
+		return ErrNoBlockNumber
+	}
+	value = common.CopyBytes(value)
+	dbKey, stored, err := prepare(b.config, key, value)
+	if err != nil {
+		return err
+	}
+	b.ops = append(b.ops, batchOp{
+		key:    common.CopyBytes(key),
+		value:  value,
+		dbKey:  dbKey,
+		stored: stored,
+		number: b.blockNumber.Uint64(),
+	})
+	b.valueSize += len(value)
+	return nil
+}
+
+// Delete satisfies the ethdb.Batch interface
+// Delete removes the key from the key-value data store
+func (b *Batch) Delete(key []byte) error {
+	dbKey, err := KeyCodec.Key(key)
+	if err != nil {
+		return err
+	}
+	b.ops = append(b.ops, batchOp{key: common.CopyBytes(key), dbKey: dbKey, delete: true})
+	return nil
+}
+
+// ValueSize satisfies the ethdb.Batch interface
+// ValueSize retrieves the amount of data queued up for writing
+// The returned value is the total byte length of all data queued to write
+func (b *Batch) ValueSize() int {
+	return b.valueSize
+}
+
+// Write satisfies the ethdb.Batch interface
+// Write flushes any accumulated data to disk
+// The operations added since the last write are applied in order, in a single transaction
+func (b *Batch) Write() error {
+	if b.flushed == len(b.ops) {
+		return nil
+	}
+	tx, err := b.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, op := range b.ops[b.flushed:] {
+		if op.delete {
+			_, err = tx.Exec(deleteStr, op.dbKey)
+		} else {
+			_, err = tx.Exec(putStr, op.dbKey, op.stored, op.number)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.flushed = len(b.ops)
+	return nil
+}
+
+// Replay satisfies the ethdb.Batch interface
+// Replay replays the batch contents
+func (b *Batch) Replay(w ethdb.KeyValueWriter) error {
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = w.Delete(op.key)
+		} else {
+			err = w.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset satisfies the ethdb.Batch interface
+// Reset resets the batch for reuse
+// This should be called after every write
+// Operations that haven't been written are discarded
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+	b.flushed = 0
+	b.valueSize = 0
+}
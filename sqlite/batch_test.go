@@ -0,0 +1,69 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sqliteethdb_test
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/internal/ethdbtest"
+	sqliteethdb "github.com/cerc-io/ipfs-ethdb/v5/sqlite"
+)
+
+var (
+	batch         ethdb.Batch
+	testHeader2   = types.Header{Number: big.NewInt(2)}
+	testValue2, _ = rlp.EncodeToBytes(&testHeader2)
+	testEthKey2   = testHeader2.Hash().Bytes()
+)
+
+var _ = Describe("Batch", func() {
+	BeforeEach(func() {
+		openTestDB()
+		database = sqliteethdb.NewDatabase(db)
+		database.(*sqliteethdb.Database).BlockNumber = testBlockNumber
+		batch = database.NewBatch()
+	})
+	AfterEach(func() {
+		batch.Reset()
+		closeTestDB()
+	})
+
+	ethdbtest.DescribeBatch(func() ethdbtest.Store {
+		return ethdbtest.Store{Database: database, Insert: insert, InUse: func() int { return db.Stats().InUse }}
+	})
+
+	It("doesn't block the writes made outside of it until it is written", func() {
+		err = batch.Put(testEthKey, testValue)
+		Expect(err).ToNot(HaveOccurred())
+		done := make(chan error, 1)
+		go func() { done <- database.Put(testEthKey2, testValue2) }()
+		Eventually(done, time.Second).Should(Receive(BeNil()))
+		Expect(database.Has(testEthKey)).To(BeFalse())
+
+		err = batch.Write()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Get(testEthKey)).To(Equal(testValue))
+		Expect(db.Stats().InUse).To(Equal(0))
+	})
+})
@@ -0,0 +1,160 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sqliteethdb
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+)
+
+const (
+	// nextRowsStr pages through the rows in (block_number, key) order
+	// The statements are valid in both SQLite and Postgres, once rebound to the bindvars of the driver
+	nextRowsStr = `SELECT key, data, block_number FROM %s
+WHERE (block_number, key) > (?, ?) AND block_number <= ?
+ORDER BY block_number, key LIMIT ?`
+	insertRowStr = "INSERT INTO %s (key, data, block_number) VALUES (?, ?, ?) ON CONFLICT DO NOTHING"
+)
+
+var DefaultCopyConfig = CopyConfig{
+	Postgres:  shared.DefaultConfig,
+	BatchSize: 1000,
+}
+
+// CopyConfig holds the settings of a copy between a SQLite and a Postgres blocks table
+type CopyConfig struct {
+	// Postgres is the Postgres blocks table
+	Postgres shared.Config
+	// BatchSize is the number of rows copied in each transaction
+	BatchSize int
+	// FromBlock and ToBlock bound the block numbers of the rows that are copied, a zero ToBlock means no upper bound
+	FromBlock, ToBlock uint64
+	// Progress, if set, is called after each batch is committed
+	Progress func(CopyProgress)
+}
+
+// CopyProgress reports how far a copy has got
+type CopyProgress struct {
+	// BlockNumber is the block number of the last row copied
+	BlockNumber uint64
+	// Copied is the number of rows read from the source, rows the target already has are left as they are
+	Copied int64
+	// Done is set once there are no rows left to copy
+	Done bool
+}
+
+// ToPostgres copies the rows of the SQLite blocks table into the Postgres one, in block number order, until none are
+// left or the context is cancelled
+// Values are copied as they are stored, compressed or not, and rows the target already has are skipped, so an
+// interrupted copy can be run again from its last reported block number
+func ToPostgres(ctx context.Context, lite, pg *sqlx.DB, config CopyConfig) (CopyProgress, error) {
+	if err := config.Postgres.Validate(); err != nil {
+		return CopyProgress{}, err
+	}
+	return copyRows(ctx, lite, Table, pg, config.Postgres.TableName(), config)
+}
+
+// FromPostgres copies the rows of the Postgres blocks table into the SQLite one, in block number order, until none are
+// left or the context is cancelled
+// The SQLite blocks table is created if it doesn't exist
+func FromPostgres(ctx context.Context, pg, lite *sqlx.DB, config CopyConfig) (CopyProgress, error) {
+	if err := config.Postgres.Validate(); err != nil {
+		return CopyProgress{}, err
+	}
+	if err := CreateTable(lite); err != nil {
+		return CopyProgress{}, err
+	}
+	return copyRows(ctx, pg, config.Postgres.TableName(), lite, Table, config)
+}
+
+// copyRows copies the rows of the source table into the target table a batch at a time
+func copyRows(ctx context.Context, src *sqlx.DB, srcTable string, dst *sqlx.DB, dstTable string, config CopyConfig) (CopyProgress, error) {
+	if config.BatchSize <= 0 {
+		return CopyProgress{}, fmt.Errorf("invalid batch size %d", config.BatchSize)
+	}
+	toBlock := config.ToBlock
+	if toBlock == 0 || toBlock > math.MaxInt64 {
+		toBlock = math.MaxInt64
+	}
+	nextRows := src.Rebind(fmt.Sprintf(nextRowsStr, srcTable))
+	insertRow := dst.Rebind(fmt.Sprintf(insertRowStr, dstTable))
+
+	progress := CopyProgress{BlockNumber: config.FromBlock}
+	lastKey := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+		rows, err := nextBlocks(ctx, src, nextRows, progress.BlockNumber, lastKey, toBlock, config.BatchSize)
+		if err != nil {
+			return progress, err
+		}
+		if len(rows) == 0 {
+			progress.Done = true
+			if config.Progress != nil {
+				config.Progress(progress)
+			}
+			return progress, nil
+		}
+		if err := insertBlocks(ctx, dst, insertRow, rows); err != nil {
+			return progress, err
+		}
+		last := rows[len(rows)-1]
+		progress.BlockNumber, lastKey = last.BlockNumber, last.Key
+		progress.Copied += int64(len(rows))
+		if config.Progress != nil {
+			config.Progress(progress)
+		}
+	}
+}
+
+// block is a row of a blocks table
+type block struct {
+	Key         string `db:"key"`
+	Data        []byte `db:"data"`
+	BlockNumber uint64 `db:"block_number"`
+}
+
+func nextBlocks(ctx context.Context, db *sqlx.DB, query string, blockNumber uint64, key string, toBlock uint64, limit int) ([]block, error) {
+	var rows []block
+	return rows, db.SelectContext(ctx, &rows, query, blockNumber, key, toBlock, limit)
+}
+
+func insertBlocks(ctx context.Context, db *sqlx.DB, query string, rows []block) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PreparexContext(ctx, query)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row.Key, row.Data, row.BlockNumber); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
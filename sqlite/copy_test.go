@@ -0,0 +1,69 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sqliteethdb_test
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	sqliteethdb "github.com/cerc-io/ipfs-ethdb/v5/sqlite"
+)
+
+var _ = Describe("Copy", func() {
+	var (
+		pg     *sqlx.DB
+		config = sqliteethdb.DefaultCopyConfig
+	)
+
+	BeforeEach(func() {
+		openTestDB()
+		pg, err = shared.TestDB()
+		Expect(err).ToNot(HaveOccurred())
+		config.BatchSize = 1
+	})
+	AfterEach(func() {
+		Expect(shared.ResetTestDB(pg)).To(Succeed())
+		Expect(pg.Close()).To(Succeed())
+		closeTestDB()
+	})
+
+	It("copies the rows from SQLite to Postgres and back", func() {
+		lite := sqliteethdb.NewDatabase(db)
+		lite.(*sqliteethdb.Database).BlockNumber = testBlockNumber
+		Expect(lite.Put(testEthKey, testValue)).To(Succeed())
+		Expect(lite.Put(testEthKey2, testValue2)).To(Succeed())
+
+		progress, err := sqliteethdb.ToPostgres(context.Background(), db, pg, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress).To(Equal(sqliteethdb.CopyProgress{BlockNumber: testBlockNumber.Uint64(), Copied: 2, Done: true}))
+		var data []byte
+		Expect(pg.Get(&data, "SELECT data FROM ipld.blocks WHERE key = $1", testMhKey)).To(Succeed())
+		Expect(data).To(Equal(testValue))
+
+		// copying again leaves the rows the target already has as they are
+		Expect(lite.Delete(testEthKey)).To(Succeed())
+		progress, err = sqliteethdb.FromPostgres(context.Background(), pg, db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Copied).To(Equal(int64(2)))
+		Expect(lite.Get(testEthKey)).To(Equal(testValue))
+		Expect(lite.Get(testEthKey2)).To(Equal(testValue2))
+	})
+})
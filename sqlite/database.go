@@ -0,0 +1,405 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package sqliteethdb is the ethdb over a SQLite blocks table with the (key, data, block_number) layout and the
+// multihash keys of postgres/v1, for developer machines and CI
+package sqliteethdb
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3" //sqlite driver
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+)
+
+const (
+	// Table is the name of the SQLite blocks table
+	Table = "blocks"
+
+	createTableStr = `CREATE TABLE IF NOT EXISTS blocks (
+    block_number INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    data BLOB NOT NULL,
+    PRIMARY KEY (key, block_number)
+);
+CREATE INDEX IF NOT EXISTS blocks_block_number_index ON blocks (block_number)`
+
+	hasStr     = "SELECT exists(select 1 from blocks WHERE key = ? LIMIT 1)"
+	getStr     = "SELECT data FROM blocks WHERE key = ? LIMIT 1"
+	getManyStr = "SELECT key, data FROM blocks WHERE key IN (?)"
+	putStr     = "INSERT INTO blocks (key, data, block_number) VALUES (?, ?, ?) ON CONFLICT DO NOTHING"
+	deleteStr  = "DELETE FROM blocks WHERE key = ?"
+	sizeStr    = "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+
+	// maxVariables is the default limit on the number of parameters of a SQLite statement
+	maxVariables = 999
+)
+
+var (
+	errNotSupported = errors.New("this operation is not supported")
+
+	// ErrNoBlockNumber is returned by writes when the block number to write the blocks at is not set, as by postgres/v1
+	ErrNoBlockNumber = pgipfsethdb.ErrNoBlockNumber
+
+	// KeyCodec converts the keccak256 hash keys into multihash db keys, the same keys as postgres/v1
+	KeyCodec keycodec.KeyCodec = pgipfsethdb.KeyCodec
+)
+
+// Config holds the configuration of the SQLite ethdb
+type Config struct {
+	// Compression compresses the values that are written, reads decompress values whatever the setting
+	// Values are compressed the same way as in the Postgres ethdbs, so rows can be copied between them as they are
+	Compression shared.Compression
+
+	// Verify checks values against their keys when they are put and read
+	// A value that doesn't hash to its key is rejected with keycodec.ErrHashMismatch
+	Verify bool
+
+	// ReadOnly makes the ethdb return ipfsethdb.ErrReadOnly from all its writers
+	ReadOnly bool
+}
+
+// Open opens the SQLite database at the path, creating it and its blocks table if they don't exist
+// The database is opened in WAL mode, so it can be read while a batch is being written
+func Open(path string) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if err := CreateTable(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// CreateTable creates the blocks table and its block number index, if they don't exist
+func CreateTable(db *sqlx.DB) error {
+	_, err := db.Exec(createTableStr)
+	return err
+}
+
+var _ ethdb.Database = &Database{}
+
+// Database is the type that satisfies the ethdb.Database and ethdb.KeyValueStore interfaces for IPFS Ethereum data
+// stored in SQLite
+type Database struct {
+	db     *sqlx.DB
+	config Config
+
+	BlockNumber *big.Int
+}
+
+// NewKeyValueStore returns a ethdb.KeyValueStore interface for SQLite
+func NewKeyValueStore(db *sqlx.DB) ethdb.KeyValueStore {
+	return &Database{db: db}
+}
+
+// NewDatabase returns a ethdb.Database interface for SQLite
+func NewDatabase(db *sqlx.DB) ethdb.Database {
+	return &Database{db: db}
+}
+
+// NewDatabaseWithConfig returns a ethdb.Database interface for SQLite with the provided settings
+func NewDatabaseWithConfig(db *sqlx.DB, config Config) ethdb.Database {
+	return &Database{db: db, config: config}
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the key-value data store
+func (d *Database) Has(key []byte) (bool, error) {
+	dbKey, err := KeyCodec.Key(key)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	return exists, d.db.Get(&exists, hasStr, dbKey)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key if it's present in the key-value data store
+func (d *Database) Get(key []byte) ([]byte, error) {
+	dbKey, err := KeyCodec.Key(key)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := d.db.Get(&data, getStr, dbKey); err != nil {
+		return nil, err
+	}
+	value := shared.Decompress(data)
+	if d.config.Verify {
+		if err := keycodec.Verify(KeyCodec, key, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// GetMany retrieves the values for the given keys, with a query per 999 keys
+// The returned values are in the same order as the keys, with a nil entry for each key that is not present
+func (d *Database) GetMany(keys [][]byte) ([][]byte, error) {
+	dbKeys, err := keycodec.Keys(KeyCodec, keys)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string][]byte, len(dbKeys))
+	for start := 0; start < len(dbKeys); start += maxVariables {
+		end := start + maxVariables
+		if end > len(dbKeys) {
+			end = len(dbKeys)
+		}
+		if err := d.getMany(dbKeys[start:end], found); err != nil {
+			return nil, err
+		}
+	}
+	values := make([][]byte, len(dbKeys))
+	for i, dbKey := range dbKeys {
+		value, ok := found[dbKey]
+		if !ok {
+			continue
+		}
+		if d.config.Verify {
+			if err := keycodec.Verify(KeyCodec, keys[i], value); err != nil {
+				return nil, err
+			}
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (d *Database) getMany(dbKeys []string, found map[string][]byte) error {
+	query, args, err := sqlx.In(getManyStr, dbKeys)
+	if err != nil {
+		return err
+	}
+	rows, err := d.db.Queryx(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dbKey string
+		var data []byte
+		if err := rows.Scan(&dbKey, &data); err != nil {
+			return err
+		}
+		found[dbKey] = shared.Decompress(data)
+	}
+	return rows.Err()
+}
+
+// Put satisfies the ethdb.KeyValueWriter interface
+// Put inserts the given value into the key-value data store
+// Key is expected to be the keccak256 hash of value
+func (d *Database) Put(key []byte, value []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	if d.BlockNumber == nil {
+		return ErrNoBlockNumber
+	}
+	dbKey, stored, err := prepare(d.config, key, value)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(putStr, dbKey, stored, d.BlockNumber.Uint64())
+	return err
+}
+
+// prepare returns the db key and the value as it is stored, checking the value against the key if the config says to
+func prepare(config Config, key, value []byte) (string, []byte, error) {
+	dbKey, err := KeyCodec.Key(key)
+	if err != nil {
+		return "", nil, err
+	}
+	if config.Verify {
+		if err := keycodec.Verify(KeyCodec, key, value); err != nil {
+			return "", nil, err
+		}
+	}
+	stored, err := config.Compression.Compress(value)
+	if err != nil {
+		return "", nil, err
+	}
+	return dbKey, stored, nil
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the key-value data store
+func (d *Database) Delete(key []byte) error {
+	if d.config.ReadOnly {
+		return ipfsethdb.ErrReadOnly
+	}
+	dbKey, err := KeyCodec.Key(key)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(deleteStr, dbKey)
+	return err
+}
+
+// Stat satisfies the ethdb.Stater interface
+// Stat returns the "size" of the database file in bytes, its "openconnections", or whether it is "readonly"
+func (d *Database) Stat(property string) (string, error) {
+	switch strings.ToLower(property) {
+	case "size":
+		var byteSize string
+		return byteSize, d.db.Get(&byteSize, sizeStr)
+	case "openconnections":
+		return strconv.Itoa(d.db.Stats().OpenConnections), nil
+	case ipfsethdb.ReadOnlyProperty:
+		return strconv.FormatBool(d.config.ReadOnly), nil
+	default:
+		return "", fmt.Errorf("unknown database property")
+	}
+}
+
+// Compact satisfies the ethdb.Compacter interface
+// Compact flattens the underlying data store for the given key range
+func (d *Database) Compact(start []byte, limit []byte) error {
+	return errNotSupported
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a write-only database that buffers changes to its host db
+// until a final write is called
+func (d *Database) NewBatch() ethdb.Batch {
+	if d.config.ReadOnly {
+		return ipfsethdb.ReadOnlyBatch{}
+	}
+	return newBatch(d.db, d.BlockNumber, d.config)
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface.
+// NewBatchWithSize creates a write-only database batch with pre-allocated buffer.
+func (d *Database) NewBatchWithSize(size int) ethdb.Batch {
+	return d.NewBatch()
+}
+
+// NewIterator satisfies the ethdb.Iteratee interface
+// it creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix, starting at a particular
+// initial key (or after, if it does not exist).
+//
+// Note: This method assumes that the prefix is NOT part of the start, so there's
+// no need for the caller to prepend the prefix to the start
+func (d *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	return newIterator(start, prefix, d.db)
+}
+
+// Close satisfies the io.Closer interface
+// Close closes the db connections
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+// HasAncient satisfies the ethdb.AncientReader interface
+// HasAncient returns an indicator whether the specified data exists in the ancient store
+func (d *Database) HasAncient(kind string, number uint64) (bool, error) {
+	return false, errNotSupported
+}
+
+// Ancient satisfies the ethdb.AncientReader interface
+// Ancient retrieves an ancient binary blob from the append-only immutable files
+func (d *Database) Ancient(kind string, number uint64) ([]byte, error) {
+	return nil, errNotSupported
+}
+
+// Ancients satisfies the ethdb.AncientReader interface
+// Ancients returns the ancient item numbers in the ancient store
+func (d *Database) Ancients() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// Tail satisfies the ethdb.AncientReader interface.
+// Tail returns the number of first stored item in the freezer.
+func (d *Database) Tail() (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientSize satisfies the ethdb.AncientReader interface
+// AncientSize returns the ancient size of the specified category
+func (d *Database) AncientSize(kind string) (uint64, error) {
+	return 0, errNotSupported
+}
+
+// AncientRange retrieves all the items in a range, starting from the index 'start'.
+// It will return
+//  - at most 'count' items,
+//  - at least 1 item (even if exceeding the maxBytes), but will otherwise
+//   return as many items as fit into maxBytes.
+func (d *Database) AncientRange(kind string, start, count, maxBytes uint64) ([][]byte, error) {
+	return nil, errNotSupported
+}
+
+// ReadAncients applies the provided AncientReader function
+func (d *Database) ReadAncients(fn func(ethdb.AncientReaderOp) error) (err error) {
+	return errNotSupported
+}
+
+// ModifyAncients satisfies the ethdb.AncientWriter interface
+// ModifyAncients runs a write operation on the ancient store
+func (d *Database) ModifyAncients(f func(ethdb.AncientWriteOp) error) (int64, error) {
+	return 0, errNotSupported
+}
+
+// TruncateHead satisfies the ethdb.AncientWriter interface.
+// TruncateHead discards all but the first n ancient data from the ancient store.
+func (d *Database) TruncateHead(n uint64) error {
+	return errNotSupported
+}
+
+// TruncateTail satisfies the ethdb.AncientWriter interface.
+// TruncateTail discards the first n ancient data from the ancient store.
+func (d *Database) TruncateTail(n uint64) error {
+	return errNotSupported
+}
+
+// Sync satisfies the ethdb.AncientWriter interface
+// Sync flushes all in-memory ancient store data to disk
+func (d *Database) Sync() error {
+	return errNotSupported
+}
+
+// MigrateTable satisfies the ethdb.AncientWriter interface.
+// MigrateTable processes and migrates entries of a given table to a new format.
+func (d *Database) MigrateTable(string, func([]byte) ([]byte, error)) error {
+	return errNotSupported
+}
+
+// NewSnapshot satisfies the ethdb.Snapshotter interface.
+// NewSnapshot creates a database snapshot based on the current state.
+func (d *Database) NewSnapshot() (ethdb.Snapshot, error) {
+	return nil, errNotSupported
+}
+
+// AncientDatadir returns an error as we don't have a backing chain freezer.
+func (d *Database) AncientDatadir() (string, error) {
+	return "", errNotSupported
+}
@@ -0,0 +1,117 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package sqliteethdb_test
+
+import (
+	"bytes"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/jmoiron/sqlx"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+	"github.com/cerc-io/ipfs-ethdb/v5/internal/ethdbtest"
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
+	pgipfsethdb "github.com/cerc-io/ipfs-ethdb/v5/postgres/v1"
+	sqliteethdb "github.com/cerc-io/ipfs-ethdb/v5/sqlite"
+)
+
+var (
+	database        ethdb.Database
+	db              *sqlx.DB
+	dir             string
+	err             error
+	testBlockNumber = big.NewInt(1337)
+	testHeader      = types.Header{Number: testBlockNumber}
+	testValue, _    = rlp.EncodeToBytes(&testHeader)
+	testEthKey      = testHeader.Hash().Bytes()
+	testMhKey, _    = pgipfsethdb.MultihashKeyFromKeccak256(testEthKey)
+)
+
+// openTestDB opens a SQLite database in a new temporary directory
+func openTestDB() {
+	dir, err = os.MkdirTemp("", "sqliteethdb")
+	Expect(err).ToNot(HaveOccurred())
+	db, err = sqliteethdb.Open(filepath.Join(dir, "blocks.db"))
+	Expect(err).ToNot(HaveOccurred())
+}
+
+// insert writes a row to the blocks table, bypassing the ethdb
+func insert(key, value []byte) error {
+	mhKey, err := pgipfsethdb.MultihashKeyFromKeccak256(key)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT into blocks (key, data, block_number) VALUES (?, ?, ?)", mhKey, value, testBlockNumber.Uint64())
+	return err
+}
+
+func closeTestDB() {
+	Expect(db.Close()).To(Succeed())
+	Expect(os.RemoveAll(dir)).To(Succeed())
+}
+
+var _ = Describe("Database", func() {
+	BeforeEach(func() {
+		openTestDB()
+		database = sqliteethdb.NewDatabase(db)
+		database.(*sqliteethdb.Database).BlockNumber = testBlockNumber
+	})
+	AfterEach(closeTestDB)
+
+	ethdbtest.DescribeDatabase(func() ethdbtest.Store {
+		return ethdbtest.Store{Database: database, Insert: insert}
+	})
+
+	Describe("Put", func() {
+		It("compresses values the same way as the Postgres ethdbs", func() {
+			value := bytes.Repeat(testValue, 10)
+			database = sqliteethdb.NewDatabaseWithConfig(db, sqliteethdb.Config{Compression: shared.Zstd})
+			database.(*sqliteethdb.Database).BlockNumber = testBlockNumber
+			err = database.Put(testEthKey, value)
+			Expect(err).ToNot(HaveOccurred())
+
+			var stored []byte
+			err = db.Get(&stored, "SELECT data FROM blocks WHERE key = ?", testMhKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(shared.IsCompressed(stored)).To(BeTrue())
+			val, err := database.Get(testEthKey)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(val).To(Equal(value))
+		})
+		It("rejects writes in read-only mode", func() {
+			database = sqliteethdb.NewDatabaseWithConfig(db, sqliteethdb.Config{ReadOnly: true})
+			Expect(database.Put(testEthKey, testValue)).To(MatchError(ipfsethdb.ErrReadOnly))
+			Expect(database.Stat(ipfsethdb.ReadOnlyProperty)).To(Equal("true"))
+		})
+		It("fails writes without a block number", func() {
+			database = sqliteethdb.NewDatabase(db)
+			Expect(database.Put(testEthKey, testValue)).To(MatchError(sqliteethdb.ErrNoBlockNumber))
+			batch := database.NewBatch()
+			Expect(batch.Put(testEthKey, testValue)).To(MatchError(sqliteethdb.ErrNoBlockNumber))
+			Expect(batch.Write()).To(Succeed())
+			Expect(database.Has(testEthKey)).To(BeFalse())
+		})
+	})
+
+})
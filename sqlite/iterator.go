@@ -1,5 +1,5 @@
 // VulcanizeDB
-// Copyright © 2020 Vulcanize
+// Copyright © 2023 Vulcanize
 
 // This program is free software: you can redistribute it and/or modify
 // it under the terms of the GNU Affero General Public License as published by
@@ -14,28 +14,27 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-package pgipfsethdb
+package sqliteethdb
 
 import (
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/jmoiron/sqlx"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/postgres/shared"
 )
 
 var _ ethdb.Iterator = &Iterator{}
 
-// Iterator is the type that satisfies the ethdb.Iterator interface for PG-IPFS Ethereum data using a direct Postgres connection
-// Iteratee interface is used in Geth for various tests, trie/sync_bloom.go (for fast sync),
-// rawdb.InspectDatabase, and the new core/state/snapshot features.
-// This should not be confused with trie.NodeIterator or state.NodeIteraor (which can be constructed
-// from the ethdb.KeyValueStoreand ethdb.Database interfaces)
+// Iterator is the type that satisfies the ethdb.Iterator interface for IPFS Ethereum data stored in SQLite
+// As with the Postgres ethdbs, the keys are stored as multihash db keys rather than the keccak256 hashes go-ethereum
+// iterates over, so Next is not supported and the iterator only reads the value at its start key
 type Iterator struct {
 	db                 *sqlx.DB
 	currentKey, prefix []byte
 	err                error
 }
 
-// NewIterator returns an ethdb.Iterator interface for PG-IPFS
-func NewIterator(start, prefix []byte, db *sqlx.DB) ethdb.Iterator {
+func newIterator(start, prefix []byte, db *sqlx.DB) *Iterator {
 	return &Iterator{
 		db:         db,
 		prefix:     prefix,
@@ -47,9 +46,8 @@ func NewIterator(start, prefix []byte, db *sqlx.DB) ethdb.Iterator {
 // Next moves the iterator to the next key/value pair
 // It returns whether the iterator is exhausted
 func (i *Iterator) Next() bool {
-	// this is complicated by the ipfs db keys not being the keccak256 hashes
-	// go-ethereum usage of this method expects the iteration to occur over keccak256 keys
-	panic("implement me: Next")
+	i.err = errNotSupported
+	return false
 }
 
 // Error satisfies the ethdb.Iterator interface
@@ -72,19 +70,17 @@ func (i *Iterator) Key() []byte {
 // The caller should not modify the contents of the returned slice
 // and its contents may change on the next call to Next
 func (i *Iterator) Value() []byte {
-	mhKey, err := MultihashKeyFromKeccak256(i.currentKey)
+	dbKey, err := KeyCodec.Key(i.currentKey)
 	if err != nil {
 		i.err = err
 		return nil
 	}
 	var data []byte
-	i.err = i.db.Get(&data, getPgStr, mhKey)
-	return data
+	i.err = i.db.Get(&data, getStr, dbKey)
+	return shared.Decompress(data)
 }
 
 // Release satisfies the ethdb.Iterator interface
 // Release releases associated resources
 // Release should always succeed and can be called multiple times without causing error
-func (i *Iterator) Release() {
-	i.db.Close()
-}
+func (i *Iterator) Release() {}
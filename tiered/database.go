@@ -0,0 +1,379 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package tiered keeps a bounded on-disk cache of the values read from an ethdb.Database
+package tiered
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/leveldb"
+	log "github.com/sirupsen/logrus"
+
+	ipfsethdb "github.com/cerc-io/ipfs-ethdb/v5"
+)
+
+// Engine is the on-disk store of the cache
+type Engine int
+
+const (
+	LevelDB Engine = iota
+	Pebble
+)
+
+// CachePrefix routes Stat properties to the cache, e.g. "cache.hits"
+// Properties of the engine can be read through it too, e.g. "cache.leveldb.stats"
+const CachePrefix = "cache."
+
+var (
+	errInvalidConfig = errors.New("cache path and max size must be set")
+
+	DefaultConfig = Config{
+		Engine:  LevelDB,
+		MaxSize: 1 << 30, // 1GB
+		Cache:   16,
+		Handles: 16,
+	}
+)
+
+// Config holds the settings of the on-disk cache
+type Config struct {
+	Engine Engine
+	// Path is the directory of the cache, its contents are reused on restart
+	Path string
+	// MaxSize is the total size, in bytes, of the keys and values kept in the cache
+	// The least recently read entries are evicted beyond it
+	MaxSize int64
+	// Cache and Handles are the memory, in megabytes, and the file handles given to the engine
+	Cache   int
+	Handles int
+}
+
+// EngineFromString helper function
+func EngineFromString(engine string) (Engine, error) {
+	switch strings.ToLower(engine) {
+	case "leveldb":
+		return LevelDB, nil
+	case "pebble":
+		return Pebble, nil
+	default:
+		return LevelDB, fmt.Errorf("unknown cache engine %s", engine)
+	}
+}
+
+// entry is a cached key and the size it accounts for
+type entry struct {
+	key  string
+	size int64
+}
+
+var _ ethdb.Database = &Database{}
+
+// Database is an ethdb.Database that keeps the values read from the wrapped database in a bounded on-disk cache
+// e.g. in front of a pgipfsethdb.Database, so that trie walks are served locally and survive restarts
+// Cached keys keep being served while the wrapped database is unreachable
+// The values are expected to be immutable, as they are for keys that are hashes of their values
+type Database struct {
+	ethdb.Database
+	disk   ethdb.KeyValueStore
+	config Config
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+
+	hits, misses, evictions uint64
+}
+
+// NewDatabase returns an ethdb.Database caching the values read from the provided one on disk
+func NewDatabase(db ethdb.Database, config Config) (*Database, error) {
+	if config.Path == "" || config.MaxSize <= 0 {
+		return nil, errInvalidConfig
+	}
+	var disk ethdb.KeyValueStore
+	var err error
+	switch config.Engine {
+	case LevelDB:
+		disk, err = leveldb.New(config.Path, config.Cache, config.Handles, "", false)
+	case Pebble:
+		disk, err = openPebble(config)
+	default:
+		err = fmt.Errorf("unknown cache engine %d", config.Engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := &Database{
+		Database: db,
+		disk:     disk,
+		config:   config,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	if err := d.load(); err != nil {
+		disk.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// load indexes the entries left in the cache by a previous run, their recency is lost so they are evicted in key order
+func (d *Database) load() error {
+	it := d.disk.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		d.add(string(it.Key()), int64(len(it.Key())+len(it.Value())))
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return d.evict()
+}
+
+// Has satisfies the ethdb.KeyValueReader interface
+// Has retrieves if a key is present in the cache or the wrapped database
+func (d *Database) Has(key []byte) (bool, error) {
+	if has, err := d.disk.Has(key); err == nil && has {
+		return true, nil
+	}
+	return d.Database.Has(key)
+}
+
+// Get satisfies the ethdb.KeyValueReader interface
+// Get retrieves the given key from the cache, or from the wrapped database and adds it to the cache
+func (d *Database) Get(key []byte) ([]byte, error) {
+	if val, ok := d.cached(key); ok {
+		return val, nil
+	}
+	val, err := d.Database.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	d.store(key, val)
+	return val, nil
+}
+
+// GetMany retrieves the values for the given keys from the cache, and those it doesn't have from the wrapped database
+// The returned values are in the same order as the keys, with a nil entry for each key that could not be found
+func (d *Database) GetMany(keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	var missing [][]byte
+	var indices []int
+	for i, key := range keys {
+		if val, ok := d.cached(key); ok {
+			values[i] = val
+			continue
+		}
+		missing = append(missing, key)
+		indices = append(indices, i)
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+	found, err := ipfsethdb.GetMany(d.Database, missing)
+	if err != nil {
+		return nil, err
+	}
+	for j, val := range found {
+		if val == nil {
+			continue
+		}
+		values[indices[j]] = val
+		d.store(missing[j], val)
+	}
+	return values, nil
+}
+
+// Delete satisfies the ethdb.KeyValueWriter interface
+// Delete removes the key from the wrapped database and the cache
+func (d *Database) Delete(key []byte) error {
+	if err := d.Database.Delete(key); err != nil {
+		return err
+	}
+	return d.remove(key)
+}
+
+// NewBatch satisfies the ethdb.Batcher interface
+// NewBatch creates a batch over the wrapped database that evicts deleted keys from the cache when written
+func (d *Database) NewBatch() ethdb.Batch {
+	return &batch{Batch: d.Database.NewBatch(), db: d}
+}
+
+// NewBatchWithSize satisfies the ethdb.Batcher interface
+// NewBatchWithSize creates a batch over the wrapped database with a pre-allocated buffer
+func (d *Database) NewBatchWithSize(size int) ethdb.Batch {
+	return &batch{Batch: d.Database.NewBatchWithSize(size), db: d}
+}
+
+// Stat satisfies the ethdb.Stater interface
+// Stat returns the cache's "cache.hits", "cache.misses", "cache.evictions", "cache.entries" and "cache.size",
+// the engine's properties prefixed with "cache.", and the wrapped database's other properties
+func (d *Database) Stat(property string) (string, error) {
+	if !strings.HasPrefix(property, CachePrefix) {
+		return d.Database.Stat(property)
+	}
+	switch cacheProperty := strings.TrimPrefix(property, CachePrefix); strings.ToLower(cacheProperty) {
+	case "hits":
+		return strconv.FormatUint(atomic.LoadUint64(&d.hits), 10), nil
+	case "misses":
+		return strconv.FormatUint(atomic.LoadUint64(&d.misses), 10), nil
+	case "evictions":
+		return strconv.FormatUint(atomic.LoadUint64(&d.evictions), 10), nil
+	case "entries":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return strconv.Itoa(d.lru.Len()), nil
+	case "size":
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return strconv.FormatInt(d.size, 10), nil
+	default:
+		return d.disk.Stat(cacheProperty)
+	}
+}
+
+// Close satisfies the io.Closer interface
+// Close closes the cache and the wrapped database
+func (d *Database) Close() error {
+	err := d.disk.Close()
+	if dbErr := d.Database.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// cached returns the value of the key if it is in the cache, marking it as recently read
+func (d *Database) cached(key []byte) ([]byte, bool) {
+	val, err := d.disk.Get(key)
+	if err != nil {
+		atomic.AddUint64(&d.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&d.hits, 1)
+	d.mu.Lock()
+	if el, ok := d.entries[string(key)]; ok {
+		d.lru.MoveToFront(el)
+	}
+	d.mu.Unlock()
+	return val, true
+}
+
+// store adds the value to the cache, evicting the least recently read entries beyond the max size
+// The cache is best effort, so failures are logged rather than returned
+func (d *Database) store(key, val []byte) {
+	size := int64(len(key) + len(val))
+	if size > d.config.MaxSize {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.entries[string(key)]; ok {
+		return
+	}
+	if err := d.disk.Put(key, val); err != nil {
+		log.Warnf("caching key %x: %v", key, err)
+		return
+	}
+	d.add(string(key), size)
+	if err := d.evict(); err != nil {
+		log.Warnf("evicting from the cache: %v", err)
+	}
+}
+
+// add indexes an entry as the most recently read, the caller holds the lock
+func (d *Database) add(key string, size int64) {
+	d.entries[key] = d.lru.PushFront(&entry{key: key, size: size})
+	d.size += size
+}
+
+// evict removes the least recently read entries until the cache is within its max size, the caller holds the lock
+func (d *Database) evict() error {
+	for d.size > d.config.MaxSize {
+		el := d.lru.Back()
+		e := el.Value.(*entry)
+		if err := d.disk.Delete([]byte(e.key)); err != nil {
+			return err
+		}
+		d.lru.Remove(el)
+		delete(d.entries, e.key)
+		d.size -= e.size
+		atomic.AddUint64(&d.evictions, 1)
+	}
+	return nil
+}
+
+// remove deletes a key from the cache
+func (d *Database) remove(key []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	el, ok := d.entries[string(key)]
+	if !ok {
+		return nil
+	}
+	if err := d.disk.Delete(key); err != nil {
+		return err
+	}
+	d.lru.Remove(el)
+	delete(d.entries, string(key))
+	d.size -= el.Value.(*entry).size
+	return nil
+}
+
+// batch wraps the wrapped database's batch so that deletes are reflected in the cache
+type batch struct {
+	ethdb.Batch
+	db      *Database
+	deletes [][]byte
+}
+
+// Delete satisfies the ethdb.Batch interface
+// Delete removes the key from the key-value data store
+func (b *batch) Delete(key []byte) error {
+	if err := b.Batch.Delete(key); err != nil {
+		return err
+	}
+	b.deletes = append(b.deletes, append([]byte(nil), key...))
+	return nil
+}
+
+// Write satisfies the ethdb.Batch interface
+// Write flushes any accumulated data to disk and evicts the deleted keys from the cache
+func (b *batch) Write() error {
+	if err := b.Batch.Write(); err != nil {
+		return err
+	}
+	for _, key := range b.deletes {
+		if err := b.db.remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset satisfies the ethdb.Batch interface
+// Reset resets the batch for reuse
+func (b *batch) Reset() {
+	b.Batch.Reset()
+	b.deletes = nil
+}
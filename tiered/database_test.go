@@ -0,0 +1,152 @@
+// VulcanizeDB
+// Copyright © 2023 Vulcanize
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tiered_test
+
+import (
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/tiered"
+)
+
+var (
+	testHeader    = types.Header{Number: big.NewInt(1337)}
+	testValue, _  = rlp.EncodeToBytes(&testHeader)
+	testEthKey    = testHeader.Hash().Bytes()
+	otherHeader   = types.Header{Number: big.NewInt(1338)}
+	otherValue, _ = rlp.EncodeToBytes(&otherHeader)
+	otherEthKey   = otherHeader.Hash().Bytes()
+)
+
+var _ = Describe("Database", func() {
+	var (
+		err      error
+		path     string
+		backing  ethdb.Database
+		config   tiered.Config
+		database *tiered.Database
+	)
+
+	BeforeEach(func() {
+		path, err = os.MkdirTemp("", "tiered")
+		Expect(err).ToNot(HaveOccurred())
+		backing = rawdb.NewMemoryDatabase()
+		Expect(backing.Put(testEthKey, testValue)).To(Succeed())
+		Expect(backing.Put(otherEthKey, otherValue)).To(Succeed())
+		config = tiered.DefaultConfig
+		config.Path = path
+		database, err = tiered.NewDatabase(backing, config)
+		Expect(err).ToNot(HaveOccurred())
+	})
+	AfterEach(func() {
+		database.Close()
+		Expect(os.RemoveAll(path)).To(Succeed())
+	})
+
+	It("rejects a config without a path", func() {
+		_, err := tiered.NewDatabase(backing, tiered.Config{MaxSize: 1})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("caches what it reads and reports hits and misses", func() {
+		val, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		val, err = database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		_, err = database.Get([]byte("missing"))
+		Expect(err).To(HaveOccurred())
+
+		Expect(database.Stat(tiered.CachePrefix + "hits")).To(Equal("1"))
+		Expect(database.Stat(tiered.CachePrefix + "misses")).To(Equal("2"))
+		Expect(database.Stat(tiered.CachePrefix + "entries")).To(Equal("1"))
+		Expect(database.Stat(tiered.CachePrefix + "size")).ToNot(Equal("0"))
+	})
+
+	It("keeps serving cached keys when the wrapped database is unreachable", func() {
+		values, err := database.GetMany([][]byte{testEthKey, []byte("missing")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal([][]byte{testValue, nil}))
+
+		Expect(backing.Close()).To(Succeed())
+		val, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		has, err := database.Has(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(has).To(BeTrue())
+		_, err = database.Get(otherEthKey)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("evicts the least recently read entries beyond the max size", func() {
+		database.Close()
+		config.MaxSize = int64(len(testEthKey) + len(testValue))
+		backing = rawdb.NewMemoryDatabase()
+		Expect(backing.Put(testEthKey, testValue)).To(Succeed())
+		Expect(backing.Put(otherEthKey, otherValue)).To(Succeed())
+		database, err = tiered.NewDatabase(backing, config)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = database.Get(otherEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Stat(tiered.CachePrefix + "evictions")).To(Equal("1"))
+		Expect(database.Stat(tiered.CachePrefix + "entries")).To(Equal("1"))
+
+		Expect(backing.Close()).To(Succeed())
+		_, err = database.Get(testEthKey)
+		Expect(err).To(HaveOccurred())
+		val, err := database.Get(otherEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(otherValue))
+	})
+
+	It("drops deleted keys from the cache", func() {
+		_, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		batch := database.NewBatch()
+		Expect(batch.Delete(testEthKey)).To(Succeed())
+		Expect(batch.Write()).To(Succeed())
+		Expect(database.Stat(tiered.CachePrefix + "entries")).To(Equal("0"))
+		_, err = database.Get(testEthKey)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("reuses the cache across restarts", func() {
+		_, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Close()).To(Succeed())
+
+		database, err = tiered.NewDatabase(rawdb.NewMemoryDatabase(), config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(database.Stat(tiered.CachePrefix + "entries")).To(Equal("1"))
+		val, err := database.Get(testEthKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(val).To(Equal(testValue))
+		Expect(database.Stat(tiered.CachePrefix + "hits")).To(Equal("1"))
+	})
+})
@@ -17,27 +17,57 @@
 package ipfsethdb
 
 import (
+	"github.com/ethereum/go-ethereum/ethdb"
 	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	_ "github.com/lib/pq" //postgres driver
-	"github.com/multiformats/go-multihash"
+
+	"github.com/cerc-io/ipfs-ethdb/v5/keycodec"
 )
 
 // Keccak256ToCid takes a keccak256 hash and returns its cid v1 using the provided codec.
 func Keccak256ToCid(h []byte, codec uint64) (cid.Cid, error) {
-	buf, err := multihash.Encode(h, multihash.KECCAK_256)
-	if err != nil {
-		return cid.Cid{}, err
-	}
-	return cid.NewCidV1(codec, buf), nil
+	return keycodec.Multihash{Codec: codec}.CID(h)
 }
 
 // NewBlock takes a keccak256 hash key and the rlp []byte value it was derived from and creates an ipfs block object
 func NewBlock(key, value []byte) (blocks.Block, error) {
-	// we are using state codec because we don't know the codec and at this level the codec doesn't matter, the datastore key is multihash-only derived
-	c, err := Keccak256ToCid(key, cid.EthStateTrie)
+	return newBlock(DefaultKeyCodec, key, value)
+}
+
+// newBlock creates an ipfs block object with the CID the KeyCodec derives from the key
+func newBlock(codec keycodec.KeyCodec, key, value []byte) (blocks.Block, error) {
+	c, err := codec.CID(key)
 	if err != nil {
 		return nil, err
 	}
 	return blocks.NewBlockWithCid(value, c)
 }
+
+// MultiGetter is satisfied by the stores that can fetch several keys in a single round trip
+// e.g. pgipfsethdb.Database and ipfsethdb.Database
+type MultiGetter interface {
+	GetMany(keys [][]byte) ([][]byte, error)
+}
+
+// GetMany looks the keys up in a single round trip if the store is a MultiGetter, and otherwise key by key
+// The values are returned in the order of the keys, with a nil value for each missing key
+func GetMany(db ethdb.KeyValueReader, keys [][]byte) ([][]byte, error) {
+	if mg, ok := db.(MultiGetter); ok {
+		return mg.GetMany(keys)
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		has, err := db.Has(key)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			continue
+		}
+		if values[i], err = db.Get(key); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}